@@ -0,0 +1,132 @@
+// Package authz はグループの中で誰が何をできるかを決める｡
+// ハンドラーは役割を直接比べずに､ここで許されているか確かめる
+package authz
+
+import (
+	"errors"
+	"slices"
+)
+
+// Role はグループの中での役割｡group_members.role に保存する
+type Role string
+
+const (
+	// RoleOwner はグループに1人だけいて､削除や役割の変更もできる
+	RoleOwner Role = "owner"
+	// RoleOrganiser はオーナーに任された幹事｡削除と役割の変更以外はオーナーと同じことができる
+	RoleOrganiser Role = "organiser"
+	// RoleMember は注文や立て替え､チャットをする普通のメンバー
+	RoleMember Role = "member"
+	// RoleViewer は見るだけのメンバー｡注文や投稿はできず､費用も分担しない
+	RoleViewer Role = "viewer"
+)
+
+// Action はグループに対する操作
+type Action string
+
+const (
+	// ViewGroup はメニューやチャット､精算などグループの中を見る
+	ViewGroup Action = "view_group"
+	// EditGroup は名前や定員､締め切り､受付の状態を変える
+	EditGroup   Action = "edit_group"
+	DeleteGroup Action = "delete_group"
+	// LeaveGroup はグループを抜ける｡オーナーが抜けると管理する人がいなくなるので許さない
+	LeaveGroup Action = "leave_group"
+	// ManageRoles はメンバーの役割を変えたりオーナーを譲ったりする
+	ManageRoles Action = "manage_roles"
+	// EditMenu は料理やメニューの画像を変える
+	EditMenu Action = "edit_menu"
+	// PlaceOrder は自分の注文をする､取り消す
+	PlaceOrder Action = "place_order"
+	// ViewOrderSummary はメンバー全員の注文の集計を見る
+	ViewOrderSummary Action = "view_order_summary"
+	// PostMessage はチャットに投稿する｡編集と削除は自分の投稿だけ
+	PostMessage Action = "post_message"
+	// RecordExpense は立て替えを記録する｡編集と削除は記録した人か立て替えた人だけ
+	RecordExpense Action = "record_expense"
+	// EditAnyExpense は他の人が記録した立て替えも直す
+	EditAnyExpense Action = "edit_any_expense"
+	// RecordPayment は自分の支払いを済ませたり､自分が受け取った支払いを確認したりする
+	RecordPayment Action = "record_payment"
+	// ManagePayments は全員の支払い状況を見て､他の人が受け取った支払いも確認する
+	ManagePayments Action = "manage_payments"
+	// EditShares はメンバーの負担の割合や固定額を変える
+	EditShares Action = "edit_shares"
+	// ViewHistory は誰がいつ参加や役割の変更､支払いをしたかの記録を見る
+	ViewHistory Action = "view_history"
+)
+
+var (
+	ErrInvalidRole = errors.New("role must be one of owner, organiser, member or viewer")
+	// ErrNotMember はユーザーがグループのメンバーでない
+	ErrNotMember = errors.New("user is not a member of the group")
+	// ErrForbidden はメンバーだが役割では許されていない
+	ErrForbidden = errors.New("role does not allow the action")
+)
+
+// permissions は役割ごとに許す操作｡ここにない組み合わせはすべて拒否する
+var permissions = map[Role][]Action{
+	RoleOwner: {
+		ViewGroup, EditGroup, DeleteGroup, ManageRoles, EditMenu, PlaceOrder, ViewOrderSummary,
+		PostMessage, RecordExpense, EditAnyExpense, RecordPayment, ManagePayments, EditShares, ViewHistory,
+	},
+	RoleOrganiser: {
+		ViewGroup, EditGroup, LeaveGroup, EditMenu, PlaceOrder, ViewOrderSummary,
+		PostMessage, RecordExpense, EditAnyExpense, RecordPayment, ManagePayments, EditShares,
+	},
+	RoleMember: {
+		ViewGroup, LeaveGroup, PlaceOrder, PostMessage, RecordExpense, RecordPayment,
+	},
+	RoleViewer: {
+		ViewGroup, LeaveGroup,
+	},
+}
+
+// ParseRole は文字列を役割にする｡知らない役割なら ErrInvalidRole を返す
+func ParseRole(value string) (Role, error) {
+	role := Role(value)
+	if _, ok := permissions[role]; !ok {
+		return "", ErrInvalidRole
+	}
+	return role, nil
+}
+
+// Can は役割 r で action が許されているか返す
+func (r Role) Can(action Action) bool {
+	return slices.Contains(permissions[r], action)
+}
+
+// RoleStore はグループでのユーザーの役割を返す｡メンバーでなければ ok=false
+type RoleStore interface {
+	GetMemberRole(groupID, userID int64) (Role, bool, error)
+}
+
+// Check はユーザーがグループで action をしてよいか確かめて役割を返す｡
+// メンバーでなければ ErrNotMember､役割で許されていなければ ErrForbidden を返す
+func Check(store RoleStore, groupID, userID int64, action Action) (Role, error) {
+	role, ok, err := store.GetMemberRole(groupID, userID)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrNotMember
+	}
+	if !role.Can(action) {
+		return role, ErrForbidden
+	}
+
+	return role, nil
+}
+
+// Can はユーザーがグループで action をしてよいか返す｡メンバーでなければ false
+func Can(store RoleStore, groupID, userID int64, action Action) (bool, error) {
+	_, err := Check(store, groupID, userID, action)
+	if errors.Is(err, ErrNotMember) || errors.Is(err, ErrForbidden) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,83 @@
+package authz
+
+import (
+	"errors"
+	"testing"
+)
+
+type fakeRoleStore map[int64]Role
+
+func (s fakeRoleStore) GetMemberRole(groupID, userID int64) (Role, bool, error) {
+	role, ok := s[userID]
+	return role, ok, nil
+}
+
+func TestRoleCan(t *testing.T) {
+	tests := []struct {
+		role    Role
+		action  Action
+		allowed bool
+	}{
+		{RoleOwner, EditMenu, true},
+		{RoleOwner, DeleteGroup, true},
+		{RoleOwner, ManageRoles, true},
+		{RoleOwner, LeaveGroup, false},
+		{RoleOwner, ViewHistory, true},
+		{RoleOrganiser, EditMenu, true},
+		{RoleOrganiser, EditGroup, true},
+		{RoleOrganiser, ManagePayments, true},
+		{RoleOrganiser, DeleteGroup, false},
+		{RoleOrganiser, ManageRoles, false},
+		{RoleOrganiser, LeaveGroup, true},
+		{RoleOrganiser, ViewHistory, false},
+		{RoleMember, PlaceOrder, true},
+		{RoleMember, PostMessage, true},
+		{RoleMember, EditMenu, false},
+		{RoleMember, EditAnyExpense, false},
+		{RoleViewer, ViewGroup, true},
+		{RoleViewer, PlaceOrder, false},
+		{RoleViewer, PostMessage, false},
+		{Role("admin"), ViewGroup, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.role.Can(tt.action); got != tt.allowed {
+			t.Errorf("%s.Can(%s) = %v, want %v", tt.role, tt.action, got, tt.allowed)
+		}
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for _, value := range []string{"owner", "organiser", "member", "viewer"} {
+		if role, err := ParseRole(value); err != nil || string(role) != value {
+			t.Errorf("ParseRole(%q) = %q, %v", value, role, err)
+		}
+	}
+
+	for _, value := range []string{"", "Owner", "organizer", "admin"} {
+		if _, err := ParseRole(value); !errors.Is(err, ErrInvalidRole) {
+			t.Errorf("ParseRole(%q) error = %v, want ErrInvalidRole", value, err)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	store := fakeRoleStore{1: RoleOwner, 2: RoleMember}
+
+	if role, err := Check(store, 10, 1, EditMenu); err != nil || role != RoleOwner {
+		t.Errorf("Check(owner, EditMenu) = %q, %v", role, err)
+	}
+	if _, err := Check(store, 10, 2, EditMenu); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Check(member, EditMenu) error = %v, want ErrForbidden", err)
+	}
+	if _, err := Check(store, 10, 3, ViewGroup); !errors.Is(err, ErrNotMember) {
+		t.Errorf("Check(non-member, ViewGroup) error = %v, want ErrNotMember", err)
+	}
+
+	if ok, err := Can(store, 10, 2, PlaceOrder); err != nil || !ok {
+		t.Errorf("Can(member, PlaceOrder) = %v, %v", ok, err)
+	}
+	if ok, err := Can(store, 10, 3, ViewGroup); err != nil || ok {
+		t.Errorf("Can(non-member, ViewGroup) = %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,312 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"domeal/jobs"
+	"domeal/line"
+	"domeal/middleware"
+	"domeal/model"
+	"domeal/realtime"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+type AccountController struct {
+	repo   model.AccountInterface
+	jobs   jobs.Enqueuer
+	events realtime.Publisher
+}
+
+func NewAccountController(repo model.AccountInterface, jobs jobs.Enqueuer, events realtime.Publisher) *AccountController {
+	return &AccountController{
+		repo:   repo,
+		jobs:   jobs,
+		events: events,
+	}
+}
+
+// ExportMeHandler はログインユーザーの個人データをJSONでダウンロードさせます
+func (c *AccountController) ExportMeHandler(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	// 読み取りだけのトランザクションで､すべてのテーブルを同じ時点で読む
+	tx, err := c.repo.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	export, err := c.repo.ExportUserData(tx, userID)
+	if err != nil {
+		slog.Error("Failed to export user data", "error", err)
+		http.Error(w, "Failed to export user data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="domeal-export-%d.json"`, userID))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(export); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("User data exported", "user_id", userID)
+}
+
+// DeleteMeHandler はログインユーザーを退会させます｡
+// オーナーのグループは他のメンバーに引き継ぎ､誰もいなければ削除します｡
+// 締め切る前のグループからは抜け､空いた枠には待ちリストから繰り上げます｡
+// 精算やチャットの記録は他のメンバーのものでもあるので､ユーザーの行は消さずに匿名化します
+func (c *AccountController) DeleteMeHandler(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := c.repo.LockUser(tx, userID); err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("User is already deleted", "user_id", userID)
+			http.Error(w, "User is already deleted", http.StatusGone)
+			return
+		}
+		slog.Error("Failed to lock user", "error", err)
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	pending, err := c.handOverGroups(tx, userID)
+	if err != nil {
+		slog.Error("Failed to hand over groups", "error", err)
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	left, err := c.leaveOpenGroups(tx, userID)
+	if err != nil {
+		slog.Error("Failed to leave groups", "error", err)
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+	pending = append(pending, left...)
+
+	if err := recordEvents(tx, c.events, pending...); err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	// LINE との連携はコミットしてからジョブで解除する
+	accessToken, err := c.repo.DeleteUserToken(tx, userID)
+	switch {
+	case err == sql.ErrNoRows:
+		slog.Warn("User has no LINE token to revoke", "user_id", userID)
+	case err != nil:
+		slog.Error("Failed to delete LINE token", "error", err)
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	default:
+		if err := line.ScheduleRevoke(c.jobs, tx, userID, accessToken); err != nil {
+			slog.Error("Failed to schedule LINE token revocation", "error", err)
+			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	revoked, err := c.repo.DeleteUserSessions(tx, userID)
+	if err != nil {
+		slog.Error("Failed to delete sessions", "error", err)
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	audits := []*model.AuditEvent{
+		model.NewAuditEvent(model.AuditActionSessionsRevoked, userID, 0, userID, map[string]any{
+			"count": revoked,
+		}),
+		model.NewAuditEvent(model.AuditActionAccountDeleted, userID, 0, userID, nil),
+	}
+	for _, audit := range audits {
+		if err := c.repo.AppendAuditEvent(tx, audit); err != nil {
+			slog.Error("Failed to append audit event", "error", err)
+			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := c.repo.AnonymizeUser(tx, userID); err != nil {
+		slog.Error("Failed to anonymize user", "error", err)
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	// Cookieを削除
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_id",
+		Value:    "",
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+		MaxAge:   -1, // 削除
+	})
+	w.WriteHeader(http.StatusNoContent)
+
+	slog.Info("Account deleted", "user_id", userID)
+}
+
+// handOverGroups はオーナーのグループを幹事や一番先に参加したメンバーに引き継ぐ｡
+// 他に退会していないメンバーがいなければグループを削除する｡ルームに流すイベントを返す
+func (c *AccountController) handOverGroups(tx *sql.Tx, userID int64) ([]realtime.Event, error) {
+	groups, err := c.repo.ListOwnedGroups(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []realtime.Event
+	for _, group := range groups {
+		nextOwnerID, found, err := c.repo.FindNextOwner(tx, group.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !found {
+			if err := c.repo.SoftDeleteGroup(tx, group.ID); err != nil {
+				return nil, err
+			}
+			err = c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionGroupDeleted, userID, group.ID, 0, map[string]any{
+				"reason": "owner_deleted_account",
+			}))
+			if err != nil {
+				return nil, err
+			}
+			pending = append(pending, realtime.NewEvent(realtime.EventGroupDeleted, group.ID, nil))
+			slog.Info("Group deleted because its owner left", "group_id", group.ID, "user_id", userID)
+			continue
+		}
+
+		if _, err := c.repo.TransferGroupOwnership(tx, group.ID, userID, nextOwnerID); err != nil {
+			return nil, err
+		}
+		// 締め切ったグループには精算のために残るが､退会したユーザーを幹事にはしておかない
+		if _, err := c.repo.UpdateMemberRole(tx, group.ID, userID, authz.RoleMember); err != nil {
+			return nil, err
+		}
+
+		err = c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionMemberRoleChanged, userID, group.ID, nextOwnerID, map[string]any{
+			"role":   authz.RoleOwner,
+			"reason": "owner_deleted_account",
+		}))
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending,
+			realtime.NewEvent(realtime.EventMemberRoleChanged, group.ID, realtime.RolePayload{
+				UserID: nextOwnerID,
+				Role:   string(authz.RoleOwner),
+			}),
+			realtime.NewEvent(realtime.EventMemberRoleChanged, group.ID, realtime.RolePayload{
+				UserID: userID,
+				Role:   string(authz.RoleMember),
+			}),
+		)
+
+		groupID := group.ID
+		err = c.repo.CreateNotification(tx, &model.Notification{
+			UserID:  nextOwnerID,
+			GroupID: &groupID,
+			Kind:    model.NotificationKindOwnershipTransferred,
+			Message: fmt.Sprintf("グループ「%s」のオーナーが退会したため､オーナーを引き継ぎました", group.Name),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		slog.Info("Group ownership transferred", "group_id", group.ID, "from_user_id", userID, "to_user_id", nextOwnerID)
+	}
+
+	return pending, nil
+}
+
+// leaveOpenGroups は締め切る前のグループからユーザーを抜き､空いた枠に待ちリストから繰り上げる｡
+// 締め切ったグループは精算の記録に必要なのでメンバーのまま残す｡ルームに流すイベントを返す
+func (c *AccountController) leaveOpenGroups(tx *sql.Tx, userID int64) ([]realtime.Event, error) {
+	groups, err := c.repo.ListMemberGroups(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []realtime.Event
+	for i := range groups {
+		group := &groups[i]
+		if group.Status != model.GroupStatusOpen {
+			continue
+		}
+
+		removed, err := c.repo.RemoveGroupMember(tx, group.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !removed {
+			continue
+		}
+
+		err = c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionMemberLeft, userID, group.ID, userID, map[string]any{
+			"reason": "account_deleted",
+		}))
+		if err != nil {
+			return nil, err
+		}
+
+		promotedUserIDs, err := promoteFromWaitlist(tx, c.repo, group)
+		if err != nil {
+			return nil, err
+		}
+
+		pending = append(pending, realtime.NewEvent(realtime.EventMemberLeft, group.ID, realtime.MemberPayload{
+			UserID: userID,
+		}))
+		for _, promotedUserID := range promotedUserIDs {
+			pending = append(pending, realtime.NewEvent(realtime.EventMemberPromoted, group.ID, realtime.MemberPayload{
+				UserID: promotedUserID,
+			}))
+		}
+	}
+
+	return pending, nil
+}
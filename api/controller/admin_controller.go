@@ -0,0 +1,718 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"domeal/middleware"
+	"domeal/model"
+	"domeal/notify"
+	"domeal/realtime"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultAdminPageSize = 50
+	maxAdminPageSize     = 100
+	// maxAdminReasonLength は操作の理由の長さの上限
+	maxAdminReasonLength = 500
+)
+
+// AdminController は運営者が本番のデータを調べたり直したりするためのAPI｡
+// ルーターで AdminMiddleware を通し､すべての操作を admin_actions に記録する
+type AdminController struct {
+	repo          model.AdminInterface
+	events        realtime.Publisher
+	notifications notify.Enqueuer
+}
+
+func NewAdminController(repo model.AdminInterface, events realtime.Publisher, notifications notify.Enqueuer) *AdminController {
+	return &AdminController{
+		repo:          repo,
+		events:        events,
+		notifications: notifications,
+	}
+}
+
+// AdminActionRequest は変更を伴う操作のボディ｡省略できる
+type AdminActionRequest struct {
+	Reason string `json:"reason"`
+}
+
+type AdminRevokeSessionsResponse struct {
+	UserID          int64 `json:"user_id"`
+	RevokedSessions int64 `json:"revoked_sessions"`
+}
+
+// ListAdminActionsResponse の next_before を before に渡すと続きを読める｡続きがなければ null
+type ListAdminActionsResponse struct {
+	Actions    []model.AdminAction `json:"actions"`
+	NextBefore *int64              `json:"next_before"`
+}
+
+// SearchUsersController は ?q= でユーザーを探します｡ID､表示名､LINE の名前､メールアドレスに一致します
+func (c *AdminController) SearchUsersController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	adminID := int64(tmpUser.ID)
+
+	query := r.URL.Query().Get("q")
+	fieldErrors := map[string]string{}
+	limit := parseAdminLimit(r, fieldErrors)
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	// 個人情報を見るので､検索する前に記録する
+	if err := c.logAccess(adminID, model.AdminActionSearchUsers, map[string]any{"q": query}); err != nil {
+		slog.Error("Failed to record admin action", "error", err)
+		http.Error(w, "Failed to search users", http.StatusInternalServerError)
+		return
+	}
+
+	users, err := c.repo.SearchUsers(query, limit)
+	if err != nil {
+		slog.Error("Failed to search users", "error", err)
+		http.Error(w, "Failed to search users", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(users); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SearchGroupsController は ?q= でグループを探します｡?status= に open､closed､deleted を指定して絞り込めます
+func (c *AdminController) SearchGroupsController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	adminID := int64(tmpUser.ID)
+
+	query := r.URL.Query().Get("q")
+	status := r.URL.Query().Get("status")
+	fieldErrors := map[string]string{}
+	switch status {
+	case "", model.GroupStatusOpen, model.GroupStatusClosed, model.GroupStatusDeleted:
+	default:
+		fieldErrors["status"] = fmt.Sprintf("must be %q, %q or %q", model.GroupStatusOpen, model.GroupStatusClosed, model.GroupStatusDeleted)
+	}
+	limit := parseAdminLimit(r, fieldErrors)
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	if err := c.logAccess(adminID, model.AdminActionSearchGroups, map[string]any{"q": query, "status": status}); err != nil {
+		slog.Error("Failed to record admin action", "error", err)
+		http.Error(w, "Failed to search groups", http.StatusInternalServerError)
+		return
+	}
+
+	groups, err := c.repo.SearchGroups(query, status, limit)
+	if err != nil {
+		slog.Error("Failed to search groups", "error", err)
+		http.Error(w, "Failed to search groups", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(groups); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// CloseGroupController は受付中のグループを運営者が締め切ります｡締め切ったときと同じくメンバーに知らせます
+func (c *AdminController) CloseGroupController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	adminID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	reason, ok := decodeAdminReason(w, r)
+	if !ok {
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	group, err := c.repo.LockGroup(tx, groupID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Group not found", "group_id", groupID)
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to lock group", "error", err)
+		http.Error(w, "Failed to close group", http.StatusInternalServerError)
+		return
+	}
+
+	if group.Status == model.GroupStatusClosed {
+		slog.Error("Group is already closed", "group_id", groupID)
+		http.Error(w, "Group is already closed", http.StatusConflict)
+		return
+	}
+
+	group.Status = model.GroupStatusClosed
+	if err := c.repo.UpdateGroup(tx, group); err != nil {
+		slog.Error("Failed to update group", "error", err)
+		http.Error(w, "Failed to close group", http.StatusInternalServerError)
+		return
+	}
+
+	memberIDs, err := c.repo.ListGroupMemberIDs(tx, groupID)
+	if err != nil {
+		slog.Error("Failed to list group members", "error", err)
+		http.Error(w, "Failed to close group", http.StatusInternalServerError)
+		return
+	}
+
+	for _, memberID := range memberIDs {
+		err = c.repo.CreateNotification(tx, &model.Notification{
+			UserID:  memberID,
+			GroupID: &groupID,
+			Kind:    model.NotificationKindGroupClosed,
+			Message: fmt.Sprintf("グループ「%s」は運営によって募集を終了しました", group.Name),
+		})
+		if err != nil {
+			slog.Error("Failed to create notification", "error", err, "user_id", memberID)
+			http.Error(w, "Failed to close group", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// 締め切ったら精算額が決まるので､支払う側に知らせる
+	if err := notify.EnqueuePaymentDue(tx, c.notifications, c.repo, group); err != nil {
+		slog.Error("Failed to enqueue payment due notifications", "error", err)
+		http.Error(w, "Failed to close group", http.StatusInternalServerError)
+		return
+	}
+
+	pending := []realtime.Event{
+		realtime.NewEvent(realtime.EventGroupUpdated, groupID, group),
+		realtime.NewEvent(realtime.EventGroupStatusChanged, groupID, realtime.StatusPayload{
+			Status: group.Status,
+		}),
+	}
+	if err := recordEvents(tx, c.events, pending...); err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to close group", http.StatusInternalServerError)
+		return
+	}
+
+	if err := c.recordAction(tx, adminID, model.AdminActionCloseGroup, model.AdminTargetGroup, groupID, map[string]any{"reason": reason}); err != nil {
+		slog.Error("Failed to record admin action", "error", err)
+		http.Error(w, "Failed to close group", http.StatusInternalServerError)
+		return
+	}
+
+	err = c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionGroupUpdated, adminID, groupID, 0, map[string]any{
+		"fields":          []string{"status"},
+		"previous_status": model.GroupStatusOpen,
+		"status":          group.Status,
+		"by_admin":        true,
+	}))
+	if err != nil {
+		slog.Error("Failed to append audit event", "error", err)
+		http.Error(w, "Failed to close group", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(group); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Group closed by admin", "group_id", groupID, "admin_user_id", adminID)
+}
+
+// DeleteGroupController は運営者がグループを論理削除します｡メンバーと待ちリストのユーザーに知らせます
+func (c *AdminController) DeleteGroupController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	adminID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	reason, ok := decodeAdminReason(w, r)
+	if !ok {
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	group, err := c.repo.LockGroup(tx, groupID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Group not found", "group_id", groupID)
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to lock group", "error", err)
+		http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+		return
+	}
+
+	// 行は消さずに論理削除する
+	if err := c.repo.SoftDeleteGroup(tx, groupID); err != nil {
+		slog.Error("Failed to delete group", "error", err)
+		http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+		return
+	}
+
+	memberIDs, err := c.repo.ListGroupMemberIDs(tx, groupID)
+	if err != nil {
+		slog.Error("Failed to list group members", "error", err)
+		http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+		return
+	}
+
+	waitlistUserIDs, err := c.repo.ListWaitlistUserIDs(tx, groupID)
+	if err != nil {
+		slog.Error("Failed to list waitlist", "error", err)
+		http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+		return
+	}
+
+	for _, memberID := range append(memberIDs, waitlistUserIDs...) {
+		err = c.repo.CreateNotification(tx, &model.Notification{
+			UserID:  memberID,
+			GroupID: &groupID,
+			Kind:    model.NotificationKindGroupDeleted,
+			Message: fmt.Sprintf("グループ「%s」は運営によって削除されました", group.Name),
+		})
+		if err != nil {
+			slog.Error("Failed to create notification", "error", err, "user_id", memberID)
+			http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	pending := []realtime.Event{
+		realtime.NewEvent(realtime.EventGroupDeleted, groupID, nil),
+	}
+	if err := recordEvents(tx, c.events, pending...); err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+		return
+	}
+
+	if err := c.recordAction(tx, adminID, model.AdminActionDeleteGroup, model.AdminTargetGroup, groupID, map[string]any{"reason": reason}); err != nil {
+		slog.Error("Failed to record admin action", "error", err)
+		http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+		return
+	}
+
+	err = c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionGroupDeleted, adminID, groupID, 0, map[string]any{
+		"by_admin": true,
+	}))
+	if err != nil {
+		slog.Error("Failed to append audit event", "error", err)
+		http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	slog.Info("Group deleted by admin", "group_id", groupID, "admin_user_id", adminID)
+}
+
+// RevokeSessionsController はユーザーのすべての端末をログアウトさせます｡乗っ取られたときなどに使います
+func (c *AdminController) RevokeSessionsController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	adminID := int64(tmpUser.ID)
+
+	userID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid user ID", "error", err)
+		http.Error(w, "Valid user ID is required", http.StatusBadRequest)
+		return
+	}
+
+	reason, ok := decodeAdminReason(w, r)
+	if !ok {
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// 退会したユーザーにはセッションがないので､見つからないものとして扱う
+	if err := c.repo.LockUser(tx, userID); err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("User not found", "user_id", userID)
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to lock user", "error", err)
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	revoked, err := c.repo.DeleteUserSessions(tx, userID)
+	if err != nil {
+		slog.Error("Failed to delete sessions", "error", err)
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	detail := map[string]any{"reason": reason, "revoked_sessions": revoked}
+	if err := c.recordAction(tx, adminID, model.AdminActionRevokeSessions, model.AdminTargetUser, userID, detail); err != nil {
+		slog.Error("Failed to record admin action", "error", err)
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	err = c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionSessionsRevoked, adminID, 0, userID, map[string]any{
+		"count":    revoked,
+		"by_admin": true,
+	}))
+	if err != nil {
+		slog.Error("Failed to append audit event", "error", err)
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	response := AdminRevokeSessionsResponse{
+		UserID:          userID,
+		RevokedSessions: revoked,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Sessions revoked by admin", "user_id", userID, "revoked_sessions", revoked, "admin_user_id", adminID)
+}
+
+// ListAdminActionsController は運営者の操作の記録を新しい順に返します｡
+// ?admin_user_id=､?target_type=&target_id= で絞り込み､?before=<id>&limit=<n> でさかのぼれます
+func (c *AdminController) ListAdminActionsController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	adminID := int64(tmpUser.ID)
+
+	query := r.URL.Query()
+	fieldErrors := map[string]string{}
+	filter := model.AdminActionFilter{
+		AdminUserID: parseAdminID(query.Get("admin_user_id"), "admin_user_id", fieldErrors),
+		TargetType:  query.Get("target_type"),
+		TargetID:    parseAdminID(query.Get("target_id"), "target_id", fieldErrors),
+		BeforeID:    parseAdminID(query.Get("before"), "before", fieldErrors),
+		Limit:       parseAdminLimit(r, fieldErrors),
+	}
+	switch filter.TargetType {
+	case "", model.AdminTargetUser, model.AdminTargetGroup:
+	default:
+		fieldErrors["target_type"] = fmt.Sprintf("must be %q or %q", model.AdminTargetUser, model.AdminTargetGroup)
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	if err := c.logAccess(adminID, model.AdminActionViewActions, map[string]any{"query": r.URL.RawQuery}); err != nil {
+		slog.Error("Failed to record admin action", "error", err)
+		http.Error(w, "Failed to list admin actions", http.StatusInternalServerError)
+		return
+	}
+
+	// 1件多く読んで続きがあるかを判断する
+	limit := filter.Limit
+	filter.Limit++
+	actions, err := c.repo.ListAdminActions(filter)
+	if err != nil {
+		slog.Error("Failed to list admin actions", "error", err)
+		http.Error(w, "Failed to list admin actions", http.StatusInternalServerError)
+		return
+	}
+
+	response := ListAdminActionsResponse{
+		Actions: actions,
+	}
+	if len(actions) > limit {
+		response.Actions = actions[:limit]
+		response.NextBefore = &response.Actions[limit-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// ListAuditEventsController はユーザーやグループの操作の記録を新しい順に返します｡
+// ?actor_user_id=､?group_id=､?subject_user_id=､?action=､?since=&until=(RFC 3339) で絞り込み､
+// ?before=<id>&limit=<n> でさかのぼれます
+func (c *AdminController) ListAuditEventsController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	adminID := int64(tmpUser.ID)
+
+	query := r.URL.Query()
+	fieldErrors := map[string]string{}
+	filter := model.AuditEventFilter{
+		ActorUserID:   parseAdminID(query.Get("actor_user_id"), "actor_user_id", fieldErrors),
+		GroupID:       parseAdminID(query.Get("group_id"), "group_id", fieldErrors),
+		SubjectUserID: parseAdminID(query.Get("subject_user_id"), "subject_user_id", fieldErrors),
+		Action:        query.Get("action"),
+		Since:         parseAdminTime(query.Get("since"), "since", fieldErrors),
+		Until:         parseAdminTime(query.Get("until"), "until", fieldErrors),
+		BeforeID:      parseAdminID(query.Get("before"), "before", fieldErrors),
+		Limit:         parseAdminLimit(r, fieldErrors),
+	}
+	if filter.Since != nil && filter.Until != nil && !filter.Since.Before(*filter.Until) {
+		fieldErrors["until"] = "must be after since"
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	if err := c.logAccess(adminID, model.AdminActionViewAuditEvents, map[string]any{"query": r.URL.RawQuery}); err != nil {
+		slog.Error("Failed to record admin action", "error", err)
+		http.Error(w, "Failed to list audit events", http.StatusInternalServerError)
+		return
+	}
+
+	// 1件多く読んで続きがあるかを判断する
+	limit := filter.Limit
+	filter.Limit++
+	events, err := c.repo.ListAuditEvents(filter)
+	if err != nil {
+		slog.Error("Failed to list audit events", "error", err)
+		http.Error(w, "Failed to list audit events", http.StatusInternalServerError)
+		return
+	}
+
+	response := ListAuditEventsResponse{
+		Events: events,
+	}
+	if len(events) > limit {
+		response.Events = events[:limit]
+		response.NextBefore = &response.Events[limit-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// recordAction は運営者の操作を変更と同じトランザクションで記録する
+func (c *AdminController) recordAction(tx *sql.Tx, adminID int64, action, targetType string, targetID int64, detail map[string]any) error {
+	data, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+
+	return c.repo.CreateAdminAction(tx, &model.AdminAction{
+		AdminUserID: adminID,
+		Action:      action,
+		TargetType:  targetType,
+		TargetID:    &targetID,
+		Detail:      data,
+	})
+}
+
+// logAccess は検索など変更を伴わない操作を記録する｡記録できなければ見せない
+func (c *AdminController) logAccess(adminID int64, action string, detail map[string]any) error {
+	data, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = c.repo.CreateAdminAction(tx, &model.AdminAction{
+		AdminUserID: adminID,
+		Action:      action,
+		Detail:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// decodeAdminReason は省略できるボディから操作の理由を読む｡
+// 読めなければエラーレスポンスを書き込んで false を返す
+func decodeAdminReason(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req AdminActionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.Error("Failed to decode request body", "error", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return "", false
+		}
+	}
+
+	if utf8.RuneCountInString(req.Reason) > maxAdminReasonLength {
+		writeValidationErrors(w, map[string]string{
+			"reason": fmt.Sprintf("must be at most %d characters", maxAdminReasonLength),
+		})
+		return "", false
+	}
+
+	return req.Reason, true
+}
+
+// parseAdminLimit はクエリの limit を読む｡不正なら fieldErrors に書き込む
+func parseAdminLimit(r *http.Request, fieldErrors map[string]string) int {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultAdminPageSize
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 || limit > maxAdminPageSize {
+		fieldErrors["limit"] = fmt.Sprintf("must be between 1 and %d", maxAdminPageSize)
+	}
+	return limit
+}
+
+// parseAdminTime は省略できる RFC 3339 の日時のクエリを読む｡省略なら nil､不正なら fieldErrors に書き込む
+func parseAdminTime(value, name string, fieldErrors map[string]string) *time.Time {
+	if value == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		fieldErrors[name] = "must be an RFC 3339 timestamp"
+		return nil
+	}
+	return &t
+}
+
+// parseAdminID は省略できるIDのクエリを読む｡省略なら 0､不正なら fieldErrors に書き込む
+func parseAdminID(value, name string, fieldErrors map[string]string) int64 {
+	if value == "" {
+		return 0
+	}
+
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id <= 0 {
+		fieldErrors[name] = "must be a valid ID"
+	}
+	return id
+}
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"domeal/authz"
+	"domeal/middleware"
+	"domeal/model"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultHistoryPageSize = 50
+	maxHistoryPageSize     = 100
+)
+
+type AuditController struct {
+	repo model.AuditInterface
+}
+
+func NewAuditController(repo model.AuditInterface) *AuditController {
+	return &AuditController{
+		repo: repo,
+	}
+}
+
+// ListAuditEventsResponse の next_before を before に渡すと続きを読める｡続きがなければ null
+type ListAuditEventsResponse struct {
+	Events     []model.AuditEvent `json:"events"`
+	NextBefore *int64             `json:"next_before"`
+}
+
+// GroupHistoryController はグループでの参加や役割の変更､支払いなどの記録を新しい順に返します｡
+// オーナーだけが見られます｡?before=<id>&limit=<n> でさかのぼれます
+func (c *AuditController) GroupHistoryController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	fieldErrors := map[string]string{}
+	var beforeID int64
+	if v := r.URL.Query().Get("before"); v != "" {
+		beforeID, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || beforeID <= 0 {
+			fieldErrors["before"] = "must be a valid event ID"
+		}
+	}
+
+	limit := defaultHistoryPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxHistoryPageSize {
+			fieldErrors["limit"] = "must be between 1 and 100"
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.ViewHistory) {
+		return
+	}
+
+	// 1件多く読んで続きがあるかを判断する
+	events, err := c.repo.ListAuditEvents(model.AuditEventFilter{
+		GroupID:  groupID,
+		BeforeID: beforeID,
+		Limit:    limit + 1,
+	})
+	if err != nil {
+		slog.Error("Failed to list audit events", "error", err)
+		http.Error(w, "Failed to list group history", http.StatusInternalServerError)
+		return
+	}
+
+	response := ListAuditEventsResponse{
+		Events: events,
+	}
+	if len(events) > limit {
+		response.Events = events[:limit]
+		response.NextBefore = &response.Events[limit-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
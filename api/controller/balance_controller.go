@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"domeal/middleware"
+	"domeal/model"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+type BalanceController struct {
+	repo model.BalanceInterface
+}
+
+func NewBalanceController(repo model.BalanceInterface) *BalanceController {
+	return &BalanceController{
+		repo: repo,
+	}
+}
+
+// GetMyBalancesController はグループをまたいで相殺した貸し借りと､まとめて清算するための送金を返します
+func (c *BalanceController) GetMyBalancesController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	balances, err := c.repo.GetUserBalances(userID)
+	if err != nil {
+		slog.Error("Failed to get balances", "error", err)
+		http.Error(w, "Failed to get balances", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(balances); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -12,7 +12,11 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
+	"domeal/jobs"
+	"domeal/line"
+	"domeal/middleware"
 	"domeal/model"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -20,14 +24,24 @@ import (
 
 type UserController struct {
 	repo model.UserInterface
+	jobs jobs.Enqueuer
 }
 
-func NewUserController(repo model.UserInterface) *UserController {
+func NewUserController(repo model.UserInterface, jobs jobs.Enqueuer) *UserController {
 	return &UserController{
 		repo: repo,
+		jobs: jobs,
 	}
 }
 
+// lineIDTokenClaims はLINEログインの id_token に含まれるクレーム
+type lineIDTokenClaims struct {
+	jwt.RegisteredClaims
+	Name    string `json:"name"`
+	Picture string `json:"picture"`
+	Email   string `json:"email"`
+}
+
 // LineCallbackHandler はLINEログインのコールバックを処理します
 func (c *UserController) LineCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	// 認可コードの取得
@@ -96,32 +110,34 @@ func (c *UserController) LineCallbackHandler(w http.ResponseWriter, r *http.Requ
 	// ========================
 	// 3. JWT(id_token)をデコード
 	// ========================
-	claims := jwt.MapClaims{}
+	var claims lineIDTokenClaims
 	parser := jwt.NewParser()
 
 	// 署名検証は省略してデコードのみ
-	_, _, err = parser.ParseUnverified(tokenResponse.IDToken, claims)
+	_, _, err = parser.ParseUnverified(tokenResponse.IDToken, &claims)
 	if err != nil {
 		http.Error(w, "Failed to parse id_token", http.StatusInternalServerError)
 		return
 	}
 
 	// ユーザー情報を取得
-	userInfo := map[string]interface{}{
-		"sub":     claims["sub"],
-		"name":    claims["name"],
-		"picture": claims["picture"],
-		"email":   claims["email"], // scopeにemailが含まれていれば取得可能
+	profile := &model.LineProfile{
+		Sub:     claims.Subject,
+		Name:    claims.Name,
+		Picture: claims.Picture,
+		Email:   claims.Email, // scopeにemailが含まれていれば取得可能
+	}
+	profile.Normalize()
+	if err := profile.Validate(); err != nil {
+		slog.Error("LINEのプロフィールが不正", "error", err)
+		http.Error(w, "Invalid LINE profile", http.StatusBadRequest)
+		return
 	}
 
-	log.Println("User Info (from id_token):", userInfo)
+	log.Println("User Info (from id_token):", profile.Sub, profile.Name)
 
 	// LINE IDからユーザーを検索
-	lineID, ok := userInfo["sub"].(string)
-	if !ok {
-		http.Error(w, "Invalid LINE ID", http.StatusBadRequest)
-		return
-	}
+	lineID := profile.Sub
 
 	isSignUpComplete := true
 	user, err := c.repo.GetUserByLineID(lineID)
@@ -149,14 +165,26 @@ func (c *UserController) LineCallbackHandler(w http.ResponseWriter, r *http.Requ
 		}
 		defer tx.Rollback()
 
-		// セッション更新
+		// セッション更新｡運営者に取り消されるなどしてなければ新しく作る
+		rotated := true
 		sessionID, err = c.repo.UpdateSessionIfExists(tx, user.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			rotated = false
+			sessionID, err = c.repo.CreateSession(tx, user.ID)
+		}
 		if err != nil {
 			slog.Error("セッションの更新に失敗した｡技術的な問題を確認すべき", "error", err)
 			http.Error(w, "Failed to update session", http.StatusInternalServerError)
 			return
 		}
 
+		err = c.appendLoginEvents(tx, user.ID, rotated)
+		if err != nil {
+			slog.Error("ログインの記録に失敗した｡技術的な問題を確認すべき", "error", err)
+			http.Error(w, "Failed to record login", http.StatusInternalServerError)
+			return
+		}
+
 		err = c.repo.UpdateToken(tx, user.ID, tokenResponse.AccessToken, tokenResponse.RefreshToken)
 		if err != nil {
 			slog.Error("トークンの更新に失敗した｡レコードの確認または技術的な問題を確認すべき｡", "error", err)
@@ -164,6 +192,22 @@ func (c *UserController) LineCallbackHandler(w http.ResponseWriter, r *http.Requ
 			return
 		}
 
+		// LINE で名前やアイコンを変えていれば反映する
+		err = c.repo.SyncUserProfile(tx, user.ID, profile)
+		if err != nil {
+			slog.Error("プロフィールの更新に失敗した｡技術的な問題を確認すべき", "error", err)
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+
+		// アクセストークンが切れる前に更新する
+		err = line.ScheduleRefresh(c.jobs, tx, user.ID, tokenResponse.ExpiresIn, time.Now())
+		if err != nil {
+			slog.Error("トークン更新ジョブの予約に失敗した｡技術的な問題を確認すべき", "error", err)
+			http.Error(w, "Failed to schedule token refresh", http.StatusInternalServerError)
+			return
+		}
+
 		if err := tx.Commit(); err != nil {
 			slog.Error("トランザクションのコミットに失敗した｡技術的な問題を確認すべき", "error", err)
 			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
@@ -181,7 +225,7 @@ func (c *UserController) LineCallbackHandler(w http.ResponseWriter, r *http.Requ
 		defer tx.Rollback()
 
 		// ユーザー情報をデータベースに保存
-		userID, err := c.repo.SaveUserInfo(tx, userInfo)
+		userID, err := c.repo.SaveUserInfo(tx, profile)
 		if err != nil {
 			http.Error(w, "Failed to save user info", http.StatusInternalServerError)
 			return
@@ -194,6 +238,13 @@ func (c *UserController) LineCallbackHandler(w http.ResponseWriter, r *http.Requ
 			return
 		}
 
+		// アクセストークンが切れる前に更新する
+		err = line.ScheduleRefresh(c.jobs, tx, userID, tokenResponse.ExpiresIn, time.Now())
+		if err != nil {
+			http.Error(w, "Failed to schedule token refresh", http.StatusInternalServerError)
+			return
+		}
+
 		// セッション作成
 		sessionID, err = c.repo.CreateSession(tx, userID)
 		if err != nil {
@@ -201,6 +252,18 @@ func (c *UserController) LineCallbackHandler(w http.ResponseWriter, r *http.Requ
 			return
 		}
 
+		err = c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionSignUp, userID, 0, userID, nil))
+		if err != nil {
+			http.Error(w, "Failed to record sign up", http.StatusInternalServerError)
+			return
+		}
+
+		err = c.appendLoginEvents(tx, userID, false)
+		if err != nil {
+			http.Error(w, "Failed to record login", http.StatusInternalServerError)
+			return
+		}
+
 		if err := tx.Commit(); err != nil {
 			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
 			return
@@ -222,6 +285,16 @@ func (c *UserController) LineCallbackHandler(w http.ResponseWriter, r *http.Requ
 	http.Redirect(w, r, os.Getenv("AFTER_LOGIN_REDIRECT_URL"), http.StatusTemporaryRedirect)
 }
 
+// appendLoginEvents はログインとセッションの発行を記録する｡rotated なら既存のセッションを作り直した
+func (c *UserController) appendLoginEvents(tx *sql.Tx, userID int64, rotated bool) error {
+	if err := c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionLogin, userID, 0, userID, nil)); err != nil {
+		return err
+	}
+
+	detail := map[string]any{"rotated": rotated}
+	return c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionSessionCreated, userID, 0, userID, detail))
+}
+
 // CheckLoginStatusResponse はログイン状態確認のレスポンス構造体
 type CheckLoginStatusResponse struct {
 	IsLoggedIn bool   `json:"is_logged_in"`
@@ -307,3 +380,245 @@ func (c *UserController) CheckLoginStatusHandler(w http.ResponseWriter, r *http.
 
 	slog.Info("Login status checked", "user_id", user.ID, "status", response.IsLoggedIn)
 }
+
+// MeResponse はログインユーザーのプロフィール
+type MeResponse struct {
+	model.UserProfile
+	PayPalMeURL string `json:"paypal_me_url"`
+}
+
+// UpdateMeRequest は送ったキーだけを変更する｡
+// display_name に null を送ると LINE の名前に戻し､paypal_me_username に null か空文字を送ると未設定に戻す
+type UpdateMeRequest struct {
+	DisplayName      OptionalString `json:"display_name"`
+	PayPalMeUsername OptionalString `json:"paypal_me_username"`
+}
+
+// GetMeHandler はログインユーザーのプロフィールを返します
+func (c *UserController) GetMeHandler(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	c.writeMeResponse(w, userID)
+}
+
+// UpdateMeHandler はログインユーザーの表示名とPayPal.meのユーザー名を変更します
+func (c *UserController) UpdateMeHandler(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	// リクエストボディをパース
+	var req UpdateMeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// バリデーション
+	fieldErrors := map[string]string{}
+
+	var displayName *string
+	if req.DisplayName.Set && req.DisplayName.Value != nil {
+		name := strings.TrimSpace(*req.DisplayName.Value)
+		if err := model.ValidateDisplayName(name); err != nil {
+			fieldErrors["display_name"] = err.Error()
+		}
+		displayName = &name
+	}
+
+	var username string
+	if req.PayPalMeUsername.Set && req.PayPalMeUsername.Value != nil {
+		username = strings.TrimSpace(*req.PayPalMeUsername.Value)
+		if username != "" {
+			if err := model.ValidatePayPalMeUsername(username); err != nil {
+				fieldErrors["paypal_me_username"] = err.Error()
+			}
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if req.DisplayName.Set {
+		err = c.repo.UpdateDisplayName(tx, userID, displayName)
+		if err != nil {
+			slog.Error("Failed to update display name", "error", err)
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.PayPalMeUsername.Set {
+		err = c.repo.UpdatePayPalMeUsername(tx, userID, username)
+		if err != nil {
+			slog.Error("Failed to update paypal.me username", "error", err)
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	c.writeMeResponse(w, userID)
+
+	slog.Info("Profile updated", "user_id", userID)
+}
+
+func (c *UserController) writeMeResponse(w http.ResponseWriter, userID int64) {
+	profile, err := c.repo.GetUserProfile(userID)
+	if err != nil {
+		slog.Error("Failed to get user profile", "error", err)
+		http.Error(w, "Failed to get user profile", http.StatusInternalServerError)
+		return
+	}
+
+	response := MeResponse{
+		UserProfile: *profile,
+	}
+	if profile.PayPalMeUsername != "" {
+		response.PayPalMeURL = "https://paypal.me/" + profile.PayPalMeUsername
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PayPalMeRequest は空文字を送ると未設定に戻す
+type PayPalMeRequest struct {
+	PayPalMeUsername string `json:"paypal_me_username"`
+}
+
+type PayPalMeResponse struct {
+	PayPalMeUsername string `json:"paypal_me_username"`
+	PayPalMeURL      string `json:"paypal_me_url"`
+}
+
+// GetPayPalMeHandler はログインユーザーのPayPal.meのユーザー名を返します
+func (c *UserController) GetPayPalMeHandler(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	username, err := c.repo.GetPayPalMeUsername(userID)
+	if err != nil {
+		slog.Error("Failed to get paypal.me username", "error", err)
+		http.Error(w, "Failed to get paypal.me username", http.StatusInternalServerError)
+		return
+	}
+
+	writePayPalMeResponse(w, username)
+}
+
+// UpdatePayPalMeHandler はログインユーザーのPayPal.meのユーザー名を設定します
+func (c *UserController) UpdatePayPalMeHandler(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	// リクエストボディをパース
+	var req PayPalMeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// バリデーション
+	username := strings.TrimSpace(req.PayPalMeUsername)
+	if username != "" {
+		if err := model.ValidatePayPalMeUsername(username); err != nil {
+			writeValidationErrors(w, map[string]string{
+				"paypal_me_username": err.Error(),
+			})
+			return
+		}
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	err = c.repo.UpdatePayPalMeUsername(tx, userID, username)
+	if err != nil {
+		slog.Error("Failed to update paypal.me username", "error", err)
+		http.Error(w, "Failed to update paypal.me username", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	writePayPalMeResponse(w, username)
+
+	slog.Info("PayPal.me username updated", "user_id", userID)
+}
+
+func writePayPalMeResponse(w http.ResponseWriter, username string) {
+	response := PayPalMeResponse{
+		PayPalMeUsername: username,
+	}
+	if username != "" {
+		response.PayPalMeURL = "https://paypal.me/" + username
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
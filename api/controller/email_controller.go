@@ -0,0 +1,248 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"domeal/mailer"
+	"domeal/middleware"
+	"domeal/model"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// emailVerificationExpiry は確認メールのリンクの有効期限
+	emailVerificationExpiry = 24 * time.Hour
+	// defaultEmailVerificationURL は EMAIL_VERIFICATION_URL がないときの確認ページ｡開発環境の nginx を指す
+	defaultEmailVerificationURL = "http://localhost/verify-email"
+	emailSendTimeout            = 30 * time.Second
+)
+
+type EmailController struct {
+	repo   model.EmailInterface
+	mailer mailer.Mailer
+	// verifyURL はメールのリンクの飛び先｡リクエストの Host から作ると偽のホストにトークンを送らされるので設定で決める
+	verifyURL string
+}
+
+func NewEmailController(repo model.EmailInterface, mailer mailer.Mailer) *EmailController {
+	verifyURL := os.Getenv("EMAIL_VERIFICATION_URL")
+	if verifyURL == "" {
+		verifyURL = defaultEmailVerificationURL
+	}
+
+	return &EmailController{
+		repo:      repo,
+		mailer:    mailer,
+		verifyURL: verifyURL,
+	}
+}
+
+type EmailChangeRequest struct {
+	Email string `json:"email"`
+}
+
+type EmailChangeResponse struct {
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type VerifyEmailResponse struct {
+	Email string `json:"email"`
+}
+
+// RequestEmailChangeHandler は新しいメールアドレスに確認メールを送ります｡
+// リンクを開いて確認が済むまではメールアドレスは変わりません
+func (c *EmailController) RequestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	// リクエストボディをパース
+	var req EmailChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// バリデーション
+	email := strings.TrimSpace(req.Email)
+	if err := model.ValidateEmail(email); err != nil {
+		writeValidationErrors(w, map[string]string{
+			"email": err.Error(),
+		})
+		return
+	}
+
+	token, tokenHash, err := model.NewEmailVerificationToken()
+	if err != nil {
+		slog.Error("Failed to generate verification token", "error", err)
+		http.Error(w, "Failed to request email change", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	verification := &model.EmailVerification{
+		UserID:    userID,
+		Email:     email,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(emailVerificationExpiry),
+	}
+	if err := c.repo.CreateEmailVerification(tx, verification); err != nil {
+		slog.Error("Failed to create email verification", "error", err)
+		http.Error(w, "Failed to request email change", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	// 送れなかったらもう一度リクエストしてもらう｡前のリンクは次のリクエストで使えなくなる
+	ctx, cancel := context.WithTimeout(r.Context(), emailSendTimeout)
+	defer cancel()
+	if err := c.mailer.Send(ctx, c.verificationMessage(email, token)); err != nil {
+		slog.Error("Failed to send verification email", "error", err, "user_id", userID)
+		http.Error(w, "Failed to send verification email", http.StatusBadGateway)
+		return
+	}
+
+	response := EmailChangeResponse{
+		Email:     email,
+		ExpiresAt: verification.ExpiresAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Verification email sent", "user_id", userID)
+}
+
+// VerifyEmailHandler は確認メールのトークンを確かめてメールアドレスを変更します｡
+// 別の端末でリンクを開いても確認できるように､ログインは求めません
+func (c *EmailController) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	// リクエストボディをパース
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Token == "" {
+		slog.Error("Token is required")
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	verification, err := c.repo.LockEmailVerification(tx, model.HashEmailVerificationToken(req.Token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Email verification not found")
+			http.Error(w, "Invalid verification token", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to get email verification", "error", err)
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	if verification.UsedAt != nil {
+		slog.Error("Email verification is already used", "verification_id", verification.ID)
+		http.Error(w, "Verification token is already used", http.StatusConflict)
+		return
+	}
+
+	if time.Now().After(verification.ExpiresAt) {
+		slog.Error("Email verification has expired", "verification_id", verification.ID)
+		http.Error(w, "Verification token has expired", http.StatusGone)
+		return
+	}
+
+	if err := c.repo.MarkEmailVerificationUsed(tx, verification.ID); err != nil {
+		slog.Error("Failed to mark email verification as used", "error", err)
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	if err := c.repo.UpdateUserEmail(tx, verification.UserID, verification.Email); err != nil {
+		slog.Error("Failed to update email", "error", err)
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	response := VerifyEmailResponse{
+		Email: verification.Email,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Email verified", "user_id", verification.UserID)
+}
+
+func (c *EmailController) verificationMessage(email, token string) mailer.Message {
+	link := c.verifyURL + "?token=" + url.QueryEscape(token)
+	return mailer.Message{
+		To:      email,
+		Subject: "メールアドレスの確認",
+		Body: fmt.Sprintf(
+			"以下のリンクを開いて､メールアドレスの確認を完了してください｡\n\n%s\n\n"+
+				"このリンクの有効期限は%d時間です｡心当たりがない場合は､このメールを破棄してください｡\n",
+			link, int(emailVerificationExpiry.Hours()),
+		),
+	}
+}
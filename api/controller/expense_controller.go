@@ -0,0 +1,461 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"domeal/middleware"
+	"domeal/model"
+	"domeal/realtime"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type ExpenseController struct {
+	repo   model.ExpenseInterface
+	events realtime.Publisher
+}
+
+func NewExpenseController(repo model.ExpenseInterface, events realtime.Publisher) *ExpenseController {
+	return &ExpenseController{
+		repo:   repo,
+		events: events,
+	}
+}
+
+// ExpenseRequest は作成と更新で共通のリクエスト｡更新時は省略されたフィールドを変更しない
+type ExpenseRequest struct {
+	// PaidBy は立て替えたメンバー｡作成時に省略するとログインユーザー
+	PaidBy          *int64  `json:"paid_by"`
+	Amount          *int64  `json:"amount"`
+	Description     *string `json:"description"`
+	ReceiptImageURL *string `json:"receipt_image_url"`
+}
+
+type ListExpensesResponse struct {
+	Expenses    []model.Expense `json:"expenses"`
+	TotalAmount int64           `json:"total_amount"`
+}
+
+// applyTo はリクエストで指定されたフィールドをバリデーションしながら expense に反映する
+func (req *ExpenseRequest) applyTo(expense *model.Expense) map[string]string {
+	fieldErrors := map[string]string{}
+
+	if req.PaidBy != nil {
+		if *req.PaidBy <= 0 {
+			fieldErrors["paid_by"] = "must be a valid user ID"
+		}
+		expense.PaidBy = *req.PaidBy
+	}
+
+	if req.Amount != nil {
+		if *req.Amount <= 0 {
+			fieldErrors["amount"] = "must be positive"
+		}
+		expense.Amount = *req.Amount
+	}
+
+	if req.Description != nil {
+		description := strings.TrimSpace(*req.Description)
+		if msg := validateRequiredText(description, 255); msg != "" {
+			fieldErrors["description"] = msg
+		}
+		expense.Description = description
+	}
+
+	if req.ReceiptImageURL != nil {
+		receiptImageURL := strings.TrimSpace(*req.ReceiptImageURL)
+		if msg := validateImageURL(receiptImageURL); msg != "" {
+			fieldErrors["receipt_image_url"] = msg
+		}
+		expense.ReceiptImageURL = receiptImageURL
+	}
+
+	return fieldErrors
+}
+
+func (c *ExpenseController) ListExpensesController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.ViewGroup) {
+		return
+	}
+
+	expenses, err := c.repo.ListExpenses(groupID)
+	if err != nil {
+		slog.Error("Failed to list expenses", "error", err)
+		http.Error(w, "Failed to list expenses", http.StatusInternalServerError)
+		return
+	}
+
+	response := ListExpensesResponse{
+		Expenses: expenses,
+	}
+	for _, expense := range expenses {
+		response.TotalAmount += expense.Amount
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *ExpenseController) CreateExpenseController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.RecordExpense) {
+		return
+	}
+
+	// リクエストボディをパース
+	var req ExpenseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// バリデーション
+	expense := &model.Expense{
+		GroupID:   groupID,
+		PaidBy:    userID,
+		CreatedBy: userID,
+	}
+	fieldErrors := req.applyTo(expense)
+	if req.Amount == nil {
+		fieldErrors["amount"] = "is required"
+	}
+	if req.Description == nil {
+		fieldErrors["description"] = "is required"
+	}
+
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	if !requireGroupMedia(w, c.repo, groupID, "receipt_image_url", expense.ReceiptImageURL) {
+		return
+	}
+
+	if !c.requirePayerIsMember(w, groupID, expense.PaidBy) {
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	err = c.repo.CreateExpense(tx, expense)
+	if err != nil {
+		slog.Error("Failed to create expense", "error", err)
+		http.Error(w, "Failed to create expense", http.StatusInternalServerError)
+		return
+	}
+
+	if err := c.recordExpenseChange(tx, model.AuditActionExpenseCreated, realtime.EventExpenseCreated, userID, expense); err != nil {
+		slog.Error("Failed to record expense change", "error", err)
+		http.Error(w, "Failed to create expense", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(expense); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Expense created successfully", "group_id", groupID, "expense_id", expense.ID, "user_id", userID)
+}
+
+func (c *ExpenseController) UpdateExpenseController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	expenseID, err := pathID(r, "expenseID")
+	if err != nil {
+		slog.Error("Invalid expense ID", "error", err)
+		http.Error(w, "Valid expense ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.RecordExpense) {
+		return
+	}
+
+	expense, ok := c.getEditableExpense(w, groupID, expenseID, userID)
+	if !ok {
+		return
+	}
+
+	// リクエストボディをパース
+	var req ExpenseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// バリデーション
+	if fieldErrors := req.applyTo(expense); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	if req.ReceiptImageURL != nil && !requireGroupMedia(w, c.repo, groupID, "receipt_image_url", expense.ReceiptImageURL) {
+		return
+	}
+
+	if req.PaidBy != nil && !c.requirePayerIsMember(w, groupID, expense.PaidBy) {
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	err = c.repo.UpdateExpense(tx, expense)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Expense not found", "group_id", groupID, "expense_id", expenseID)
+			http.Error(w, "Expense not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to update expense", "error", err)
+		http.Error(w, "Failed to update expense", http.StatusInternalServerError)
+		return
+	}
+
+	if err := c.recordExpenseChange(tx, model.AuditActionExpenseUpdated, realtime.EventExpenseUpdated, userID, expense); err != nil {
+		slog.Error("Failed to record expense change", "error", err)
+		http.Error(w, "Failed to update expense", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(expense); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Expense updated successfully", "group_id", groupID, "expense_id", expenseID, "user_id", userID)
+}
+
+func (c *ExpenseController) DeleteExpenseController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	expenseID, err := pathID(r, "expenseID")
+	if err != nil {
+		slog.Error("Invalid expense ID", "error", err)
+		http.Error(w, "Valid expense ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.RecordExpense) {
+		return
+	}
+
+	expense, ok := c.getEditableExpense(w, groupID, expenseID, userID)
+	if !ok {
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	deleted, err := c.repo.DeleteExpense(tx, groupID, expenseID)
+	if err != nil {
+		slog.Error("Failed to delete expense", "error", err)
+		http.Error(w, "Failed to delete expense", http.StatusInternalServerError)
+		return
+	}
+
+	if !deleted {
+		slog.Error("Expense not found", "group_id", groupID, "expense_id", expenseID)
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+
+	if err := c.recordExpenseChange(tx, model.AuditActionExpenseDeleted, realtime.EventExpenseDeleted, userID, expense); err != nil {
+		slog.Error("Failed to record expense change", "error", err)
+		http.Error(w, "Failed to delete expense", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	slog.Info("Expense deleted successfully", "group_id", groupID, "expense_id", expenseID, "user_id", userID)
+}
+
+// getEditableExpense は記録した本人､立て替えた本人､他の人の立て替えも直せる役割の人のいずれかであれば立て替えを返す｡
+// 条件を満たさない場合はエラーレスポンスを書き込んで false を返す
+func (c *ExpenseController) getEditableExpense(w http.ResponseWriter, groupID, expenseID, userID int64) (*model.Expense, bool) {
+	expense, err := c.repo.GetExpense(groupID, expenseID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Expense not found", "group_id", groupID, "expense_id", expenseID)
+			http.Error(w, "Expense not found", http.StatusNotFound)
+			return nil, false
+		}
+		slog.Error("Failed to get expense", "error", err)
+		http.Error(w, "Failed to get expense", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if expense.CreatedBy == userID || expense.PaidBy == userID {
+		return expense, true
+	}
+
+	allowed, err := authz.Can(c.repo, groupID, userID, authz.EditAnyExpense)
+	if err != nil {
+		slog.Error("Failed to check group permission", "error", err)
+		http.Error(w, "Failed to check group permission", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !allowed {
+		slog.Error("User cannot edit this expense", "group_id", groupID, "expense_id", expenseID, "user_id", userID)
+		http.Error(w, "Only the person who recorded or paid the expense, or an organiser, can change it", http.StatusForbidden)
+		return nil, false
+	}
+
+	return expense, true
+}
+
+// recordExpenseChange は立て替えの変更を監査ログに残し､精算の金額が変わったことをルームに知らせる
+func (c *ExpenseController) recordExpenseChange(tx *sql.Tx, action, eventType string, userID int64, expense *model.Expense) error {
+	err := c.repo.AppendAuditEvent(tx, model.NewAuditEvent(action, userID, expense.GroupID, expense.PaidBy, map[string]any{
+		"expense_id": expense.ID,
+		"amount":     expense.Amount,
+	}))
+	if err != nil {
+		return err
+	}
+
+	return recordEvents(tx, c.events, realtime.NewEvent(eventType, expense.GroupID, realtime.ExpensePayload{
+		ExpenseID: expense.ID,
+		PaidBy:    expense.PaidBy,
+		Amount:    expense.Amount,
+	}))
+}
+
+// requirePayerIsMember は立て替えた人がグループのメンバーであることを確認する
+func (c *ExpenseController) requirePayerIsMember(w http.ResponseWriter, groupID, payerID int64) bool {
+	isMember, err := c.repo.IsGroupMember(groupID, payerID)
+	if err != nil {
+		slog.Error("Failed to check group membership", "error", err)
+		http.Error(w, "Failed to check group membership", http.StatusInternalServerError)
+		return false
+	}
+
+	if !isMember {
+		writeValidationErrors(w, map[string]string{
+			"paid_by": "must be a member of this group",
+		})
+		return false
+	}
+
+	return true
+}
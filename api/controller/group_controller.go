@@ -3,34 +3,57 @@ package controller
 import (
 	"context"
 	"database/sql"
+	"domeal/authz"
+	"domeal/media"
 	"domeal/middleware"
 	"domeal/model"
+	"domeal/notify"
+	"domeal/realtime"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type GroupController struct {
-	repo model.GroupInterface
+	repo          model.GroupInterface
+	events        realtime.Publisher
+	notifications notify.Enqueuer
+	blobs         media.BlobStore
 }
 
-func NewGroupController(repo model.GroupInterface) *GroupController {
+func NewGroupController(repo model.GroupInterface, events realtime.Publisher, notifications notify.Enqueuer, blobs media.BlobStore) *GroupController {
 	return &GroupController{
-		repo: repo,
+		repo:          repo,
+		events:        events,
+		notifications: notifications,
+		blobs:         blobs,
 	}
 }
 
+// CreateGroupRequest は menu か menu_items のどちらかが必要｡
+// menu だけを送る旧クライアントもそのまま使える｡
+// 料理の画像を付けるときは multipart/form-data で group にこのJSONを､image に画像ファイルを送る
 type CreateGroupRequest struct {
-	Name         string `json:"name"`
-	Menu         string `json:"menu"`
-	MenuImageURL string `json:"menu_image_url"`
+	Name       string            `json:"name"`
+	Menu       string            `json:"menu"`
+	MaxMembers *int64            `json:"max_members"`
+	MenuItems  []MenuItemRequest `json:"menu_items"`
+	// Deadline を過ぎると自動で締め切る
+	Deadline *time.Time `json:"deadline"`
 }
 
 type CreateGroupResponse struct {
-	ID           int64  `json:"id"`
-	Name         string `json:"name"`
-	Menu         string `json:"menu"`
-	MenuImageURL string `json:"menu_image_url"`
+	ID           int64            `json:"id"`
+	Name         string           `json:"name"`
+	Menu         string           `json:"menu"`
+	MenuImageURL string           `json:"menu_image_url"`
+	MaxMembers   *int64           `json:"max_members"`
+	MenuItems    []model.MenuItem `json:"menu_items"`
+	Deadline     *time.Time       `json:"deadline"`
 }
 
 type JoinGroupRequest struct {
@@ -38,10 +61,51 @@ type JoinGroupRequest struct {
 }
 
 type JoinGroupResponse struct {
-	GroupID   int64  `json:"group_id"`
-	GroupName string `json:"group_name"`
-	UserID    int64  `json:"user_id"`
-	Message   string `json:"message"`
+	GroupID    int64  `json:"group_id"`
+	GroupName  string `json:"group_name"`
+	UserID     int64  `json:"user_id"`
+	Waitlisted bool   `json:"waitlisted"`
+	Message    string `json:"message"`
+}
+
+// UpdateGroupRequest は省略されたフィールドを変更しない
+type UpdateGroupRequest struct {
+	Name        *string       `json:"name"`
+	Menu        *string       `json:"menu"`
+	MaxMembers  OptionalInt64 `json:"max_members"`
+	Status      *string       `json:"status"`
+	SplitMethod *string       `json:"split_method"`
+	Deadline    OptionalTime  `json:"deadline"`
+}
+
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type MemberRoleResponse struct {
+	GroupID int64      `json:"group_id"`
+	UserID  int64      `json:"user_id"`
+	Role    authz.Role `json:"role"`
+}
+
+// roleLabels は通知に書く役割の名前
+var roleLabels = map[authz.Role]string{
+	authz.RoleOwner:     "オーナー",
+	authz.RoleOrganiser: "幹事",
+	authz.RoleMember:    "メンバー",
+	authz.RoleViewer:    "閲覧のみ",
+}
+
+type LeaveGroupRequest struct {
+	GroupID int64 `json:"group_id"`
+}
+
+type LeaveGroupResponse struct {
+	GroupID int64 `json:"group_id"`
+	UserID  int64 `json:"user_id"`
+	// PromotedUserID は待ちリストから繰り上がったユーザー
+	PromotedUserID *int64 `json:"promoted_user_id,omitempty"`
+	Message        string `json:"message"`
 }
 
 func (c *GroupController) CreateGroupController(w http.ResponseWriter, r *http.Request) {
@@ -62,7 +126,28 @@ func (c *GroupController) CreateGroupController(w http.ResponseWriter, r *http.R
 
 	// リクエストボディをパース
 	var req CreateGroupRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	var menuImage []byte
+	if isMultipart(r) {
+		r.Body = http.MaxBytesReader(w, r.Body, media.MaxUploadSize+multipartOverhead)
+		if err := r.ParseMultipartForm(multipartMemory); err != nil {
+			writeMultipartError(w, err)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		if err := json.Unmarshal([]byte(r.FormValue("group")), &req); err != nil {
+			slog.Error("Failed to decode group field", "error", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		menuImage, err = readFormFile(r, "image", media.MaxUploadSize)
+		if err != nil {
+			writeMultipartError(w, err)
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		slog.Error("Failed to decode request body", "error", err)
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
@@ -75,14 +160,65 @@ func (c *GroupController) CreateGroupController(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	menuItems := make([]*model.MenuItem, 0, len(req.MenuItems))
+	fieldErrors := map[string]string{}
+	for i := range req.MenuItems {
+		item, itemErrors := req.MenuItems[i].newMenuItem(0, menuItemFieldPrefix(i))
+		for field, msg := range itemErrors {
+			fieldErrors[field] = msg
+		}
+		// 画像はグループのものとして登録するので､グループを作ってから付けてもらう
+		if _, ok := itemErrors[menuItemFieldPrefix(i)+"image_url"]; !ok && item.ImageURL != "" {
+			fieldErrors[menuItemFieldPrefix(i)+"image_url"] = "must be added after the group is created"
+		}
+		menuItems = append(menuItems, item)
+	}
+
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	// 料理の一覧だけが送られてきた場合は旧クライアント向けに menu を埋める
+	if req.Menu == "" && len(menuItems) > 0 {
+		req.Menu = summarizeMenuItems(menuItems)
+	}
+
 	if req.Menu == "" {
 		slog.Error("Menu is required")
 		http.Error(w, "Menu is required", http.StatusBadRequest)
 		return
 	}
 
-	// TODO:  料理の画像は一旦ダミーをつかう
-	req.MenuImageURL = "https://www.foodiesfeed.com/wp-content/uploads/2023/06/burger-with-melted-cheese.jpg.webp"
+	if req.MaxMembers != nil && *req.MaxMembers <= 0 {
+		slog.Error("Max members must be positive", "max_members", *req.MaxMembers)
+		http.Error(w, "Max members must be positive", http.StatusBadRequest)
+		return
+	}
+
+	if req.Deadline != nil && !req.Deadline.After(time.Now()) {
+		slog.Error("Deadline must be in the future", "deadline", *req.Deadline)
+		http.Error(w, "Deadline must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	// 料理の画像は縮小して保存しておき､グループを作れなかったら消す
+	var stored *media.StoredImage
+	committed := false
+	if menuImage != nil {
+		var err error
+		stored, err = media.SaveImage(r.Context(), c.blobs, menuImagePrefix, menuImage)
+		if err != nil {
+			writeImageError(w, err)
+			return
+		}
+
+		defer func() {
+			if !committed {
+				media.DeleteImage(context.Background(), c.blobs, stored)
+			}
+		}()
+	}
 
 	// トランザクション開始
 	tx, err := c.repo.BeginTx(context.Background(), nil)
@@ -95,10 +231,11 @@ func (c *GroupController) CreateGroupController(w http.ResponseWriter, r *http.R
 
 	// Groupオブジェクトを作成
 	group := &model.Group{
-		Name:         req.Name,
-		Menu:         req.Menu,
-		MenuImageURL: req.MenuImageURL,
-		CreatedBy:    userID,
+		Name:       req.Name,
+		Menu:       req.Menu,
+		CreatedBy:  userID,
+		MaxMembers: req.MaxMembers,
+		Deadline:   req.Deadline,
 	}
 
 	// Groupを作成
@@ -109,27 +246,72 @@ func (c *GroupController) CreateGroupController(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	// 画像はグループのIDが決まってから登録し､メンバーだけが見られるURLにする
+	var menuImageURL string
+	if stored != nil {
+		image := &model.Media{
+			GroupID:     groupID,
+			ObjectKey:   stored.Key,
+			ContentType: media.ContentType,
+		}
+		if err := c.repo.CreateMedia(tx, image); err != nil {
+			slog.Error("Failed to create media", "error", err)
+			http.Error(w, "Failed to create group", http.StatusInternalServerError)
+			return
+		}
+
+		menuImageURL = media.Path(image.ID)
+		if err := c.repo.UpdateGroupMenuImage(tx, groupID, menuImageURL); err != nil {
+			slog.Error("Failed to update menu image", "error", err)
+			http.Error(w, "Failed to create group", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// グループ作成者をgroup_membersテーブルに追加（オーナーとして）
-	err = c.repo.AddGroupMember(tx, groupID, userID, true)
+	err = c.repo.AddGroupMember(tx, groupID, userID, authz.RoleOwner)
 	if err != nil {
 		slog.Error("Failed to add group creator as group member", "error", err)
 		http.Error(w, "Failed to add group creator as group member", http.StatusInternalServerError)
 		return
 	}
 
+	createdMenuItems := make([]model.MenuItem, 0, len(menuItems))
+	for _, item := range menuItems {
+		item.GroupID = groupID
+		err = c.repo.CreateMenuItem(tx, item)
+		if err != nil {
+			slog.Error("Failed to create menu item", "error", err)
+			http.Error(w, "Failed to create menu item", http.StatusInternalServerError)
+			return
+		}
+		createdMenuItems = append(createdMenuItems, *item)
+	}
+
+	err = c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionGroupCreated, userID, groupID, 0, nil))
+	if err != nil {
+		slog.Error("Failed to append audit event", "error", err)
+		http.Error(w, "Failed to create group", http.StatusInternalServerError)
+		return
+	}
+
 	// トランザクションをコミット
 	if err := tx.Commit(); err != nil {
 		slog.Error("Failed to commit transaction", "error", err)
 		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
 		return
 	}
+	committed = true
 
 	// レスポンスを作成
 	response := CreateGroupResponse{
 		ID:           groupID,
 		Name:         req.Name,
 		Menu:         req.Menu,
-		MenuImageURL: req.MenuImageURL,
+		MenuImageURL: menuImageURL,
+		MaxMembers:   req.MaxMembers,
+		MenuItems:    createdMenuItems,
+		Deadline:     req.Deadline,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -174,30 +356,196 @@ func (c *GroupController) JoinGroupController(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// グループが存在するかチェック
-	group, err := c.repo.GetGroup(req.GroupID)
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// 同時に参加リクエストが来ても定員を超えないようにグループの行をロックしてから数える
+	group, err := c.repo.LockGroup(tx, req.GroupID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			slog.Error("Group not found", "group_id", req.GroupID)
 			http.Error(w, "Group not found", http.StatusNotFound)
 			return
 		}
-		slog.Error("Failed to get group", "error", err)
-		http.Error(w, "Failed to get group", http.StatusInternalServerError)
+		slog.Error("Failed to lock group", "error", err)
+		http.Error(w, "Failed to join group", http.StatusInternalServerError)
 		return
 	}
 
-	// ユーザーが既にグループのメンバーかチェック
-	isMember, err := c.repo.IsGroupMember(req.GroupID, userID)
-	if err != nil {
+	// 同じユーザーの参加リクエストが重なっても二重に登録しないように､ロックしてから確かめる
+	_, err = c.repo.GetGroupMemberID(tx, req.GroupID, userID)
+	if err == nil {
+		slog.Error("User is already a member of this group", "group_id", req.GroupID, "user_id", userID)
+		http.Error(w, "You are already a member of this group", http.StatusConflict)
+		return
+	}
+	if err != sql.ErrNoRows {
 		slog.Error("Failed to check group membership", "error", err)
 		http.Error(w, "Failed to check group membership", http.StatusInternalServerError)
 		return
 	}
 
-	if isMember {
-		slog.Error("User is already a member of this group", "group_id", req.GroupID, "user_id", userID)
-		http.Error(w, "You are already a member of this group", http.StatusConflict)
+	isWaitlisted, err := c.repo.IsWaitlisted(tx, req.GroupID, userID)
+	if err != nil {
+		slog.Error("Failed to check waitlist", "error", err)
+		http.Error(w, "Failed to check waitlist", http.StatusInternalServerError)
+		return
+	}
+
+	if isWaitlisted {
+		slog.Error("User is already on the waitlist of this group", "group_id", req.GroupID, "user_id", userID)
+		http.Error(w, "You are already on the waitlist of this group", http.StatusConflict)
+		return
+	}
+
+	if group.Status != model.GroupStatusOpen {
+		slog.Error("Group is not open for joining", "group_id", req.GroupID, "status", group.Status)
+		http.Error(w, "The group is no longer accepting members", http.StatusConflict)
+		return
+	}
+
+	isFull := false
+	var memberCount int64
+	if group.MaxMembers != nil {
+		memberCount, err = c.repo.CountGroupMembers(tx, req.GroupID)
+		if err != nil {
+			slog.Error("Failed to count group members", "error", err)
+			http.Error(w, "Failed to join group", http.StatusInternalServerError)
+			return
+		}
+		isFull = memberCount >= *group.MaxMembers
+	}
+
+	if isFull {
+		// 定員に達しているので待ちリストに追加
+		err = c.repo.AddWaitlistEntry(tx, req.GroupID, userID)
+		if err != nil {
+			slog.Error("Failed to add user to waitlist", "error", err)
+			http.Error(w, "Failed to join waitlist", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		// ユーザーをグループメンバーとして追加（オーナーではない）
+		err = c.repo.AddGroupMember(tx, req.GroupID, userID, authz.RoleMember)
+		if err != nil {
+			slog.Error("Failed to add user to group", "error", err)
+			http.Error(w, "Failed to join group", http.StatusInternalServerError)
+			return
+		}
+
+		// この参加で定員に達したらメンバー全員に知らせる
+		if group.MaxMembers != nil && memberCount+1 == *group.MaxMembers {
+			if err := c.notifyGroupFull(tx, group); err != nil {
+				slog.Error("Failed to enqueue group full notifications", "error", err)
+				http.Error(w, "Failed to join group", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	eventType := realtime.EventMemberJoined
+	auditAction := model.AuditActionMemberJoined
+	if isFull {
+		eventType = realtime.EventMemberWaitlisted
+		auditAction = model.AuditActionMemberWaitlisted
+	}
+	err = c.repo.AppendAuditEvent(tx, model.NewAuditEvent(auditAction, userID, req.GroupID, userID, nil))
+	if err != nil {
+		slog.Error("Failed to append audit event", "error", err)
+		http.Error(w, "Failed to join group", http.StatusInternalServerError)
+		return
+	}
+
+	pending := []realtime.Event{
+		realtime.NewEvent(eventType, req.GroupID, realtime.MemberPayload{
+			UserID:      userID,
+			DisplayName: tmpUser.DisplayName,
+		}),
+	}
+	if err := recordEvents(tx, c.events, pending...); err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to join group", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	// レスポンスを作成
+	response := JoinGroupResponse{
+		GroupID:    req.GroupID,
+		GroupName:  group.Name,
+		UserID:     userID,
+		Waitlisted: isFull,
+		Message:    "Successfully joined the group",
+	}
+	if isFull {
+		response.Message = "The group is full. You have been added to the waitlist"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("User joined group successfully", "group_id", req.GroupID, "user_id", userID, "waitlisted", isFull)
+}
+
+func (c *GroupController) LeaveGroupController(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		slog.Error("Invalid method", "method", r.Method)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	// リクエストボディをパース
+	var req LeaveGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// バリデーション
+	if req.GroupID <= 0 {
+		slog.Error("Valid group ID is required")
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	// オーナーが抜けるとグループを管理する人がいなくなるので許可しない｡
+	// メンバーでなければ待ちリストから抜けるので､ここではエラーにしない
+	_, err := authz.Check(c.repo, req.GroupID, userID, authz.LeaveGroup)
+	if errors.Is(err, authz.ErrForbidden) {
+		slog.Error("Owner cannot leave the group", "group_id", req.GroupID, "user_id", userID)
+		http.Error(w, "The owner cannot leave the group", http.StatusConflict)
+		return
+	}
+	if err != nil && !errors.Is(err, authz.ErrNotMember) {
+		slog.Error("Failed to check group permission", "error", err)
+		http.Error(w, "Failed to check group permission", http.StatusInternalServerError)
 		return
 	}
 
@@ -210,11 +558,92 @@ func (c *GroupController) JoinGroupController(w http.ResponseWriter, r *http.Req
 	}
 	defer tx.Rollback()
 
-	// ユーザーをグループメンバーとして追加（オーナーではない）
-	err = c.repo.AddGroupMember(tx, req.GroupID, userID, false)
+	// 参加と同じくグループの行をロックして､繰り上げと新規参加が競合しないようにする
+	lockedGroup, err := c.repo.LockGroup(tx, req.GroupID)
 	if err != nil {
-		slog.Error("Failed to add user to group", "error", err)
-		http.Error(w, "Failed to join group", http.StatusInternalServerError)
+		if err == sql.ErrNoRows {
+			slog.Error("Group not found", "group_id", req.GroupID)
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to lock group", "error", err)
+		http.Error(w, "Failed to leave group", http.StatusInternalServerError)
+		return
+	}
+
+	removed, err := c.repo.RemoveGroupMember(tx, req.GroupID, userID)
+	if err != nil {
+		slog.Error("Failed to remove user from group", "error", err)
+		http.Error(w, "Failed to leave group", http.StatusInternalServerError)
+		return
+	}
+
+	response := LeaveGroupResponse{
+		GroupID: req.GroupID,
+		UserID:  userID,
+		Message: "Successfully left the group",
+	}
+	eventType := realtime.EventMemberLeft
+
+	if removed {
+		// 抜けた記録を繰り上げの記録より先にする
+		err = c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionMemberLeft, userID, req.GroupID, userID, nil))
+		if err != nil {
+			slog.Error("Failed to append audit event", "error", err)
+			http.Error(w, "Failed to leave group", http.StatusInternalServerError)
+			return
+		}
+
+		// 空いた枠に待ちリストの先頭を繰り上げる
+		promotedUserIDs, err := promoteFromWaitlist(tx, c.repo, lockedGroup)
+		if err != nil {
+			slog.Error("Failed to promote user from waitlist", "error", err)
+			http.Error(w, "Failed to leave group", http.StatusInternalServerError)
+			return
+		}
+
+		if len(promotedUserIDs) > 0 {
+			response.PromotedUserID = &promotedUserIDs[0]
+		}
+	} else {
+		// メンバーでなければ待ちリストから抜ける
+		removed, err = c.repo.RemoveWaitlistEntry(tx, req.GroupID, userID)
+		if err != nil {
+			slog.Error("Failed to remove user from waitlist", "error", err)
+			http.Error(w, "Failed to leave waitlist", http.StatusInternalServerError)
+			return
+		}
+
+		if !removed {
+			slog.Error("User is not a member of this group", "group_id", req.GroupID, "user_id", userID)
+			http.Error(w, "You are not a member of this group", http.StatusNotFound)
+			return
+		}
+		response.Message = "Successfully left the waitlist"
+		eventType = realtime.EventWaitlistLeft
+
+		err = c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionWaitlistLeft, userID, req.GroupID, userID, nil))
+		if err != nil {
+			slog.Error("Failed to append audit event", "error", err)
+			http.Error(w, "Failed to leave waitlist", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	pending := []realtime.Event{
+		realtime.NewEvent(eventType, req.GroupID, realtime.MemberPayload{
+			UserID:      userID,
+			DisplayName: tmpUser.DisplayName,
+		}),
+	}
+	if response.PromotedUserID != nil {
+		pending = append(pending, realtime.NewEvent(realtime.EventMemberPromoted, req.GroupID, realtime.MemberPayload{
+			UserID: *response.PromotedUserID,
+		}))
+	}
+	if err := recordEvents(tx, c.events, pending...); err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to leave group", http.StatusInternalServerError)
 		return
 	}
 
@@ -225,12 +654,495 @@ func (c *GroupController) JoinGroupController(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// レスポンスを作成
-	response := JoinGroupResponse{
-		GroupID:   req.GroupID,
-		GroupName: group.Name,
-		UserID:    userID,
-		Message:   "Successfully joined the group",
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("User left group successfully", "group_id", req.GroupID, "user_id", userID, "promoted_user_id", response.PromotedUserID)
+}
+
+func (c *GroupController) UpdateGroupController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.EditGroup) {
+		return
+	}
+
+	// リクエストボディをパース
+	var req UpdateGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == nil && req.Menu == nil && !req.MaxMembers.Set && req.Status == nil && req.SplitMethod == nil && !req.Deadline.Set {
+		slog.Error("No fields to update", "group_id", groupID)
+		http.Error(w, "No fields to update", http.StatusBadRequest)
+		return
+	}
+
+	// バリデーション
+	fieldErrors := map[string]string{}
+	if req.Name != nil {
+		*req.Name = strings.TrimSpace(*req.Name)
+		if msg := validateRequiredText(*req.Name, 255); msg != "" {
+			fieldErrors["name"] = msg
+		}
+	}
+	if req.Menu != nil {
+		*req.Menu = strings.TrimSpace(*req.Menu)
+		if msg := validateRequiredText(*req.Menu, 255); msg != "" {
+			fieldErrors["menu"] = msg
+		}
+	}
+	if req.MaxMembers.Value != nil && *req.MaxMembers.Value <= 0 {
+		fieldErrors["max_members"] = "must be positive"
+	}
+	if req.Status != nil && *req.Status != model.GroupStatusOpen && *req.Status != model.GroupStatusClosed {
+		fieldErrors["status"] = fmt.Sprintf("must be %q or %q", model.GroupStatusOpen, model.GroupStatusClosed)
+	}
+	if req.Deadline.Value != nil && !req.Deadline.Value.After(time.Now()) {
+		fieldErrors["deadline"] = "must be in the future"
+	}
+	var splitMethod model.SplitMethod
+	if req.SplitMethod != nil {
+		splitMethod, err = model.ParseSplitMethod(*req.SplitMethod)
+		if err != nil {
+			fieldErrors["split_method"] = "must be one of equal, items, fixed or weighted"
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// 定員の変更と参加が競合しないようにロックする
+	group, err := c.repo.LockGroup(tx, groupID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Group not found", "group_id", groupID)
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to lock group", "error", err)
+		http.Error(w, "Failed to update group", http.StatusInternalServerError)
+		return
+	}
+
+	if req.Name != nil {
+		group.Name = *req.Name
+	}
+	if req.Menu != nil {
+		group.Menu = *req.Menu
+	}
+	previousStatus := group.Status
+	if req.Status != nil {
+		group.Status = *req.Status
+	}
+	if req.SplitMethod != nil {
+		group.SplitMethod = splitMethod
+	}
+	if req.Deadline.Set {
+		group.Deadline = req.Deadline.Value
+	}
+
+	// 締め切りを過ぎたまま開き直すとすぐにスケジューラーが締め切ってしまう
+	if previousStatus != model.GroupStatusOpen && group.Status == model.GroupStatusOpen &&
+		group.Deadline != nil && !group.Deadline.After(time.Now()) {
+		writeValidationErrors(w, map[string]string{
+			"deadline": "has passed; set a new deadline to reopen the group",
+		})
+		return
+	}
+	if req.MaxMembers.Set {
+		if req.MaxMembers.Value != nil {
+			memberCount, err := c.repo.CountGroupMembers(tx, groupID)
+			if err != nil {
+				slog.Error("Failed to count group members", "error", err)
+				http.Error(w, "Failed to update group", http.StatusInternalServerError)
+				return
+			}
+
+			// すでに参加しているメンバーを追い出すことはしない
+			if *req.MaxMembers.Value < memberCount {
+				writeValidationErrors(w, map[string]string{
+					"max_members": fmt.Sprintf("must be at least the current member count (%d)", memberCount),
+				})
+				return
+			}
+		}
+		group.MaxMembers = req.MaxMembers.Value
+	}
+
+	err = c.repo.UpdateGroup(tx, group)
+	if err != nil {
+		slog.Error("Failed to update group", "error", err)
+		http.Error(w, "Failed to update group", http.StatusInternalServerError)
+		return
+	}
+
+	// 定員が増えたか開き直した場合は待ちリストから繰り上げる
+	var promotedUserIDs []int64
+	if req.MaxMembers.Set || previousStatus != model.GroupStatusOpen {
+		promotedUserIDs, err = promoteFromWaitlist(tx, c.repo, group)
+		if err != nil {
+			slog.Error("Failed to promote users from waitlist", "error", err)
+			http.Error(w, "Failed to update group", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// 締め切ったら精算額が決まるので､支払う側に知らせる
+	if previousStatus == model.GroupStatusOpen && group.Status == model.GroupStatusClosed {
+		if err := notify.EnqueuePaymentDue(tx, c.notifications, c.repo, group); err != nil {
+			slog.Error("Failed to enqueue payment due notifications", "error", err)
+			http.Error(w, "Failed to update group", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionGroupUpdated, userID, groupID, 0, map[string]any{
+		"fields":          updatedGroupFields(req),
+		"previous_status": previousStatus,
+		"status":          group.Status,
+	}))
+	if err != nil {
+		slog.Error("Failed to append audit event", "error", err)
+		http.Error(w, "Failed to update group", http.StatusInternalServerError)
+		return
+	}
+
+	pending := []realtime.Event{
+		realtime.NewEvent(realtime.EventGroupUpdated, groupID, group),
+	}
+	if group.Status != previousStatus {
+		pending = append(pending, realtime.NewEvent(realtime.EventGroupStatusChanged, groupID, realtime.StatusPayload{
+			Status: group.Status,
+		}))
+	}
+	for _, promotedUserID := range promotedUserIDs {
+		pending = append(pending, realtime.NewEvent(realtime.EventMemberPromoted, groupID, realtime.MemberPayload{
+			UserID: promotedUserID,
+		}))
+	}
+	if err := recordEvents(tx, c.events, pending...); err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to update group", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(group); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Group updated successfully", "group_id", groupID, "user_id", userID)
+}
+
+func (c *GroupController) DeleteGroupController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.DeleteGroup) {
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	group, err := c.repo.LockGroup(tx, groupID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Group not found", "group_id", groupID)
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to lock group", "error", err)
+		http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+		return
+	}
+
+	// 行は消さずに論理削除する
+	err = c.repo.SoftDeleteGroup(tx, groupID)
+	if err != nil {
+		slog.Error("Failed to delete group", "error", err)
+		http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+		return
+	}
+
+	// メンバーと待ちリストのユーザーに削除を知らせる
+	memberIDs, err := c.repo.ListGroupMemberIDs(tx, groupID)
+	if err != nil {
+		slog.Error("Failed to list group members", "error", err)
+		http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+		return
+	}
+
+	waitlistUserIDs, err := c.repo.ListWaitlistUserIDs(tx, groupID)
+	if err != nil {
+		slog.Error("Failed to list waitlist", "error", err)
+		http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+		return
+	}
+
+	for _, memberID := range append(memberIDs, waitlistUserIDs...) {
+		if memberID == userID {
+			continue
+		}
+
+		err = c.repo.CreateNotification(tx, &model.Notification{
+			UserID:  memberID,
+			GroupID: &groupID,
+			Kind:    model.NotificationKindGroupDeleted,
+			Message: fmt.Sprintf("グループ「%s」はオーナーによって削除されました", group.Name),
+		})
+		if err != nil {
+			slog.Error("Failed to create notification", "error", err, "user_id", memberID)
+			http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionGroupDeleted, userID, groupID, 0, nil))
+	if err != nil {
+		slog.Error("Failed to append audit event", "error", err)
+		http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+		return
+	}
+
+	pending := []realtime.Event{
+		realtime.NewEvent(realtime.EventGroupDeleted, groupID, nil),
+	}
+	if err := recordEvents(tx, c.events, pending...); err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	slog.Info("Group deleted successfully", "group_id", groupID, "user_id", userID)
+}
+
+// UpdateMemberRoleController はオーナーがメンバーの役割を変えます｡
+// owner を指定するとオーナーを譲り､自分は幹事になります
+func (c *GroupController) UpdateMemberRoleController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	memberID, err := pathID(r, "userID")
+	if err != nil {
+		slog.Error("Invalid user ID", "error", err)
+		http.Error(w, "Valid user ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.ManageRoles) {
+		return
+	}
+
+	// リクエストボディをパース
+	var req UpdateMemberRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// バリデーション
+	role, err := authz.ParseRole(req.Role)
+	if err != nil {
+		writeValidationErrors(w, map[string]string{
+			"role": err.Error(),
+		})
+		return
+	}
+
+	// オーナーがいなくならないように､自分の役割は変えられない｡降りるときは他のメンバーに譲る
+	if memberID == userID {
+		slog.Error("Owner cannot change their own role", "group_id", groupID, "user_id", userID)
+		http.Error(w, "Transfer ownership to another member instead", http.StatusConflict)
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// 参加や脱退と同じくグループの行をロックして､役割の変更を直列化する
+	group, err := c.repo.LockGroup(tx, groupID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Group not found", "group_id", groupID)
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to lock group", "error", err)
+		http.Error(w, "Failed to update member role", http.StatusInternalServerError)
+		return
+	}
+
+	var updated bool
+	if role == authz.RoleOwner {
+		updated, err = c.repo.TransferGroupOwnership(tx, groupID, userID, memberID)
+	} else {
+		updated, err = c.repo.UpdateMemberRole(tx, groupID, memberID, role)
+	}
+	if err != nil {
+		slog.Error("Failed to update member role", "error", err)
+		http.Error(w, "Failed to update member role", http.StatusInternalServerError)
+		return
+	}
+
+	if !updated {
+		slog.Error("Group member not found", "group_id", groupID, "user_id", memberID)
+		http.Error(w, "Group member not found", http.StatusNotFound)
+		return
+	}
+
+	audits := []*model.AuditEvent{
+		model.NewAuditEvent(model.AuditActionMemberRoleChanged, userID, groupID, memberID, map[string]any{
+			"role": role,
+		}),
+	}
+	if role == authz.RoleOwner {
+		// オーナーを譲った側は幹事になる
+		audits = append(audits, model.NewAuditEvent(model.AuditActionMemberRoleChanged, userID, groupID, userID, map[string]any{
+			"role": authz.RoleOrganiser,
+		}))
+	}
+	for _, audit := range audits {
+		if err := c.repo.AppendAuditEvent(tx, audit); err != nil {
+			slog.Error("Failed to append audit event", "error", err)
+			http.Error(w, "Failed to update member role", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = c.repo.CreateNotification(tx, &model.Notification{
+		UserID:  memberID,
+		GroupID: &groupID,
+		Kind:    model.NotificationKindRoleChanged,
+		Message: fmt.Sprintf("グループ「%s」での役割が%sになりました", group.Name, roleLabels[role]),
+	})
+	if err != nil {
+		slog.Error("Failed to create notification", "error", err, "user_id", memberID)
+		http.Error(w, "Failed to update member role", http.StatusInternalServerError)
+		return
+	}
+
+	pending := []realtime.Event{
+		realtime.NewEvent(realtime.EventMemberRoleChanged, groupID, realtime.RolePayload{
+			UserID: memberID,
+			Role:   string(role),
+		}),
+	}
+	if role == authz.RoleOwner {
+		pending = append(pending, realtime.NewEvent(realtime.EventMemberRoleChanged, groupID, realtime.RolePayload{
+			UserID: userID,
+			Role:   string(authz.RoleOrganiser),
+		}))
+	}
+	if err := recordEvents(tx, c.events, pending...); err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to update member role", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	response := MemberRoleResponse{
+		GroupID: groupID,
+		UserID:  memberID,
+		Role:    role,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -241,5 +1153,102 @@ func (c *GroupController) JoinGroupController(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	slog.Info("User joined group successfully", "group_id", req.GroupID, "user_id", userID)
+	slog.Info("Member role updated", "group_id", groupID, "user_id", memberID, "role", role, "updated_by", userID)
+}
+
+// waitlistPromoter は待ちリストから繰り上げるのに必要なリポジトリのメソッド
+type waitlistPromoter interface {
+	CountGroupMembers(tx *sql.Tx, groupID int64) (int64, error)
+	PopWaitlist(tx *sql.Tx, groupID int64) (int64, bool, error)
+	AddGroupMember(tx *sql.Tx, groupID, userID int64, role authz.Role) error
+	AppendAuditEvent(tx *sql.Tx, event *model.AuditEvent) error
+}
+
+// promoteFromWaitlist は定員に空きがある限り待ちリストの先頭からメンバーに繰り上げる｡
+// 締め切ったグループには繰り上げず､開き直したときに繰り上げる｡呼び出し側で LockGroup 済みであること
+func promoteFromWaitlist(tx *sql.Tx, repo waitlistPromoter, group *model.Group) ([]int64, error) {
+	if group.Status != model.GroupStatusOpen {
+		return nil, nil
+	}
+
+	var promotedUserIDs []int64
+	for {
+		if group.MaxMembers != nil {
+			memberCount, err := repo.CountGroupMembers(tx, group.ID)
+			if err != nil {
+				return nil, err
+			}
+			if memberCount >= *group.MaxMembers {
+				return promotedUserIDs, nil
+			}
+		}
+
+		promotedUserID, ok, err := repo.PopWaitlist(tx, group.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return promotedUserIDs, nil
+		}
+
+		err = repo.AddGroupMember(tx, group.ID, promotedUserID, authz.RoleMember)
+		if err != nil {
+			return nil, err
+		}
+
+		// 繰り上げは誰かの操作の結果なので､操作した人ではなくシステムの記録にする
+		err = repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionMemberPromoted, 0, group.ID, promotedUserID, nil))
+		if err != nil {
+			return nil, err
+		}
+		promotedUserIDs = append(promotedUserIDs, promotedUserID)
+	}
+}
+
+// updatedGroupFields は更新のリクエストに含まれていた項目の名前を返す｡値は個人の入力なので記録しない
+func updatedGroupFields(req UpdateGroupRequest) []string {
+	fields := []string{}
+	if req.Name != nil {
+		fields = append(fields, "name")
+	}
+	if req.Menu != nil {
+		fields = append(fields, "menu")
+	}
+	if req.MaxMembers.Set {
+		fields = append(fields, "max_members")
+	}
+	if req.Status != nil {
+		fields = append(fields, "status")
+	}
+	if req.SplitMethod != nil {
+		fields = append(fields, "split_method")
+	}
+	if req.Deadline.Set {
+		fields = append(fields, "deadline")
+	}
+	return fields
+}
+
+// notifyGroupFull はメンバー全員に定員に達したことを知らせる
+func (c *GroupController) notifyGroupFull(tx *sql.Tx, group *model.Group) error {
+	memberIDs, err := c.repo.ListGroupMemberIDs(tx, group.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, memberID := range memberIDs {
+		err := c.notifications.Enqueue(tx, notify.Message{
+			UserID: memberID,
+			Kind:   notify.KindGroupFull,
+			Params: map[string]any{
+				"group_name":  group.Name,
+				"max_members": *group.MaxMembers,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
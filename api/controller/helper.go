@@ -0,0 +1,287 @@
+package controller
+
+import (
+	"database/sql"
+	"domeal/authz"
+	"domeal/media"
+	"domeal/model"
+	"domeal/realtime"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// uuidPattern はDBで作ったUUIDの形｡パスから受け取ったIDをクエリに渡す前に確かめる
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// groupAccessChecker はグループへのアクセス権を確認するのに必要なリポジトリのメソッド
+type groupAccessChecker interface {
+	GetGroup(groupID int64) (*model.Group, error)
+	authz.RoleStore
+}
+
+// mediaGetter は画像がどのグループのものかを確認するのに必要なリポジトリのメソッド
+type mediaGetter interface {
+	GetMedia(id string) (*model.Media, error)
+}
+
+// OptionalInt64 はJSONでキーが省略されたのか null が指定されたのかを区別するための型
+type OptionalInt64 struct {
+	Set   bool
+	Value *int64
+}
+
+func (o *OptionalInt64) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Value = nil
+		return nil
+	}
+
+	var v int64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	o.Value = &v
+	return nil
+}
+
+// OptionalTime は OptionalInt64 の時刻版
+type OptionalTime struct {
+	Set   bool
+	Value *time.Time
+}
+
+func (o *OptionalTime) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Value = nil
+		return nil
+	}
+
+	var v time.Time
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	o.Value = &v
+	return nil
+}
+
+// OptionalString は OptionalInt64 の文字列版
+type OptionalString struct {
+	Set   bool
+	Value *string
+}
+
+func (o *OptionalString) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Value = nil
+		return nil
+	}
+
+	var v string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	o.Value = &v
+	return nil
+}
+
+// ValidationErrorResponse はフィールドごとのバリデーションエラーを返すレスポンス
+type ValidationErrorResponse struct {
+	Message string            `json:"message"`
+	Errors  map[string]string `json:"errors"`
+}
+
+// requireGroupPermission はグループが存在し､ユーザーの役割で action が許されていることを確認する｡
+// 条件を満たさない場合はエラーレスポンスを書き込んで false を返す
+func requireGroupPermission(w http.ResponseWriter, repo groupAccessChecker, groupID, userID int64, action authz.Action) bool {
+	_, err := repo.GetGroup(groupID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Group not found", "group_id", groupID)
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return false
+		}
+		slog.Error("Failed to get group", "error", err)
+		http.Error(w, "Failed to get group", http.StatusInternalServerError)
+		return false
+	}
+
+	role, err := authz.Check(repo, groupID, userID, action)
+	switch {
+	case errors.Is(err, authz.ErrNotMember):
+		slog.Error("User is not a member of this group", "group_id", groupID, "user_id", userID)
+		http.Error(w, "You are not a member of this group", http.StatusForbidden)
+		return false
+	case errors.Is(err, authz.ErrForbidden):
+		slog.Error("User's role does not allow this action", "group_id", groupID, "user_id", userID, "role", role, "action", action)
+		http.Error(w, "Your role in this group does not allow this action", http.StatusForbidden)
+		return false
+	case err != nil:
+		slog.Error("Failed to check group permission", "error", err)
+		http.Error(w, "Failed to check group permission", http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+// requireGroupMedia は imageURL が空か､groupID のグループに登録された画像であることを確認する｡
+// 他のグループの画像を付けるとこのグループのメンバーには見えないので受け付けない｡
+// 条件を満たさない場合はバリデーションエラーを書き込んで false を返す
+func requireGroupMedia(w http.ResponseWriter, repo mediaGetter, groupID int64, field, imageURL string) bool {
+	id, ok := mediaIDFromPath(imageURL)
+	if !ok {
+		return true
+	}
+
+	image, err := repo.GetMedia(id)
+	if err != nil && err != sql.ErrNoRows {
+		slog.Error("Failed to get media", "error", err)
+		http.Error(w, "Failed to get media", http.StatusInternalServerError)
+		return false
+	}
+	if err == sql.ErrNoRows || image.GroupID != groupID {
+		slog.Error("Media does not belong to this group", "group_id", groupID, "media_id", id)
+		writeValidationErrors(w, map[string]string{field: "must be an image uploaded to this group"})
+		return false
+	}
+
+	return true
+}
+
+// mediaIDFromPath は /api/media/{id} から画像のIDを取り出す
+func mediaIDFromPath(path string) (string, bool) {
+	id, ok := strings.CutPrefix(path, media.MediaPath+"/")
+	if !ok || !uuidPattern.MatchString(id) {
+		return "", false
+	}
+	return id, true
+}
+
+// pathID はパスパラメータを正の整数IDとして取り出す
+func pathID(r *http.Request, name string) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue(name), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if id <= 0 {
+		return 0, fmt.Errorf("invalid %s: %d", name, id)
+	}
+	return id, nil
+}
+
+func validateRequiredText(value string, maxLength int) string {
+	if value == "" {
+		return "is required"
+	}
+	if utf8.RuneCountInString(value) > maxLength {
+		return fmt.Sprintf("must be at most %d characters", maxLength)
+	}
+	return ""
+}
+
+func writeValidationErrors(w http.ResponseWriter, fieldErrors map[string]string) {
+	slog.Error("Validation failed", "errors", fieldErrors)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	if err := json.NewEncoder(w).Encode(ValidationErrorResponse{
+		Message: "Validation failed",
+		Errors:  fieldErrors,
+	}); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+	}
+}
+
+// recordEvents はイベントをトランザクション内で group_events に記録する｡
+// コミットされると各レプリカの購読者に届く
+func recordEvents(tx *sql.Tx, events realtime.Publisher, pending ...realtime.Event) error {
+	for _, event := range pending {
+		if err := events.Record(tx, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const (
+	// menuImagePrefix は料理の画像を置くキーの先頭
+	menuImagePrefix = "menu"
+	// receiptImagePrefix はレシートの画像を置くキーの先頭
+	receiptImagePrefix = "receipts"
+	// multipartOverhead は画像以外のフィールドと区切りのために上限に足す分
+	multipartOverhead = 1 << 20
+	// multipartMemory を超えるファイルは一時ファイルに書かれる
+	multipartMemory = 1 << 20
+)
+
+func isMultipart(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// readFormFile はフォームのファイルを読む｡ファイルがなければ nil を返す
+func readFormFile(r *http.Request, name string, maxSize int64) ([]byte, error) {
+	file, _, err := r.FormFile(name)
+	if err == http.ErrMissingFile {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, media.ErrTooLarge
+	}
+
+	return data, nil
+}
+
+// writeMultipartError は multipart/form-data を読めなかったときのレスポンスを書き込む
+func writeMultipartError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || errors.Is(err, media.ErrTooLarge) {
+		slog.Error("Upload is too large", "error", err)
+		http.Error(w, "Upload is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	slog.Error("Failed to parse multipart form", "error", err)
+	http.Error(w, "Invalid multipart form", http.StatusBadRequest)
+}
+
+// writeImageError は画像を保存できなかったときのレスポンスを書き込む
+func writeImageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, media.ErrTooLarge):
+		slog.Error("Image is too large", "error", err)
+		http.Error(w, "Image is too large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, media.ErrUnsupportedType):
+		slog.Error("Unsupported image type", "error", err)
+		http.Error(w, "Image must be JPEG, PNG, GIF or WebP", http.StatusUnsupportedMediaType)
+	case errors.Is(err, media.ErrInvalidImage):
+		slog.Error("Invalid image", "error", err)
+		http.Error(w, "Invalid image", http.StatusBadRequest)
+	default:
+		slog.Error("Failed to save image", "error", err)
+		http.Error(w, "Failed to save image", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"domeal/media"
+	"domeal/middleware"
+	"domeal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signedMediaURLExpiry は署名したURLの有効期限｡通知に埋め込んでも当日中は見られるようにする
+const signedMediaURLExpiry = 24 * time.Hour
+
+type MediaController struct {
+	db     *sql.DB
+	repo   model.MediaInterface
+	blobs  media.BlobStore
+	signer *media.Signer
+	cache  *media.Cache
+}
+
+func NewMediaController(db *sql.DB, repo model.MediaInterface, blobs media.BlobStore, signer *media.Signer, cache *media.Cache) *MediaController {
+	return &MediaController{
+		db:     db,
+		repo:   repo,
+		blobs:  blobs,
+		signer: signer,
+		cache:  cache,
+	}
+}
+
+type SignedMediaURLResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ServeMediaController は画像をグループのメンバーだけに返します｡
+// 署名されたURLならCookieなしで返します｡w で media.Widths の幅に縮小でき､Range にも対応します
+func (c *MediaController) ServeMediaController(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !uuidPattern.MatchString(id) {
+		http.NotFound(w, r)
+		return
+	}
+
+	width, ok := parseMediaWidth(w, r)
+	if !ok {
+		return
+	}
+
+	image, err := c.repo.GetMedia(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		slog.Error("Failed to get media", "error", err)
+		http.Error(w, "Failed to get media", http.StatusInternalServerError)
+		return
+	}
+
+	// 署名があればそれだけで確かめ､なければCookieのユーザーがメンバーか確かめる
+	cacheControl := "private, max-age=31536000, immutable"
+	if sig := r.URL.Query().Get("sig"); sig != "" {
+		expires := r.URL.Query().Get("expires")
+		if err := c.signer.Verify(id, width, expires, sig); err != nil {
+			slog.Error("Invalid media signature", "error", err, "media_id", id)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		unix, _ := strconv.ParseInt(expires, 10, 64)
+		cacheControl = fmt.Sprintf("private, max-age=%d", max(0, unix-time.Now().Unix()))
+	} else {
+		user, err := middleware.Authenticate(c.db, r)
+		if errors.Is(err, middleware.ErrMissingSession) || errors.Is(err, middleware.ErrInvalidSession) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			slog.Error("Failed to authenticate media request", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		_, err = authz.Check(c.repo, image.GroupID, int64(user.ID), authz.ViewGroup)
+		if errors.Is(err, authz.ErrNotMember) || errors.Is(err, authz.ErrForbidden) {
+			slog.Error("User cannot view this group", "group_id", image.GroupID, "user_id", user.ID)
+			http.Error(w, "You are not a member of this group", http.StatusForbidden)
+			return
+		} else if err != nil {
+			slog.Error("Failed to check group permission", "error", err)
+			http.Error(w, "Failed to check group permission", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// 画像は id ごとに変わらないので､幅と合わせればそのまま ETag にできる
+	etag := fmt.Sprintf(`"%s-%d"`, id, width)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	data, err := c.load(r.Context(), image, width)
+	if err != nil {
+		if err == media.ErrNotFound {
+			slog.Error("Media blob not found", "media_id", id, "key", image.ObjectKey)
+			http.NotFound(w, r)
+			return
+		}
+		slog.Error("Failed to load media", "error", err, "media_id", id)
+		http.Error(w, "Failed to load media", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", image.ContentType)
+	http.ServeContent(w, r, "", image.CreatedAt, bytes.NewReader(data))
+}
+
+// SignMediaController はメンバーに画像の署名付きURLを発行します
+func (c *MediaController) SignMediaController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	id := r.PathValue("id")
+	if !uuidPattern.MatchString(id) {
+		slog.Error("Invalid media ID", "media_id", id)
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	}
+
+	width, ok := parseMediaWidth(w, r)
+	if !ok {
+		return
+	}
+
+	image, err := c.repo.GetMedia(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Media not found", "media_id", id)
+			http.Error(w, "Media not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to get media", "error", err)
+		http.Error(w, "Failed to get media", http.StatusInternalServerError)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, image.GroupID, userID, authz.ViewGroup) {
+		return
+	}
+
+	url, expiresAt := c.signer.Sign(id, width, signedMediaURLExpiry)
+	response := SignedMediaURLResponse{
+		URL:       url,
+		ExpiresAt: expiresAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// load は幅 width の画像を返す｡縮小した画像はディスクにキャッシュしておく
+func (c *MediaController) load(ctx context.Context, image *model.Media, width int) ([]byte, error) {
+	if data, ok := c.cache.Get(image.ID, width); ok {
+		return data, nil
+	}
+
+	body, err := c.blobs.Get(ctx, image.ObjectKey)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, media.MaxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if width > 0 {
+		variant, err := media.Resize(data, width)
+		if err != nil {
+			return nil, err
+		}
+		data = variant.Data
+	}
+
+	c.cache.Put(image.ID, width, data)
+	return data, nil
+}
+
+// parseMediaWidth はクエリの w を読む｡省略されたら元の大きさを表す 0 を返す｡
+// media.Widths にない幅ならエラーレスポンスを書き込んで false を返す
+func parseMediaWidth(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("w")
+	if value == "" {
+		return 0, true
+	}
+
+	width, err := strconv.Atoi(value)
+	if err != nil || !slices.Contains(media.Widths, width) {
+		slog.Error("Invalid media width", "w", value)
+		http.Error(w, fmt.Sprintf("w must be one of %v", media.Widths), http.StatusBadRequest)
+		return 0, false
+	}
+
+	return width, true
+}
+
+// etagMatches は If-None-Match に etag が含まれるか返す
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,507 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"domeal/media"
+	"domeal/middleware"
+	"domeal/model"
+	"domeal/realtime"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+type MenuItemController struct {
+	repo   model.MenuItemInterface
+	events realtime.Publisher
+}
+
+func NewMenuItemController(repo model.MenuItemInterface, events realtime.Publisher) *MenuItemController {
+	return &MenuItemController{
+		repo:   repo,
+		events: events,
+	}
+}
+
+// MenuItemRequest は作成と更新で共通のリクエスト｡更新時は省略されたフィールドを変更しない
+type MenuItemRequest struct {
+	Name     *string `json:"name"`
+	Price    *int64  `json:"price"`
+	Quantity *int64  `json:"quantity"`
+	Unit     *string `json:"unit"`
+	ImageURL *string `json:"image_url"`
+}
+
+type ListMenuItemsResponse struct {
+	MenuItems []model.MenuItem `json:"menu_items"`
+}
+
+const defaultMenuItemUnit = "個"
+
+// newMenuItem は作成リクエストから MenuItem を組み立てる｡
+// 名前と価格は必須で､数量と単位は省略時に既定値を使う
+func (req *MenuItemRequest) newMenuItem(groupID int64, fieldPrefix string) (*model.MenuItem, map[string]string) {
+	item := &model.MenuItem{
+		GroupID:  groupID,
+		Quantity: 1,
+		Unit:     defaultMenuItemUnit,
+	}
+
+	fieldErrors := map[string]string{}
+	if req.Name == nil {
+		fieldErrors[fieldPrefix+"name"] = "is required"
+	}
+	if req.Price == nil {
+		fieldErrors[fieldPrefix+"price"] = "is required"
+	}
+
+	for field, msg := range req.applyTo(item, fieldPrefix) {
+		fieldErrors[field] = msg
+	}
+
+	return item, fieldErrors
+}
+
+// applyTo はリクエストで指定されたフィールドをバリデーションしながら item に反映する
+func (req *MenuItemRequest) applyTo(item *model.MenuItem, fieldPrefix string) map[string]string {
+	fieldErrors := map[string]string{}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if msg := validateRequiredText(name, 255); msg != "" {
+			fieldErrors[fieldPrefix+"name"] = msg
+		}
+		item.Name = name
+	}
+
+	if req.Price != nil {
+		if *req.Price < 0 {
+			fieldErrors[fieldPrefix+"price"] = "must not be negative"
+		}
+		item.Price = *req.Price
+	}
+
+	if req.Quantity != nil {
+		if *req.Quantity <= 0 {
+			fieldErrors[fieldPrefix+"quantity"] = "must be positive"
+		}
+		item.Quantity = *req.Quantity
+	}
+
+	if req.Unit != nil {
+		unit := strings.TrimSpace(*req.Unit)
+		if msg := validateRequiredText(unit, 32); msg != "" {
+			fieldErrors[fieldPrefix+"unit"] = msg
+		}
+		item.Unit = unit
+	}
+
+	if req.ImageURL != nil {
+		imageURL := strings.TrimSpace(*req.ImageURL)
+		if msg := validateImageURL(imageURL); msg != "" {
+			fieldErrors[fieldPrefix+"image_url"] = msg
+		}
+		item.ImageURL = imageURL
+	}
+
+	return fieldErrors
+}
+
+// lockOpenGroup は注文と競合しないようにグループをロックし､まだ締め切られていないことを確認する｡
+// 締め切ったあとに料理を変えると､確定した注文や精算の金額が変わってしまう｡
+// 条件を満たさない場合はエラーレスポンスを書き込んで false を返す
+func (c *MenuItemController) lockOpenGroup(w http.ResponseWriter, tx *sql.Tx, groupID int64) (*model.Group, bool) {
+	group, err := c.repo.LockGroup(tx, groupID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Group not found", "group_id", groupID)
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return nil, false
+		}
+		slog.Error("Failed to lock group", "error", err)
+		http.Error(w, "Failed to lock group", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if group.Status != model.GroupStatusOpen {
+		slog.Error("Group is not open for menu changes", "group_id", groupID, "status", group.Status)
+		http.Error(w, "The menu of a closed group cannot be changed", http.StatusConflict)
+		return nil, false
+	}
+
+	return group, true
+}
+
+// validateImageURL は空文字(画像なし)か､アップロードを確定したときに発行された /api/media/{id} であることを確認する｡
+// 外部のURLはメンバー以外にも見えてしまうので受け付けない
+func validateImageURL(imageURL string) string {
+	if imageURL == "" {
+		return ""
+	}
+	if _, ok := mediaIDFromPath(imageURL); !ok {
+		return "must be a " + media.MediaPath + "/{id} path returned by confirming an upload"
+	}
+	return ""
+}
+
+// summarizeMenuItems は旧クライアント向けの menu 文字列を料理名から作る
+func summarizeMenuItems(items []*model.MenuItem) string {
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+
+	summary := strings.Join(names, "、")
+	if utf8.RuneCountInString(summary) > 255 {
+		summary = string([]rune(summary)[:254]) + "…"
+	}
+	return summary
+}
+
+func (c *MenuItemController) ListMenuItemsController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.ViewGroup) {
+		return
+	}
+
+	items, err := c.repo.ListMenuItems(groupID)
+	if err != nil {
+		slog.Error("Failed to list menu items", "error", err)
+		http.Error(w, "Failed to list menu items", http.StatusInternalServerError)
+		return
+	}
+
+	response := ListMenuItemsResponse{
+		MenuItems: items,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *MenuItemController) CreateMenuItemController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.EditMenu) {
+		return
+	}
+
+	// リクエストボディをパース
+	var req MenuItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// バリデーション
+	item, fieldErrors := req.newMenuItem(groupID, "")
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	if !requireGroupMedia(w, c.repo, groupID, "image_url", item.ImageURL) {
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	group, ok := c.lockOpenGroup(w, tx, groupID)
+	if !ok {
+		return
+	}
+
+	err = c.repo.CreateMenuItem(tx, item)
+	if err != nil {
+		slog.Error("Failed to create menu item", "error", err)
+		http.Error(w, "Failed to create menu item", http.StatusInternalServerError)
+		return
+	}
+
+	pending := []realtime.Event{
+		realtime.NewEvent(realtime.EventGroupUpdated, groupID, group),
+	}
+	if err := recordEvents(tx, c.events, pending...); err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to create menu item", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(item); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Menu item created successfully", "group_id", groupID, "menu_item_id", item.ID, "user_id", userID)
+}
+
+func (c *MenuItemController) UpdateMenuItemController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	itemID, err := pathID(r, "itemID")
+	if err != nil {
+		slog.Error("Invalid menu item ID", "error", err)
+		http.Error(w, "Valid menu item ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.EditMenu) {
+		return
+	}
+
+	item, err := c.repo.GetMenuItem(groupID, itemID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Menu item not found", "group_id", groupID, "menu_item_id", itemID)
+			http.Error(w, "Menu item not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to get menu item", "error", err)
+		http.Error(w, "Failed to get menu item", http.StatusInternalServerError)
+		return
+	}
+
+	// リクエストボディをパース
+	var req MenuItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// バリデーション
+	if fieldErrors := req.applyTo(item, ""); len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	if req.ImageURL != nil && !requireGroupMedia(w, c.repo, groupID, "image_url", item.ImageURL) {
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	group, ok := c.lockOpenGroup(w, tx, groupID)
+	if !ok {
+		return
+	}
+
+	err = c.repo.UpdateMenuItem(tx, item)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Menu item not found", "group_id", groupID, "menu_item_id", itemID)
+			http.Error(w, "Menu item not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to update menu item", "error", err)
+		http.Error(w, "Failed to update menu item", http.StatusInternalServerError)
+		return
+	}
+
+	// 値段が変われば注文した人の金額も変わるので､クライアントには注文を取り直してもらう
+	pending := []realtime.Event{
+		realtime.NewEvent(realtime.EventGroupUpdated, groupID, group),
+	}
+	if err := recordEvents(tx, c.events, pending...); err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to update menu item", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(item); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Menu item updated successfully", "group_id", groupID, "menu_item_id", itemID, "user_id", userID)
+}
+
+func (c *MenuItemController) DeleteMenuItemController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	itemID, err := pathID(r, "itemID")
+	if err != nil {
+		slog.Error("Invalid menu item ID", "error", err)
+		http.Error(w, "Valid menu item ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.EditMenu) {
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	group, ok := c.lockOpenGroup(w, tx, groupID)
+	if !ok {
+		return
+	}
+
+	// 料理を消すとその料理の注文も消えるので､誰の注文が変わるかを先に調べておく
+	orderers, err := c.repo.ListMenuItemOrderers(tx, groupID, itemID)
+	if err != nil {
+		slog.Error("Failed to list menu item orders", "error", err)
+		http.Error(w, "Failed to delete menu item", http.StatusInternalServerError)
+		return
+	}
+
+	deleted, err := c.repo.DeleteMenuItem(tx, groupID, itemID)
+	if err != nil {
+		slog.Error("Failed to delete menu item", "error", err)
+		http.Error(w, "Failed to delete menu item", http.StatusInternalServerError)
+		return
+	}
+
+	if !deleted {
+		slog.Error("Menu item not found", "group_id", groupID, "menu_item_id", itemID)
+		http.Error(w, "Menu item not found", http.StatusNotFound)
+		return
+	}
+
+	pending := []realtime.Event{
+		realtime.NewEvent(realtime.EventGroupUpdated, groupID, group),
+	}
+	for _, orderer := range orderers {
+		// ほかの料理を頼んでいなければ注文がなくなる
+		if orderer.Quantity == 0 {
+			pending = append(pending, realtime.NewEvent(realtime.EventOrderCancelled, groupID, realtime.OrderPayload{
+				UserID: orderer.UserID,
+			}))
+			continue
+		}
+		pending = append(pending, realtime.NewEvent(realtime.EventOrderUpdated, groupID, realtime.OrderPayload{
+			UserID:   orderer.UserID,
+			Quantity: orderer.Quantity,
+		}))
+	}
+	if err := recordEvents(tx, c.events, pending...); err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to delete menu item", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	slog.Info("Menu item deleted successfully", "group_id", groupID, "menu_item_id", itemID, "user_id", userID)
+}
+
+// menuItemFieldPrefix は CreateGroupRequest の menu_items[i] のエラーに付けるプレフィックス
+func menuItemFieldPrefix(i int) string {
+	return fmt.Sprintf("menu_items[%d].", i)
+}
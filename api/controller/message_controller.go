@@ -0,0 +1,490 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"domeal/middleware"
+	"domeal/model"
+	"domeal/realtime"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultMessagePageSize = 50
+	maxMessagePageSize     = 100
+	maxMessageLength       = 2000
+)
+
+type MessageController struct {
+	repo   model.MessageInterface
+	events realtime.Publisher
+}
+
+func NewMessageController(repo model.MessageInterface, events realtime.Publisher) *MessageController {
+	return &MessageController{
+		repo:   repo,
+		events: events,
+	}
+}
+
+type MessageRequest struct {
+	Body string `json:"body"`
+}
+
+type MarkMessagesReadRequest struct {
+	MessageID int64 `json:"message_id"`
+}
+
+// ListMessagesResponse の next_before を before に渡すと続きを読める｡続きがなければ null
+type ListMessagesResponse struct {
+	Messages   []model.Message     `json:"messages"`
+	NextBefore *int64              `json:"next_before"`
+	Reads      []model.MessageRead `json:"reads"`
+}
+
+// ListMessagesController はメッセージを新しい順に返します｡?before=<id>&limit=<n> でさかのぼれます
+func (c *MessageController) ListMessagesController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	fieldErrors := map[string]string{}
+	var beforeID int64
+	if v := r.URL.Query().Get("before"); v != "" {
+		beforeID, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || beforeID <= 0 {
+			fieldErrors["before"] = "must be a valid message ID"
+		}
+	}
+
+	limit := defaultMessagePageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxMessagePageSize {
+			fieldErrors["limit"] = "must be between 1 and 100"
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.ViewGroup) {
+		return
+	}
+
+	// 1件多く読んで続きがあるかを判断する
+	messages, err := c.repo.ListMessages(groupID, beforeID, limit+1)
+	if err != nil {
+		slog.Error("Failed to list messages", "error", err)
+		http.Error(w, "Failed to list messages", http.StatusInternalServerError)
+		return
+	}
+
+	response := ListMessagesResponse{
+		Messages: messages,
+	}
+	if len(messages) > limit {
+		response.Messages = messages[:limit]
+		response.NextBefore = &response.Messages[limit-1].ID
+	}
+
+	response.Reads, err = c.repo.ListMessageReads(groupID)
+	if err != nil {
+		slog.Error("Failed to list message reads", "error", err)
+		http.Error(w, "Failed to list messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *MessageController) PostMessageController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.PostMessage) {
+		return
+	}
+
+	// リクエストボディをパース
+	var req MessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// バリデーション
+	body := strings.TrimSpace(req.Body)
+	if msg := validateRequiredText(body, maxMessageLength); msg != "" {
+		writeValidationErrors(w, map[string]string{"body": msg})
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	message := &model.Message{
+		GroupID:     groupID,
+		UserID:      userID,
+		DisplayName: tmpUser.DisplayName,
+		Body:        body,
+	}
+	err = c.repo.CreateMessage(tx, message)
+	if err != nil {
+		slog.Error("Failed to create message", "error", err)
+		http.Error(w, "Failed to post message", http.StatusInternalServerError)
+		return
+	}
+
+	err = recordEvents(tx, c.events, realtime.NewEvent(realtime.EventMessagePosted, groupID, message))
+	if err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to post message", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(message); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Message posted successfully", "group_id", groupID, "message_id", message.ID, "user_id", userID)
+}
+
+func (c *MessageController) UpdateMessageController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	messageID, err := pathID(r, "messageID")
+	if err != nil {
+		slog.Error("Invalid message ID", "error", err)
+		http.Error(w, "Valid message ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.PostMessage) {
+		return
+	}
+
+	message, ok := c.getOwnMessage(w, groupID, messageID, userID)
+	if !ok {
+		return
+	}
+
+	// リクエストボディをパース
+	var req MessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// バリデーション
+	message.Body = strings.TrimSpace(req.Body)
+	if msg := validateRequiredText(message.Body, maxMessageLength); msg != "" {
+		writeValidationErrors(w, map[string]string{"body": msg})
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	err = c.repo.UpdateMessage(tx, message)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Message not found", "group_id", groupID, "message_id", messageID)
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to update message", "error", err)
+		http.Error(w, "Failed to update message", http.StatusInternalServerError)
+		return
+	}
+
+	err = recordEvents(tx, c.events, realtime.NewEvent(realtime.EventMessageEdited, groupID, message))
+	if err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to update message", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(message); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Message updated successfully", "group_id", groupID, "message_id", messageID, "user_id", userID)
+}
+
+func (c *MessageController) DeleteMessageController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	messageID, err := pathID(r, "messageID")
+	if err != nil {
+		slog.Error("Invalid message ID", "error", err)
+		http.Error(w, "Valid message ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.PostMessage) {
+		return
+	}
+
+	if _, ok := c.getOwnMessage(w, groupID, messageID, userID); !ok {
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	deleted, err := c.repo.DeleteMessage(tx, groupID, messageID)
+	if err != nil {
+		slog.Error("Failed to delete message", "error", err)
+		http.Error(w, "Failed to delete message", http.StatusInternalServerError)
+		return
+	}
+
+	if !deleted {
+		slog.Error("Message not found", "group_id", groupID, "message_id", messageID)
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	err = recordEvents(tx, c.events, realtime.NewEvent(realtime.EventMessageDeleted, groupID, realtime.MessagePayload{
+		MessageID: messageID,
+		UserID:    userID,
+	}))
+	if err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to delete message", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	slog.Info("Message deleted successfully", "group_id", groupID, "message_id", messageID, "user_id", userID)
+}
+
+// MarkMessagesReadController は指定したメッセージまでを既読にします
+func (c *MessageController) MarkMessagesReadController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.ViewGroup) {
+		return
+	}
+
+	// リクエストボディをパース
+	var req MarkMessagesReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.MessageID <= 0 {
+		writeValidationErrors(w, map[string]string{"message_id": "is required"})
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	read, err := c.repo.MarkMessagesRead(tx, groupID, userID, req.MessageID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Message not found", "group_id", groupID, "message_id", req.MessageID)
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to mark messages as read", "error", err)
+		http.Error(w, "Failed to mark messages as read", http.StatusInternalServerError)
+		return
+	}
+	read.DisplayName = tmpUser.DisplayName
+
+	err = recordEvents(tx, c.events, realtime.NewEvent(realtime.EventMessagesRead, groupID, realtime.MessageReadPayload{
+		UserID:            userID,
+		LastReadMessageID: read.LastReadMessageID,
+	}))
+	if err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to mark messages as read", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(read); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// getOwnMessage は自分が投稿して削除されていないメッセージを返す｡
+// 条件を満たさない場合はエラーレスポンスを書き込んで false を返す
+func (c *MessageController) getOwnMessage(w http.ResponseWriter, groupID, messageID, userID int64) (*model.Message, bool) {
+	message, err := c.repo.GetMessage(groupID, messageID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Message not found", "group_id", groupID, "message_id", messageID)
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return nil, false
+		}
+		slog.Error("Failed to get message", "error", err)
+		http.Error(w, "Failed to get message", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if message.Deleted {
+		slog.Error("Message already deleted", "group_id", groupID, "message_id", messageID)
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	if message.UserID != userID {
+		slog.Error("User cannot change this message", "group_id", groupID, "message_id", messageID, "user_id", userID)
+		http.Error(w, "You can only change your own messages", http.StatusForbidden)
+		return nil, false
+	}
+
+	return message, true
+}
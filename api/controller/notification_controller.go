@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"context"
+	"domeal/middleware"
+	"domeal/model"
+	"domeal/notify"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+type NotificationController struct {
+	repo model.NotificationInterface
+}
+
+func NewNotificationController(repo model.NotificationInterface) *NotificationController {
+	return &NotificationController{
+		repo: repo,
+	}
+}
+
+type ListNotificationsResponse struct {
+	Notifications []model.Notification `json:"notifications"`
+}
+
+// NotificationSettings は通知の種類ごとに LINE で受け取るかどうか
+type NotificationSettings struct {
+	Settings map[string]bool `json:"settings"`
+}
+
+// ListNotificationsController はログインユーザーへのお知らせを新しい順に返します
+func (c *NotificationController) ListNotificationsController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	notifications, err := c.repo.ListNotifications(userID, 50)
+	if err != nil {
+		slog.Error("Failed to list notifications", "error", err)
+		http.Error(w, "Failed to list notifications", http.StatusInternalServerError)
+		return
+	}
+
+	response := ListNotificationsResponse{
+		Notifications: notifications,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// MarkNotificationsReadController は未読のお知らせをすべて既読にします
+func (c *NotificationController) MarkNotificationsReadController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	if err := c.repo.MarkNotificationsRead(userID); err != nil {
+		slog.Error("Failed to mark notifications as read", "error", err)
+		http.Error(w, "Failed to mark notifications as read", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetNotificationSettingsController は LINE で受け取る通知の設定を返します
+func (c *NotificationController) GetNotificationSettingsController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	c.writeNotificationSettings(w, userID)
+}
+
+// UpdateNotificationSettingsController は指定された種類の通知の受け取りを切り替えます｡
+// 指定されなかった種類はそのまま
+func (c *NotificationController) UpdateNotificationSettingsController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	// リクエストボディをパース
+	var req NotificationSettings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// バリデーション
+	fieldErrors := map[string]string{}
+	for kind := range req.Settings {
+		if !notify.IsKind(kind) {
+			fieldErrors[fmt.Sprintf("settings.%s", kind)] = "is not a notification kind"
+		}
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for kind, enabled := range req.Settings {
+		if err := c.repo.SetNotificationOptOut(tx, userID, kind, !enabled); err != nil {
+			slog.Error("Failed to update notification settings", "error", err)
+			http.Error(w, "Failed to update notification settings", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	c.writeNotificationSettings(w, userID)
+
+	slog.Info("Notification settings updated", "user_id", userID, "settings", req.Settings)
+}
+
+func (c *NotificationController) writeNotificationSettings(w http.ResponseWriter, userID int64) {
+	optOuts, err := c.repo.ListNotificationOptOuts(userID)
+	if err != nil {
+		slog.Error("Failed to list notification opt-outs", "error", err)
+		http.Error(w, "Failed to get notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	response := NotificationSettings{
+		Settings: map[string]bool{},
+	}
+	for _, kind := range notify.Kinds {
+		response.Settings[kind] = true
+	}
+	for _, kind := range optOuts {
+		if _, ok := response.Settings[kind]; ok {
+			response.Settings[kind] = false
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
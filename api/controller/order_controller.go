@@ -0,0 +1,361 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"domeal/middleware"
+	"domeal/model"
+	"domeal/realtime"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"unicode/utf8"
+)
+
+type OrderController struct {
+	repo   model.OrderInterface
+	events realtime.Publisher
+}
+
+func NewOrderController(repo model.OrderInterface, events realtime.Publisher) *OrderController {
+	return &OrderController{
+		repo:   repo,
+		events: events,
+	}
+}
+
+type PlaceOrderItem struct {
+	MenuItemID int64  `json:"menu_item_id"`
+	Quantity   int64  `json:"quantity"`
+	Note       string `json:"note"`
+}
+
+// PlaceOrderRequest は自分の注文をまるごと置き換える
+type PlaceOrderRequest struct {
+	Items []PlaceOrderItem `json:"items"`
+}
+
+type MemberOrderResponse struct {
+	GroupID int64             `json:"group_id"`
+	UserID  int64             `json:"user_id"`
+	Lines   []model.OrderLine `json:"lines"`
+	Total   int64             `json:"total"`
+}
+
+func (c *OrderController) GetMyOrderController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.ViewGroup) {
+		return
+	}
+
+	c.writeMemberOrder(w, http.StatusOK, groupID, userID)
+}
+
+func (c *OrderController) PlaceOrderController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.PlaceOrder) {
+		return
+	}
+
+	// リクエストボディをパース
+	var req PlaceOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// バリデーション
+	fieldErrors := map[string]string{}
+	if len(req.Items) == 0 {
+		fieldErrors["items"] = "is required"
+	}
+
+	lines := make([]model.OrderLine, 0, len(req.Items))
+	seen := map[int64]bool{}
+	for i, item := range req.Items {
+		prefix := fmt.Sprintf("items[%d].", i)
+		if item.MenuItemID <= 0 {
+			fieldErrors[prefix+"menu_item_id"] = "is required"
+		} else if seen[item.MenuItemID] {
+			fieldErrors[prefix+"menu_item_id"] = "is duplicated"
+		}
+		seen[item.MenuItemID] = true
+
+		if item.Quantity <= 0 {
+			fieldErrors[prefix+"quantity"] = "must be positive"
+		}
+		if utf8.RuneCountInString(item.Note) > 500 {
+			fieldErrors[prefix+"note"] = "must be at most 500 characters"
+		}
+
+		lines = append(lines, model.OrderLine{
+			MenuItemID: item.MenuItemID,
+			Quantity:   item.Quantity,
+			Note:       item.Note,
+		})
+	}
+
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	groupMemberID, ok := c.lockOpenGroupMember(w, tx, groupID, userID)
+	if !ok {
+		return
+	}
+
+	err = c.repo.ReplaceMemberOrder(tx, groupID, groupMemberID, lines)
+	if err != nil {
+		if errors.Is(err, model.ErrMenuItemNotInGroup) {
+			writeValidationErrors(w, map[string]string{
+				"items": "contains a menu item that does not belong to this group",
+			})
+			return
+		}
+		slog.Error("Failed to place order", "error", err)
+		http.Error(w, "Failed to place order", http.StatusInternalServerError)
+		return
+	}
+
+	var quantity int64
+	for _, line := range lines {
+		quantity += line.Quantity
+	}
+	pending := []realtime.Event{
+		realtime.NewEvent(realtime.EventOrderUpdated, groupID, realtime.OrderPayload{
+			UserID:   userID,
+			Quantity: quantity,
+		}),
+	}
+	if err := recordEvents(tx, c.events, pending...); err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to place order", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	c.writeMemberOrder(w, http.StatusOK, groupID, userID)
+
+	slog.Info("Order placed successfully", "group_id", groupID, "user_id", userID)
+}
+
+func (c *OrderController) CancelOrderController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.PlaceOrder) {
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	groupMemberID, ok := c.lockOpenGroupMember(w, tx, groupID, userID)
+	if !ok {
+		return
+	}
+
+	deleted, err := c.repo.DeleteMemberOrder(tx, groupMemberID)
+	if err != nil {
+		slog.Error("Failed to cancel order", "error", err)
+		http.Error(w, "Failed to cancel order", http.StatusInternalServerError)
+		return
+	}
+
+	if !deleted {
+		slog.Error("Order not found", "group_id", groupID, "user_id", userID)
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	pending := []realtime.Event{
+		realtime.NewEvent(realtime.EventOrderCancelled, groupID, realtime.OrderPayload{
+			UserID: userID,
+		}),
+	}
+	if err := recordEvents(tx, c.events, pending...); err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to cancel order", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	slog.Info("Order cancelled successfully", "group_id", groupID, "user_id", userID)
+}
+
+// GetOrderSummaryController はオーナーと幹事向けに料理ごとの合計数量とメンバーごとの注文を返します
+func (c *OrderController) GetOrderSummaryController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.ViewOrderSummary) {
+		return
+	}
+
+	summary, err := c.repo.GetOrderSummary(groupID)
+	if err != nil {
+		slog.Error("Failed to get order summary", "error", err)
+		http.Error(w, "Failed to get order summary", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// lockOpenGroupMember はグループをロックして受付中であることを確かめ､group_members の ID を返す｡
+// 注文できない場合はエラーレスポンスを書き込んで false を返す
+func (c *OrderController) lockOpenGroupMember(w http.ResponseWriter, tx *sql.Tx, groupID, userID int64) (int64, bool) {
+	group, err := c.repo.LockGroup(tx, groupID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Group not found", "group_id", groupID)
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return 0, false
+		}
+		slog.Error("Failed to lock group", "error", err)
+		http.Error(w, "Failed to lock group", http.StatusInternalServerError)
+		return 0, false
+	}
+
+	if group.Status != model.GroupStatusOpen {
+		slog.Error("Group is not open for orders", "group_id", groupID, "status", group.Status)
+		http.Error(w, "The group is no longer accepting orders", http.StatusConflict)
+		return 0, false
+	}
+
+	groupMemberID, err := c.repo.GetGroupMemberID(tx, groupID, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("User is not a member of this group", "group_id", groupID, "user_id", userID)
+			http.Error(w, "You are not a member of this group", http.StatusForbidden)
+			return 0, false
+		}
+		slog.Error("Failed to get group member", "error", err)
+		http.Error(w, "Failed to get group member", http.StatusInternalServerError)
+		return 0, false
+	}
+
+	return groupMemberID, true
+}
+
+func (c *OrderController) writeMemberOrder(w http.ResponseWriter, status int, groupID, userID int64) {
+	lines, err := c.repo.ListMemberOrder(groupID, userID)
+	if err != nil {
+		slog.Error("Failed to get order", "error", err)
+		http.Error(w, "Failed to get order", http.StatusInternalServerError)
+		return
+	}
+
+	response := MemberOrderResponse{
+		GroupID: groupID,
+		UserID:  userID,
+		Lines:   lines,
+	}
+	for _, line := range lines {
+		response.Total += line.Subtotal
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
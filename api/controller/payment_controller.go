@@ -0,0 +1,397 @@
+package controller
+
+import (
+	"context"
+	"domeal/authz"
+	"domeal/middleware"
+	"domeal/model"
+	"domeal/realtime"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+type PaymentController struct {
+	repo   model.PaymentInterface
+	events realtime.Publisher
+}
+
+func NewPaymentController(repo model.PaymentInterface, events realtime.Publisher) *PaymentController {
+	return &PaymentController{
+		repo:   repo,
+		events: events,
+	}
+}
+
+// MarkPaidRequest の to_user_id は送金先が1人だけなら省略できる
+type MarkPaidRequest struct {
+	ToUserID int64 `json:"to_user_id"`
+}
+
+// ConfirmPaymentRequest の to_user_id は省略するとログインユーザーが受け取った送金を確認する
+type ConfirmPaymentRequest struct {
+	ToUserID int64 `json:"to_user_id"`
+}
+
+type PaymentStatusesResponse struct {
+	GroupID  int64                 `json:"group_id"`
+	Payments []model.PaymentStatus `json:"payments"`
+	// OutstandingTotal はまだ確認されていない送金の合計
+	OutstandingTotal int64 `json:"outstanding_total"`
+}
+
+// GetMyPaymentsController はログインユーザーが払う､または受け取る送金とPayPal.meのリンクを返します
+func (c *PaymentController) GetMyPaymentsController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.ViewGroup) {
+		return
+	}
+
+	statuses, ok := c.paymentStatuses(w, groupID)
+	if !ok {
+		return
+	}
+
+	mine := []model.PaymentStatus{}
+	for _, status := range statuses {
+		if status.FromUserID == userID || status.ToUserID == userID {
+			mine = append(mine, status)
+		}
+	}
+
+	writePaymentStatuses(w, groupID, mine)
+}
+
+// ListPaymentsController はオーナーと幹事向けにグループ全体の支払い状況を返します
+func (c *PaymentController) ListPaymentsController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.ManagePayments) {
+		return
+	}
+
+	statuses, ok := c.paymentStatuses(w, groupID)
+	if !ok {
+		return
+	}
+
+	writePaymentStatuses(w, groupID, statuses)
+}
+
+// MarkPaidController は送金した側が支払い済みにします｡金額は現在の精算額で記録します
+func (c *PaymentController) MarkPaidController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.RecordPayment) {
+		return
+	}
+
+	// リクエストボディをパース
+	var req MarkPaidRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.Error("Failed to decode request body", "error", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	statuses, ok := c.paymentStatuses(w, groupID)
+	if !ok {
+		return
+	}
+
+	var candidates []model.PaymentStatus
+	for _, status := range statuses {
+		if status.FromUserID != userID {
+			continue
+		}
+		if req.ToUserID != 0 && status.ToUserID != req.ToUserID {
+			continue
+		}
+		candidates = append(candidates, status)
+	}
+
+	if len(candidates) == 0 {
+		slog.Error("No payment is due", "group_id", groupID, "user_id", userID, "to_user_id", req.ToUserID)
+		http.Error(w, "You have no payment due", http.StatusNotFound)
+		return
+	}
+
+	if len(candidates) > 1 {
+		writeValidationErrors(w, map[string]string{
+			"to_user_id": "is required when you have several payments due",
+		})
+		return
+	}
+	target := candidates[0]
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	err = c.repo.MarkPaymentPaid(tx, groupID, userID, target.ToUserID, target.Amount)
+	if err != nil {
+		slog.Error("Failed to mark payment as paid", "error", err)
+		http.Error(w, "Failed to mark payment as paid", http.StatusInternalServerError)
+		return
+	}
+
+	err = c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionPaymentPaid, userID, groupID, userID, map[string]any{
+		"payee_id": target.ToUserID,
+		"amount":   target.Amount,
+	}))
+	if err != nil {
+		slog.Error("Failed to append audit event", "error", err)
+		http.Error(w, "Failed to mark payment as paid", http.StatusInternalServerError)
+		return
+	}
+
+	pending := []realtime.Event{
+		realtime.NewEvent(realtime.EventPaymentUpdated, groupID, realtime.PaymentPayload{
+			PayerID: userID,
+			PayeeID: target.ToUserID,
+			Amount:  target.Amount,
+			Status:  model.PaymentStatusPaid,
+		}),
+	}
+	if err := recordEvents(tx, c.events, pending...); err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to mark payment as paid", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	slog.Info("Payment marked as paid", "group_id", groupID, "user_id", userID, "to_user_id", target.ToUserID, "amount", target.Amount)
+}
+
+// ConfirmPaymentController は受け取った側か､オーナーや幹事が送金を確認します
+func (c *PaymentController) ConfirmPaymentController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	payerID, err := pathID(r, "userID")
+	if err != nil {
+		slog.Error("Invalid user ID", "error", err)
+		http.Error(w, "Valid user ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.RecordPayment) {
+		return
+	}
+
+	// リクエストボディをパース
+	var req ConfirmPaymentRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.Error("Failed to decode request body", "error", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	payeeID := req.ToUserID
+	if payeeID == 0 {
+		payeeID = userID
+	}
+
+	// 受け取った本人以外は支払いを管理できる役割の人だけが確認できる
+	if payeeID != userID {
+		allowed, err := authz.Can(c.repo, groupID, userID, authz.ManagePayments)
+		if err != nil {
+			slog.Error("Failed to check group permission", "error", err)
+			http.Error(w, "Failed to check group permission", http.StatusInternalServerError)
+			return
+		}
+
+		if !allowed {
+			slog.Error("User cannot confirm this payment", "group_id", groupID, "user_id", userID, "payee_id", payeeID)
+			http.Error(w, "Only the payee or an organiser can confirm this payment", http.StatusForbidden)
+			return
+		}
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	confirmed, err := c.repo.ConfirmPayment(tx, groupID, payerID, payeeID)
+	if err != nil {
+		slog.Error("Failed to confirm payment", "error", err)
+		http.Error(w, "Failed to confirm payment", http.StatusInternalServerError)
+		return
+	}
+
+	if !confirmed {
+		slog.Error("Payment not found", "group_id", groupID, "payer_id", payerID, "payee_id", payeeID)
+		http.Error(w, "Payment not found", http.StatusNotFound)
+		return
+	}
+
+	// 確認した人が受け取った人と違うことがあるので､支払った人を記録の対象にする
+	err = c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionPaymentConfirmed, userID, groupID, payerID, map[string]any{
+		"payee_id": payeeID,
+	}))
+	if err != nil {
+		slog.Error("Failed to append audit event", "error", err)
+		http.Error(w, "Failed to confirm payment", http.StatusInternalServerError)
+		return
+	}
+
+	pending := []realtime.Event{
+		realtime.NewEvent(realtime.EventPaymentUpdated, groupID, realtime.PaymentPayload{
+			PayerID: payerID,
+			PayeeID: payeeID,
+			Status:  model.PaymentStatusConfirmed,
+		}),
+	}
+	if err := recordEvents(tx, c.events, pending...); err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to confirm payment", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	slog.Info("Payment confirmed", "group_id", groupID, "payer_id", payerID, "payee_id", payeeID, "user_id", userID)
+}
+
+// paymentStatuses はグループの現在の精算と支払いの記録から支払い状況を作る｡
+// 失敗した場合はエラーレスポンスを書き込んで false を返す
+func (c *PaymentController) paymentStatuses(w http.ResponseWriter, groupID int64) ([]model.PaymentStatus, bool) {
+	group, err := c.repo.GetGroup(groupID)
+	if err != nil {
+		slog.Error("Failed to get group", "error", err)
+		http.Error(w, "Failed to get group", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	settlement, err := c.repo.GetSettlement(groupID, group.SplitMethod)
+	if err != nil {
+		if model.IsSplitError(err) {
+			writeValidationErrors(w, map[string]string{
+				"split_method": err.Error(),
+			})
+			return nil, false
+		}
+		slog.Error("Failed to get settlement", "error", err)
+		http.Error(w, "Failed to get settlement", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	payments, err := c.repo.ListPayments(groupID)
+	if err != nil {
+		slog.Error("Failed to list payments", "error", err)
+		http.Error(w, "Failed to list payments", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	usernames, err := c.repo.ListGroupPayPalMeUsernames(groupID)
+	if err != nil {
+		slog.Error("Failed to list paypal.me usernames", "error", err)
+		http.Error(w, "Failed to list paypal.me usernames", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return model.BuildPaymentStatuses(settlement, payments, usernames), true
+}
+
+func writePaymentStatuses(w http.ResponseWriter, groupID int64, statuses []model.PaymentStatus) {
+	response := PaymentStatusesResponse{
+		GroupID:  groupID,
+		Payments: statuses,
+	}
+	for _, status := range statuses {
+		response.OutstandingTotal += status.Outstanding
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
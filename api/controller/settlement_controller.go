@@ -0,0 +1,248 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"domeal/middleware"
+	"domeal/model"
+	"domeal/realtime"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+type SettlementController struct {
+	repo   model.SettlementInterface
+	events realtime.Publisher
+}
+
+func NewSettlementController(repo model.SettlementInterface, events realtime.Publisher) *SettlementController {
+	return &SettlementController{
+		repo:   repo,
+		events: events,
+	}
+}
+
+// UpdateMemberShareRequest はメンバーの分担の設定をまるごと置き換える
+type UpdateMemberShareRequest struct {
+	// Weight は weighted のときの比率｡省略時は1
+	Weight *int64 `json:"weight"`
+	// FixedAmount は fixed のときの負担額｡null または省略で残りを均等に負担する
+	FixedAmount *int64 `json:"fixed_amount"`
+}
+
+type MemberShareResponse struct {
+	GroupID     int64  `json:"group_id"`
+	UserID      int64  `json:"user_id"`
+	Weight      int64  `json:"weight"`
+	FixedAmount *int64 `json:"fixed_amount"`
+}
+
+// GetSettlementController はグループの精算の内訳を返します｡
+// method クエリでグループの設定とは別の割り方を試算できます
+func (c *SettlementController) GetSettlementController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.ViewGroup) {
+		return
+	}
+
+	group, err := c.repo.GetGroup(groupID)
+	if err != nil {
+		slog.Error("Failed to get group", "error", err)
+		http.Error(w, "Failed to get group", http.StatusInternalServerError)
+		return
+	}
+
+	method := group.SplitMethod
+	if m := r.URL.Query().Get("method"); m != "" {
+		method, err = model.ParseSplitMethod(m)
+		if err != nil {
+			writeValidationErrors(w, map[string]string{
+				"method": "must be one of equal, items, fixed or weighted",
+			})
+			return
+		}
+	}
+
+	settlement, err := c.repo.GetSettlement(groupID, method)
+	if err != nil {
+		if model.IsSplitError(err) {
+			writeValidationErrors(w, map[string]string{
+				"method": err.Error(),
+			})
+			return
+		}
+		slog.Error("Failed to get settlement", "error", err)
+		http.Error(w, "Failed to get settlement", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(settlement); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// UpdateMemberShareController はオーナーや幹事がメンバーの重みや固定額を設定します
+func (c *SettlementController) UpdateMemberShareController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		slog.Error("Invalid group ID", "error", err)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	memberID, err := pathID(r, "userID")
+	if err != nil {
+		slog.Error("Invalid user ID", "error", err)
+		http.Error(w, "Valid user ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, groupID, userID, authz.EditShares) {
+		return
+	}
+
+	// リクエストボディをパース
+	var req UpdateMemberShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// バリデーション
+	weight := int64(1)
+	if req.Weight != nil {
+		weight = *req.Weight
+	}
+
+	fieldErrors := map[string]string{}
+	if weight < 0 {
+		fieldErrors["weight"] = "must not be negative"
+	}
+	if req.FixedAmount != nil && *req.FixedAmount < 0 {
+		fieldErrors["fixed_amount"] = "must not be negative"
+	}
+
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// 締め切ったあとに分担を変えると､記録した支払いと精算の金額が合わなくなる
+	group, err := c.repo.LockGroup(tx, groupID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Group not found", "group_id", groupID)
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to lock group", "error", err)
+		http.Error(w, "Failed to update member share", http.StatusInternalServerError)
+		return
+	}
+
+	if group.Status != model.GroupStatusOpen {
+		slog.Error("Group is not open for share changes", "group_id", groupID, "status", group.Status)
+		http.Error(w, "The shares of a closed group cannot be changed", http.StatusConflict)
+		return
+	}
+
+	updated, err := c.repo.UpdateMemberShare(tx, groupID, memberID, weight, req.FixedAmount)
+	if err != nil {
+		slog.Error("Failed to update member share", "error", err)
+		http.Error(w, "Failed to update member share", http.StatusInternalServerError)
+		return
+	}
+
+	if !updated {
+		slog.Error("Group member not found", "group_id", groupID, "user_id", memberID)
+		http.Error(w, "Group member not found", http.StatusNotFound)
+		return
+	}
+
+	err = c.repo.AppendAuditEvent(tx, model.NewAuditEvent(model.AuditActionMemberShareChanged, userID, groupID, memberID, map[string]any{
+		"weight":       weight,
+		"fixed_amount": req.FixedAmount,
+	}))
+	if err != nil {
+		slog.Error("Failed to append audit event", "error", err)
+		http.Error(w, "Failed to update member share", http.StatusInternalServerError)
+		return
+	}
+
+	pending := []realtime.Event{
+		realtime.NewEvent(realtime.EventShareUpdated, groupID, realtime.SharePayload{
+			UserID:      memberID,
+			Weight:      weight,
+			FixedAmount: req.FixedAmount,
+		}),
+	}
+	if err := recordEvents(tx, c.events, pending...); err != nil {
+		slog.Error("Failed to record group events", "error", err)
+		http.Error(w, "Failed to update member share", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	response := MemberShareResponse{
+		GroupID:     groupID,
+		UserID:      memberID,
+		Weight:      weight,
+		FixedAmount: req.FixedAmount,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Member share updated successfully", "group_id", groupID, "member_id", memberID, "user_id", userID)
+}
@@ -0,0 +1,415 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"domeal/media"
+	"domeal/middleware"
+	"domeal/model"
+	"domeal/realtime"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// uploadURLExpiry はアップロード用のURLの有効期限
+	uploadURLExpiry = 15 * time.Minute
+	// uploadConfirmWindow までに確定されなかったアップロードは掃除される
+	uploadConfirmWindow = time.Hour
+)
+
+type UploadController struct {
+	repo   model.UploadInterface
+	events realtime.Publisher
+	blobs  media.BlobStore
+}
+
+func NewUploadController(repo model.UploadInterface, events realtime.Publisher, blobs media.BlobStore) *UploadController {
+	return &UploadController{
+		repo:   repo,
+		events: events,
+		blobs:  blobs,
+	}
+}
+
+type CreateUploadRequest struct {
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// CreateUploadResponse のURLに headers を付けて method で送ると､APIを通さずにストレージに保存される
+type CreateUploadResponse struct {
+	ID        string            `json:"id"`
+	UploadURL string            `json:"upload_url"`
+	Method    string            `json:"method"`
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// アップロードした画像の使い道
+const (
+	// UploadPurposeMenuImage はグループの料理の画像にする｡省略したときもこれになる
+	UploadPurposeMenuImage = "menu_image"
+	// UploadPurposeMenuItem は料理ごとの画像の image_url に使う
+	UploadPurposeMenuItem = "menu_item"
+	// UploadPurposeReceipt は立て替えのレシートの receipt_image_url に使う
+	UploadPurposeReceipt = "receipt"
+)
+
+type ConfirmUploadRequest struct {
+	GroupID int64  `json:"group_id"`
+	Purpose string `json:"purpose"`
+}
+
+// ConfirmUploadResponse は menu_image 以外で確定したときに返す｡URL を image_url などに入れる
+type ConfirmUploadResponse struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// CreateUploadController はストレージに直接アップロードするためのURLを発行します
+func (c *UploadController) CreateUploadController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	presigner, ok := c.blobs.(media.Presigner)
+	if !ok {
+		slog.Error("Blob store does not support presigned uploads")
+		http.Error(w, "Direct uploads are not supported", http.StatusNotImplemented)
+		return
+	}
+
+	// リクエストボディをパース
+	var req CreateUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// バリデーション
+	fieldErrors := map[string]string{}
+	if !media.IsAllowedType(req.ContentType) {
+		fieldErrors["content_type"] = "must be image/jpeg, image/png, image/gif or image/webp"
+	}
+	if req.Size <= 0 || req.Size > media.MaxUploadSize {
+		fieldErrors["size"] = fmt.Sprintf("must be between 1 and %d bytes", media.MaxUploadSize)
+	}
+
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, fieldErrors)
+		return
+	}
+
+	key, err := media.UploadKey(userID)
+	if err != nil {
+		slog.Error("Failed to generate upload key", "error", err)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	uploadURL, err := presigner.PresignPut(key, req.ContentType, req.Size, uploadURLExpiry)
+	if err != nil {
+		slog.Error("Failed to presign upload", "error", err)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	upload := &model.Upload{
+		UserID:      userID,
+		ObjectKey:   key,
+		ContentType: req.ContentType,
+		Size:        req.Size,
+		ExpiresAt:   time.Now().Add(uploadConfirmWindow),
+	}
+	if err := c.repo.CreateUpload(tx, upload); err != nil {
+		slog.Error("Failed to create upload", "error", err)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	response := CreateUploadResponse{
+		ID:        upload.ID,
+		UploadURL: uploadURL,
+		Method:    http.MethodPut,
+		Headers:   map[string]string{"Content-Type": req.ContentType},
+		ExpiresAt: upload.ExpiresAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Upload created successfully", "upload_id", upload.ID, "user_id", userID)
+}
+
+// ConfirmUploadController はアップロードされた画像を確かめて､グループの画像として登録します｡
+// purpose が menu_image ならグループの料理の画像にし､それ以外は登録した画像のURLを返します｡
+// 直接アップロードされたファイルはそのまま使わず､縮小してメタデータを落としたものを使います
+func (c *UploadController) ConfirmUploadController(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	uploadID := r.PathValue("id")
+	if !uuidPattern.MatchString(uploadID) {
+		slog.Error("Invalid upload ID", "upload_id", uploadID)
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+
+	// リクエストボディをパース
+	var req ConfirmUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.GroupID <= 0 {
+		slog.Error("Valid group ID is required", "group_id", req.GroupID)
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		action authz.Action
+		prefix string
+	)
+	switch req.Purpose {
+	case "", UploadPurposeMenuImage:
+		req.Purpose = UploadPurposeMenuImage
+		action, prefix = authz.EditMenu, menuImagePrefix
+	case UploadPurposeMenuItem:
+		action, prefix = authz.EditMenu, menuImagePrefix
+	case UploadPurposeReceipt:
+		action, prefix = authz.RecordExpense, receiptImagePrefix
+	default:
+		writeValidationErrors(w, map[string]string{"purpose": "must be menu_image, menu_item or receipt"})
+		return
+	}
+
+	if !requireGroupPermission(w, c.repo, req.GroupID, userID, action) {
+		return
+	}
+
+	upload, err := c.repo.GetUpload(uploadID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Upload not found", "upload_id", uploadID)
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to get upload", "error", err)
+		http.Error(w, "Failed to get upload", http.StatusInternalServerError)
+		return
+	}
+
+	// 他人のアップロードはないものとして扱う
+	if upload.UserID != userID {
+		slog.Error("Upload belongs to another user", "upload_id", uploadID, "user_id", userID)
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+
+	if !checkUploadPending(w, upload) {
+		return
+	}
+
+	data, err := c.readUpload(r.Context(), upload)
+	if err != nil {
+		if err == media.ErrNotFound {
+			slog.Error("Upload has not been completed", "upload_id", uploadID)
+			http.Error(w, "Upload has not been completed", http.StatusConflict)
+			return
+		}
+		writeImageError(w, err)
+		return
+	}
+
+	stored, err := media.SaveImage(r.Context(), c.blobs, prefix, data)
+	if err != nil {
+		writeImageError(w, err)
+		return
+	}
+
+	// グループに付けられなかったら縮小した画像を消す
+	committed := false
+	defer func() {
+		if !committed {
+			media.DeleteImage(context.Background(), c.blobs, stored)
+		}
+	}()
+
+	// トランザクション開始
+	tx, err := c.repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		slog.Error("Failed to begin transaction", "error", err)
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// 同じアップロードを同時に確定したり掃除したりしないようにロックし直す
+	upload, err = c.repo.LockUpload(tx, uploadID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Upload not found", "upload_id", uploadID)
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to lock upload", "error", err)
+		http.Error(w, "Failed to confirm upload", http.StatusInternalServerError)
+		return
+	}
+
+	if !checkUploadPending(w, upload) {
+		return
+	}
+
+	group, err := c.repo.LockGroup(tx, req.GroupID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Group not found", "group_id", req.GroupID)
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("Failed to lock group", "error", err)
+		http.Error(w, "Failed to confirm upload", http.StatusInternalServerError)
+		return
+	}
+
+	image := &model.Media{
+		GroupID:     group.ID,
+		ObjectKey:   stored.Key,
+		ContentType: media.ContentType,
+	}
+	if err := c.repo.CreateMedia(tx, image); err != nil {
+		slog.Error("Failed to create media", "error", err)
+		http.Error(w, "Failed to confirm upload", http.StatusInternalServerError)
+		return
+	}
+
+	if err := c.repo.MarkUploadAttached(tx, uploadID, group.ID); err != nil {
+		slog.Error("Failed to mark upload as attached", "error", err)
+		http.Error(w, "Failed to confirm upload", http.StatusInternalServerError)
+		return
+	}
+
+	// 料理ごとの画像やレシートは､返したURLを付けて更新されたときに変わる
+	var response any = ConfirmUploadResponse{ID: image.ID, URL: media.Path(image.ID)}
+	if req.Purpose == UploadPurposeMenuImage {
+		group.MenuImageURL = media.Path(image.ID)
+		if err := c.repo.UpdateGroupMenuImage(tx, group.ID, group.MenuImageURL); err != nil {
+			slog.Error("Failed to update menu image", "error", err)
+			http.Error(w, "Failed to confirm upload", http.StatusInternalServerError)
+			return
+		}
+
+		pending := []realtime.Event{
+			realtime.NewEvent(realtime.EventGroupUpdated, group.ID, group),
+		}
+		if err := recordEvents(tx, c.events, pending...); err != nil {
+			slog.Error("Failed to record group events", "error", err)
+			http.Error(w, "Failed to confirm upload", http.StatusInternalServerError)
+			return
+		}
+		response = group
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		slog.Error("Failed to commit transaction", "error", err)
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	committed = true
+
+	// 元のファイルはもう使わない｡消せなくても縮小した画像は使える
+	if err := c.blobs.Delete(context.Background(), upload.ObjectKey); err != nil {
+		slog.Error("Failed to delete original upload", "error", err, "upload_id", uploadID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Upload confirmed successfully", "upload_id", uploadID, "group_id", group.ID, "purpose", req.Purpose, "user_id", userID)
+}
+
+// readUpload はアップロードされたファイルを読む｡申告より大きければ ErrTooLarge を返す
+func (c *UploadController) readUpload(ctx context.Context, upload *model.Upload) ([]byte, error) {
+	body, err := c.blobs.Get(ctx, upload.ObjectKey)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, upload.Size+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > upload.Size {
+		return nil, media.ErrTooLarge
+	}
+
+	return data, nil
+}
+
+// checkUploadPending はアップロードがまだ確定されておらず期限内であることを確認する｡
+// 条件を満たさない場合はエラーレスポンスを書き込んで false を返す
+func checkUploadPending(w http.ResponseWriter, upload *model.Upload) bool {
+	if upload.Status != model.UploadStatusPending {
+		slog.Error("Upload is already attached", "upload_id", upload.ID)
+		http.Error(w, "Upload is already attached", http.StatusConflict)
+		return false
+	}
+
+	if time.Now().After(upload.ExpiresAt) {
+		slog.Error("Upload has expired", "upload_id", upload.ID)
+		http.Error(w, "Upload has expired", http.StatusGone)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,272 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"domeal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxAttempts を超えて失敗したジョブは dead にする
+	DefaultMaxAttempts = 10
+	// pollInterval は取り出せるジョブがなかったときに待つ時間
+	pollInterval = 2 * time.Second
+	// handlerTimeout は1件のジョブに許す時間
+	handlerTimeout = time.Minute
+	baseBackoff    = 10 * time.Second
+	maxBackoff     = time.Hour
+	// handlerSavepoint まで戻せばハンドラーが書いた内容だけを取り消せる
+	handlerSavepoint = "job_handler"
+)
+
+// Enqueuer はコントローラーのトランザクションでジョブを積む
+type Enqueuer interface {
+	EnqueueJob(tx *sql.Tx, job *model.Job) error
+}
+
+// Store はジョブキューの永続化に使う
+type Store interface {
+	Enqueuer
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	ClaimJob(tx *sql.Tx) (*model.Job, error)
+	RetryJob(tx *sql.Tx, id int64, attempts int, runAt time.Time, lastError string) error
+	DeadLetterJob(tx *sql.Tx, id int64, attempts int, lastError string) error
+}
+
+// Kind はジョブの種類とペイロードの型を結びつける
+type Kind[T any] struct {
+	name string
+}
+
+func NewKind[T any](name string) Kind[T] {
+	return Kind[T]{name: name}
+}
+
+func (k Kind[T]) Name() string {
+	return k.name
+}
+
+// Option はジョブを積むときの設定
+type Option func(job *model.Job)
+
+// RunAt は実行する時刻を指定する
+func RunAt(t time.Time) Option {
+	return func(job *model.Job) {
+		job.RunAt = t
+	}
+}
+
+// UniqueKey は同じ種類で同じキーの未実行のジョブを1つにまとめる
+func UniqueKey(key string) Option {
+	return func(job *model.Job) {
+		job.UniqueKey = key
+	}
+}
+
+// MaxAttempts は dead にするまでに試す回数を指定する
+func MaxAttempts(n int) Option {
+	return func(job *model.Job) {
+		job.MaxAttempts = n
+	}
+}
+
+// Enqueue はジョブを tx で積む｡tx がコミットされたときだけ実行される
+func (k Kind[T]) Enqueue(q Enqueuer, tx *sql.Tx, payload T, opts ...Option) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	job := &model.Job{
+		Kind:        k.name,
+		Payload:     data,
+		MaxAttempts: DefaultMaxAttempts,
+	}
+	for _, opt := range opts {
+		opt(job)
+	}
+
+	return q.EnqueueJob(tx, job)
+}
+
+// permanentError は再試行しても成功しない失敗
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent で包んだエラーを返すと再試行せずにすぐ dead にする
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+func isPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+type handlerFunc func(ctx context.Context, tx *sql.Tx, payload json.RawMessage) error
+
+// Runner は登録されたハンドラーでジョブを実行するワーカーの集まり｡レプリカごとに動かしてよい
+type Runner struct {
+	store    Store
+	handlers map[string]handlerFunc
+	now      func() time.Time
+}
+
+func NewRunner(store Store) *Runner {
+	return &Runner{
+		store:    store,
+		handlers: map[string]handlerFunc{},
+		now:      time.Now,
+	}
+}
+
+// Register は種類ごとのハンドラーを登録する｡ハンドラーはジョブを取り出したトランザクションで動き､
+// エラーを返すと書き込んだ内容は取り消される｡Run より前に呼ぶこと
+func Register[T any](r *Runner, kind Kind[T], handle func(ctx context.Context, tx *sql.Tx, payload T) error) {
+	if _, ok := r.handlers[kind.name]; ok {
+		panic(fmt.Sprintf("jobs: handler for %q is already registered", kind.name))
+	}
+
+	r.handlers[kind.name] = func(ctx context.Context, tx *sql.Tx, data json.RawMessage) error {
+		var payload T
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return Permanent(fmt.Errorf("invalid payload: %w", err))
+		}
+		return handle(ctx, tx, payload)
+	}
+}
+
+// Run は workers 個のワーカーでジョブを実行する｡ctx が終わり､実行中のジョブが終わるまで戻らない
+func (r *Runner) Run(ctx context.Context, workers int) {
+	slog.Info("Starting job workers", "workers", workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (r *Runner) work(ctx context.Context) {
+	for {
+		found, err := r.RunOnce(ctx)
+		if err != nil {
+			slog.Error("Job worker error", "error", err)
+		}
+
+		if found && ctx.Err() == nil {
+			// まだ残っているかもしれないので待たずに続ける
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// RunOnce はジョブを1件取り出して実行する｡取り出せるジョブがなければ false を返す
+func (r *Runner) RunOnce(ctx context.Context) (bool, error) {
+	tx, err := r.store.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	job, err := r.store.ClaimJob(tx)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec("SAVEPOINT " + handlerSavepoint); err != nil {
+		return true, err
+	}
+
+	handleErr := r.handle(ctx, tx, job)
+	if handleErr != nil {
+		// ハンドラーの書き込みだけを取り消して失敗を記録する
+		if _, err := tx.Exec("ROLLBACK TO SAVEPOINT " + handlerSavepoint); err != nil {
+			return true, err
+		}
+		if err := r.fail(tx, job, handleErr); err != nil {
+			return true, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return true, err
+	}
+
+	if handleErr == nil {
+		slog.Info("Job done", "id", job.ID, "kind", job.Kind, "attempts", job.Attempts+1)
+	}
+
+	return true, nil
+}
+
+// handle はハンドラーを呼ぶ｡panic もエラーとして扱う
+func (r *Runner) handle(ctx context.Context, tx *sql.Tx, job *model.Job) (err error) {
+	handler, ok := r.handlers[job.Kind]
+	if !ok {
+		// ほかのレプリカが新しい種類を積んだだけかもしれないので再試行に回す
+		return fmt.Errorf("no handler for job kind %q", job.Kind)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job handler panicked: %v", p)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, handlerTimeout)
+	defer cancel()
+
+	return handler(ctx, tx, job.Payload)
+}
+
+func (r *Runner) fail(tx *sql.Tx, job *model.Job, handleErr error) error {
+	attempts := job.Attempts + 1
+
+	if isPermanent(handleErr) || attempts >= job.MaxAttempts {
+		slog.Error("Job dead-lettered", "error", handleErr, "id", job.ID, "kind", job.Kind, "attempts", attempts)
+		return r.store.DeadLetterJob(tx, job.ID, attempts, handleErr.Error())
+	}
+
+	runAt := r.now().Add(backoff(attempts))
+	slog.Warn("Job failed, will retry", "error", handleErr, "id", job.ID, "kind", job.Kind, "attempts", attempts, "run_at", runAt)
+	return r.store.RetryJob(tx, job.ID, attempts, runAt, handleErr.Error())
+}
+
+// backoff は attempts 回目の失敗の後に待つ時間｡10秒から倍々に増やし､1時間で頭打ちにする
+func backoff(attempts int) time.Duration {
+	wait := baseBackoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+
+	return min(wait, maxBackoff)
+}
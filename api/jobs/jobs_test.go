@@ -0,0 +1,151 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"domeal/model"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeStore はトランザクションを使わずに失敗の記録だけを覚えておく
+type fakeStore struct {
+	enqueued []*model.Job
+	retried  map[int64]time.Time
+	dead     []int64
+	attempts map[int64]int
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		retried:  map[int64]time.Time{},
+		attempts: map[int64]int{},
+	}
+}
+
+func (s *fakeStore) EnqueueJob(tx *sql.Tx, job *model.Job) error {
+	s.enqueued = append(s.enqueued, job)
+	return nil
+}
+
+func (s *fakeStore) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *fakeStore) ClaimJob(tx *sql.Tx) (*model.Job, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeStore) RetryJob(tx *sql.Tx, id int64, attempts int, runAt time.Time, lastError string) error {
+	s.retried[id] = runAt
+	s.attempts[id] = attempts
+	return nil
+}
+
+func (s *fakeStore) DeadLetterJob(tx *sql.Tx, id int64, attempts int, lastError string) error {
+	s.dead = append(s.dead, id)
+	s.attempts[id] = attempts
+	return nil
+}
+
+type greetPayload struct {
+	Name string `json:"name"`
+}
+
+var greetKind = NewKind[greetPayload]("greet")
+
+func TestKindEnqueue(t *testing.T) {
+	store := newFakeStore()
+	runAt := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	err := greetKind.Enqueue(store, nil, greetPayload{Name: "taro"}, RunAt(runAt), UniqueKey("taro"))
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	if len(store.enqueued) != 1 {
+		t.Fatalf("enqueued %d jobs, want 1", len(store.enqueued))
+	}
+	job := store.enqueued[0]
+	if job.Kind != "greet" || string(job.Payload) != `{"name":"taro"}` || !job.RunAt.Equal(runAt) ||
+		job.UniqueKey != "taro" || job.MaxAttempts != DefaultMaxAttempts {
+		t.Errorf("job = %+v", job)
+	}
+}
+
+func TestRunnerHandle(t *testing.T) {
+	runner := NewRunner(newFakeStore())
+
+	var got greetPayload
+	Register(runner, greetKind, func(ctx context.Context, tx *sql.Tx, payload greetPayload) error {
+		got = payload
+		if payload.Name == "panic" {
+			panic("boom")
+		}
+		return nil
+	})
+
+	err := runner.handle(context.Background(), nil, &model.Job{Kind: "greet", Payload: []byte(`{"name":"hanako"}`)})
+	if err != nil || got.Name != "hanako" {
+		t.Errorf("handle() = %v, payload = %+v", err, got)
+	}
+
+	// 壊れたペイロードは再試行しても無駄
+	err = runner.handle(context.Background(), nil, &model.Job{Kind: "greet", Payload: []byte(`[]`)})
+	if !isPermanent(err) {
+		t.Errorf("handle() with invalid payload = %v, want permanent error", err)
+	}
+
+	err = runner.handle(context.Background(), nil, &model.Job{Kind: "greet", Payload: []byte(`{"name":"panic"}`)})
+	if err == nil || isPermanent(err) {
+		t.Errorf("handle() with panic = %v, want retryable error", err)
+	}
+
+	err = runner.handle(context.Background(), nil, &model.Job{Kind: "unknown", Payload: []byte(`{}`)})
+	if err == nil || isPermanent(err) {
+		t.Errorf("handle() with unknown kind = %v, want retryable error", err)
+	}
+}
+
+func TestRunnerFail(t *testing.T) {
+	store := newFakeStore()
+	runner := NewRunner(store)
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	runner.now = func() time.Time { return now }
+
+	failure := errors.New("temporary")
+	runner.fail(nil, &model.Job{ID: 1, Attempts: 2, MaxAttempts: 10}, failure)
+	runner.fail(nil, &model.Job{ID: 2, Attempts: 9, MaxAttempts: 10}, failure)
+	runner.fail(nil, &model.Job{ID: 3, Attempts: 0, MaxAttempts: 10}, Permanent(failure))
+
+	if got, want := store.retried[1], now.Add(40*time.Second); len(store.retried) != 1 || !got.Equal(want) {
+		t.Errorf("retried = %v, want {1: %v}", store.retried, want)
+	}
+	if len(store.dead) != 2 || store.dead[0] != 2 || store.dead[1] != 3 {
+		t.Errorf("dead = %v, want [2 3]", store.dead)
+	}
+	if store.attempts[1] != 3 || store.attempts[2] != 10 || store.attempts[3] != 1 {
+		t.Errorf("attempts = %v", store.attempts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{9, 2560 * time.Second},
+		{10, maxBackoff},
+		{50, maxBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package line
+
+import (
+	"context"
+	"database/sql"
+	"domeal/jobs"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// RefreshPayload はユーザーの LINE のトークンを更新するジョブのペイロード
+type RefreshPayload struct {
+	UserID int64 `json:"user_id"`
+}
+
+// RefreshKind はアクセストークンが切れる前に更新するジョブ｡更新したら次の更新を予約する
+var RefreshKind = jobs.NewKind[RefreshPayload]("refresh_line_token")
+
+// TokenStore はトークンの保存に使う
+type TokenStore interface {
+	GetRefreshToken(tx *sql.Tx, userID int64) (string, error)
+	UpdateToken(tx *sql.Tx, userID int64, accessToken, refreshToken string) error
+}
+
+// ScheduleRefresh はアクセストークンの有効期限の9割が過ぎたころに更新するジョブを予約する｡
+// 同じユーザーの予約は1つにまとめる
+func ScheduleRefresh(q jobs.Enqueuer, tx *sql.Tx, userID int64, expiresIn int, now time.Time) error {
+	runAt := now.Add(time.Duration(expiresIn) * time.Second * 9 / 10)
+	return RefreshKind.Enqueue(q, tx, RefreshPayload{UserID: userID},
+		jobs.RunAt(runAt),
+		jobs.UniqueKey(strconv.FormatInt(userID, 10)),
+	)
+}
+
+type Refresher struct {
+	store  TokenStore
+	client *TokenClient
+	jobs   jobs.Enqueuer
+	now    func() time.Time
+}
+
+func NewRefresher(store TokenStore, client *TokenClient, jobs jobs.Enqueuer) *Refresher {
+	return &Refresher{
+		store:  store,
+		client: client,
+		jobs:   jobs,
+		now:    time.Now,
+	}
+}
+
+// Register はトークンを更新するジョブのハンドラーを登録する
+func (r *Refresher) Register(runner *jobs.Runner) {
+	jobs.Register(runner, RefreshKind, r.Refresh)
+}
+
+func (r *Refresher) Refresh(ctx context.Context, tx *sql.Tx, payload RefreshPayload) error {
+	refreshToken, err := r.store.GetRefreshToken(tx, payload.UserID)
+	if err == sql.ErrNoRows {
+		// 退会したユーザー
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := r.client.Refresh(ctx, refreshToken)
+	if errors.Is(err, ErrInvalidGrant) {
+		// リフレッシュトークンも切れている｡次にログインしたときに取り直す
+		slog.Warn("LINE refresh token is no longer valid", "user_id", payload.UserID, "error", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := r.store.UpdateToken(tx, payload.UserID, token.AccessToken, token.RefreshToken); err != nil {
+		return err
+	}
+
+	return ScheduleRefresh(r.jobs, tx, payload.UserID, token.ExpiresIn, r.now())
+}
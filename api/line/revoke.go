@@ -0,0 +1,52 @@
+package line
+
+import (
+	"context"
+	"database/sql"
+	"domeal/jobs"
+	"errors"
+	"log/slog"
+)
+
+// RevokePayload は取り消すアクセストークン｡user_tokens の行は退会と同時に消すのでペイロードに持たせる
+type RevokePayload struct {
+	UserID      int64  `json:"user_id"`
+	AccessToken string `json:"access_token"`
+}
+
+// RevokeKind は退会したユーザーの LINE のアクセストークンを取り消すジョブ｡
+// LINE が落ちていても退会は止めず､コミットしてから再試行しながら取り消す
+var RevokeKind = jobs.NewKind[RevokePayload]("revoke_line_token")
+
+// ScheduleRevoke はアクセストークンを取り消すジョブを積む
+func ScheduleRevoke(q jobs.Enqueuer, tx *sql.Tx, userID int64, accessToken string) error {
+	return RevokeKind.Enqueue(q, tx, RevokePayload{UserID: userID, AccessToken: accessToken})
+}
+
+type Revoker struct {
+	client *TokenClient
+}
+
+func NewRevoker(client *TokenClient) *Revoker {
+	return &Revoker{client: client}
+}
+
+// Register はトークンを取り消すジョブのハンドラーを登録する
+func (r *Revoker) Register(runner *jobs.Runner) {
+	jobs.Register(runner, RevokeKind, r.Revoke)
+}
+
+func (r *Revoker) Revoke(ctx context.Context, tx *sql.Tx, payload RevokePayload) error {
+	err := r.client.Revoke(ctx, payload.AccessToken)
+	if errors.Is(err, ErrInvalidGrant) {
+		// すでに使えないトークンなので取り消すまでもない
+		slog.Warn("LINE access token was already invalid", "user_id", payload.UserID, "error", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.Info("LINE access token revoked", "user_id", payload.UserID)
+	return nil
+}
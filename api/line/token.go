@@ -0,0 +1,115 @@
+package line
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTokenEndpoint は LINEログインのトークンエンドポイント
+	DefaultTokenEndpoint = "https://api.line.me/oauth2/v2.1/token"
+	// DefaultRevokeEndpoint は LINEログインのアクセストークンを取り消すエンドポイント
+	DefaultRevokeEndpoint = "https://api.line.me/oauth2/v2.1/revoke"
+)
+
+// ErrInvalidGrant はリフレッシュトークンが期限切れか取り消されていることを表す｡再ログインが必要
+var ErrInvalidGrant = errors.New("line: invalid grant")
+
+// Token はトークンエンドポイントのレスポンス
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+	TokenType    string `json:"token_type"`
+}
+
+// TokenClient は LINEログインのトークンを扱う
+type TokenClient struct {
+	TokenEndpoint  string
+	RevokeEndpoint string
+	ClientID       string
+	ClientSecret   string
+	Client         *http.Client
+}
+
+// NewTokenClient はログインと同じ環境変数からクライアントを作る
+func NewTokenClient() *TokenClient {
+	return &TokenClient{
+		TokenEndpoint:  DefaultTokenEndpoint,
+		RevokeEndpoint: DefaultRevokeEndpoint,
+		ClientID:       os.Getenv("LINE_CLIENT_ID"),
+		ClientSecret:   os.Getenv("LINE_CLIENT_SECRET"),
+		Client:         &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Refresh はリフレッシュトークンで新しいアクセストークンを取得する
+func (c *TokenClient) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
+	data := url.Values{}
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", refreshToken)
+	data.Set("client_id", c.ClientID)
+	data.Set("client_secret", c.ClientSecret)
+
+	body, err := c.post(ctx, c.TokenEndpoint, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var token Token
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("line: failed to parse token response: %w", err)
+	}
+
+	return &token, nil
+}
+
+// Revoke はアクセストークンを取り消してアプリとの連携を解除する｡
+// すでに取り消されているか期限が切れていれば ErrInvalidGrant を返す
+func (c *TokenClient) Revoke(ctx context.Context, accessToken string) error {
+	data := url.Values{}
+	data.Set("access_token", accessToken)
+	data.Set("client_id", c.ClientID)
+	data.Set("client_secret", c.ClientSecret)
+
+	_, err := c.post(ctx, c.RevokeEndpoint, data)
+	return err
+}
+
+func (c *TokenClient) post(ctx context.Context, endpoint string, data url.Values) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return body, nil
+	case resp.StatusCode == http.StatusBadRequest:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidGrant, body)
+	default:
+		return nil, fmt.Errorf("line: status %d: %s", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,41 @@
+package line
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRevoke(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		got = r.PostForm.Get("access_token")
+		if got == "expired" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &TokenClient{RevokeEndpoint: server.URL, ClientID: "id", ClientSecret: "secret", Client: server.Client()}
+
+	if err := client.Revoke(context.Background(), "token"); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if got != "token" {
+		t.Errorf("access_token = %q", got)
+	}
+
+	if err := client.Revoke(context.Background(), "expired"); !errors.Is(err, ErrInvalidGrant) {
+		t.Errorf("Revoke() error = %v, want ErrInvalidGrant", err)
+	}
+
+	// すでに使えないトークンならジョブは成功にする
+	if err := NewRevoker(client).Revoke(context.Background(), nil, RevokePayload{UserID: 1, AccessToken: "expired"}); err != nil {
+		t.Errorf("Revoker.Revoke() error = %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidHeader は宛先や件名に改行が含まれていて､ヘッダーを書き換えられるおそれがある
+var ErrInvalidHeader = errors.New("mailer: header contains a line break")
+
+// Message は送るメール｡本文はプレーンテキストだけにする
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+func (m Message) validate() error {
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return ErrInvalidHeader
+	}
+	return nil
+}
+
+// Mailer はメールを送る
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// NewMailerFromEnv は SMTP_HOST があれば SMTP で送り､なければログに出すだけの Mailer を返す
+func NewMailerFromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		slog.Warn("SMTP_HOST is not set; emails are only logged")
+		return LogMailer{}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	return NewSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("MAIL_FROM"))
+}
+
+// LogMailer は送らずにログに出すだけの Mailer｡SMTP サーバーがない開発環境で使う
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, message Message) error {
+	if err := message.validate(); err != nil {
+		return err
+	}
+	slog.Info("Email (not sent)", "to", message.To, "subject", message.Subject, "body", message.Body)
+	return nil
+}
+
+// MemoryMailer は送ったメールを覚えておくだけの Mailer｡テストで使う
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, message Message) error {
+	if err := message.validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// Messages はこれまでに送ったメールを返す
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
@@ -0,0 +1,65 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"mime"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSMTPMailerBuild(t *testing.T) {
+	m := NewSMTPMailer("smtp.example.com", "587", "", "", "Domeal <no-reply@example.com>")
+	m.now = func() time.Time { return time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC) }
+
+	from, _ := mail.ParseAddress(m.From)
+	to, _ := mail.ParseAddress("taro@example.com")
+	body := strings.Repeat("メールアドレスの確認をお願いします｡", 5)
+	data := m.build(from, to, Message{To: "taro@example.com", Subject: "メールアドレスの確認", Body: body})
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != "メールアドレスの確認" {
+		t.Errorf("Subject = %q, %v", subject, err)
+	}
+	if got := parsed.Header.Get("To"); got != "<taro@example.com>" {
+		t.Errorf("To = %q", got)
+	}
+
+	for _, line := range strings.Split(string(data), "\r\n") {
+		if len(line) > 78 {
+			t.Errorf("line is too long: %q", line)
+		}
+	}
+
+	encoded := new(bytes.Buffer)
+	encoded.ReadFrom(parsed.Body)
+	decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(encoded.String(), "\r\n", ""))
+	if err != nil || string(decoded) != body {
+		t.Errorf("body = %q, %v", decoded, err)
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	var m MemoryMailer
+	ctx := context.Background()
+
+	if err := m.Send(ctx, Message{To: "taro@example.com", Subject: "hello", Body: "body"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err := m.Send(ctx, Message{To: "taro@example.com\r\nBcc: evil@example.com", Subject: "hello"}); err != ErrInvalidHeader {
+		t.Errorf("Send() with a line break error = %v, want ErrInvalidHeader", err)
+	}
+
+	messages := m.Messages()
+	if len(messages) != 1 || messages[0].To != "taro@example.com" {
+		t.Errorf("Messages() = %+v", messages)
+	}
+}
@@ -1,14 +1,26 @@
 package main
 
 import (
+	"context"
+	"domeal/jobs"
+	"domeal/line"
+	"domeal/media"
+	"domeal/middleware"
 	"domeal/model"
+	"domeal/notify"
+	"domeal/ratelimit"
+	"domeal/realtime"
 	"domeal/router"
+	"domeal/scheduler"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
 )
 
+// jobWorkers はレプリカごとに動かすジョブのワーカーの数
+const jobWorkers = 4
+
 func main() {
 	opts := &slog.HandlerOptions{
 		AddSource: true,
@@ -29,7 +41,47 @@ func main() {
 	}
 	defer conn.Close()
 
-	router := router.NewRouter(conn)
+	repo := model.NewRepository(conn)
+
+	// グループのイベントはどのレプリカで起きても NOTIFY で全レプリカに届ける
+	broker := realtime.NewBroker(repo)
+	go func() {
+		if err := broker.Listen(context.Background(), model.DSN()); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	// コミット後の処理はジョブキューのワーカーが行う
+	runner := jobs.NewRunner(repo)
+
+	// LINE へのプッシュ通知｡トークンがなければログに出すだけで送らない
+	var notifier notify.Notifier = notify.LogNotifier{}
+	if token := os.Getenv("LINE_MESSAGING_CHANNEL_ACCESS_TOKEN"); token != "" {
+		notifier = notify.NewLineNotifier(token)
+	} else {
+		slog.Warn("LINE_MESSAGING_CHANNEL_ACCESS_TOKEN is not set; push notifications are only logged")
+	}
+	notify.NewSender(repo, notifier).Register(runner)
+	tokens := line.NewTokenClient()
+	line.NewRefresher(repo, tokens, repo).Register(runner)
+	line.NewRevoker(tokens).Register(runner)
+
+	go runner.Run(context.Background(), jobWorkers)
+
+	// 締め切りの処理はアドバイザリロックでレプリカのうち1つだけが行う
+	deadlines := scheduler.NewScheduler(repo, broker, notify.NewOutbox(repo, repo), scheduler.SystemClock{})
+	go deadlines.Run(context.Background())
+
+	// 確定されなかった直接アップロードを掃除する
+	blobs := media.NewBlobStoreFromEnv()
+	go media.NewSweeper(repo, blobs).Run(context.Background())
+
+	// レプリカが複数なら RATE_LIMIT_STORE=postgres にして全レプリカで同じバケットを数える
+	limiter := ratelimit.NewLimiterFromEnv(repo)
+	go limiter.Run(context.Background())
+	limits := middleware.NewRateLimiter(limiter, ratelimit.TrustedProxiesFromEnv())
+
+	router := router.NewRouter(conn, broker, blobs, limits)
 	router.SetupRouter()
 
 	log.Println("Starting server on :8080")
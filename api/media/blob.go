@@ -0,0 +1,67 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrNotFound はキーに対応するオブジェクトがない
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore はアップロードされたファイルの置き場所
+type BlobStore interface {
+	// Put は data を key に保存する｡同じキーがあれば上書きする
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	// Get は key のオブジェクトを返す｡なければ ErrNotFound を返す
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete は key のオブジェクトを消す｡なくてもエラーにしない
+	Delete(ctx context.Context, key string) error
+	// URL は key のオブジェクトをブラウザから取得するURLを返す
+	URL(key string) string
+}
+
+// validKey はキーに使える文字｡パスの外を指せないようにする
+var validKey = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9]+)?(/[A-Za-z0-9_-]+(\.[A-Za-z0-9]+)?)*$`)
+
+func checkKey(key string) error {
+	if !validKey.MatchString(key) {
+		return errors.New("invalid blob key: " + key)
+	}
+	return nil
+}
+
+// NewBlobStoreFromEnv は環境変数から BlobStore を作る｡
+// BLOB_STORE=s3 なら S3 互換のストレージ(開発環境では MinIO)､それ以外はローカルのディレクトリを使う
+func NewBlobStoreFromEnv() BlobStore {
+	if strings.EqualFold(os.Getenv("BLOB_STORE"), "s3") {
+		store := NewS3Store(
+			os.Getenv("S3_ENDPOINT"),
+			os.Getenv("S3_REGION"),
+			os.Getenv("S3_BUCKET"),
+			os.Getenv("S3_ACCESS_KEY_ID"),
+			os.Getenv("S3_SECRET_ACCESS_KEY"),
+		)
+		store.PublicURL = os.Getenv("S3_PUBLIC_URL")
+		store.PresignEndpoint = os.Getenv("S3_PRESIGN_ENDPOINT")
+		slog.Info("Using S3 blob store", "endpoint", store.Endpoint, "bucket", store.Bucket)
+		return store
+	}
+
+	dir := os.Getenv("UPLOAD_DIR")
+	if dir == "" {
+		dir = DefaultUploadDir
+	}
+	slog.Info("Using local blob store", "dir", dir)
+	return NewLocalStore(dir, LocalStorePath)
+}
+
+// Presigner はブラウザから直接アップロードできるURLを発行できる BlobStore
+type Presigner interface {
+	PresignPut(key, contentType string, size int64, expires time.Duration) (string, error)
+}
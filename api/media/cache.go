@@ -0,0 +1,65 @@
+package media
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// DefaultCacheDir は MEDIA_CACHE_DIR がないときに使うディレクトリ
+const DefaultCacheDir = "/var/cache/domeal/media"
+
+// Cache は縮小した画像をローカルのディスクに置いておく｡
+// 画像は id ごとに変わらないので消さなくてよく､消えても作り直すだけなのでディレクトリごと消してよい
+type Cache struct {
+	dir string
+}
+
+func NewCache(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// NewCacheFromEnv は MEDIA_CACHE_DIR に置く Cache を作る
+func NewCacheFromEnv() *Cache {
+	dir := os.Getenv("MEDIA_CACHE_DIR")
+	if dir == "" {
+		dir = DefaultCacheDir
+	}
+	return NewCache(dir)
+}
+
+func (c *Cache) path(id string, width int) string {
+	return filepath.Join(c.dir, id, strconv.Itoa(width)+".jpg")
+}
+
+// Get は id を幅 width に縮小した画像を返す｡なければ false を返す
+func (c *Cache) Get(id string, width int) ([]byte, bool) {
+	if checkKey(id) != nil {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(c.path(id, width))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Error("Failed to read media cache", "error", err, "id", id, "width", width)
+		}
+		return nil, false
+	}
+
+	return data, true
+}
+
+// Put は縮小した画像を置く｡キャッシュなので失敗してもログに残すだけにする
+func (c *Cache) Put(id string, width int, data []byte) {
+	if checkKey(id) != nil {
+		return
+	}
+
+	name := c.path(id, width)
+	if err := writeFileAtomic(name, data); err != nil {
+		slog.Error("Failed to write media cache", "error", err, "id", id, "width", width)
+	}
+}
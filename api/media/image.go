@@ -0,0 +1,217 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log/slog"
+	"net/http"
+
+	// image.Decode で読めるようにする
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// MaxUploadSize はアップロードできる画像の上限
+	MaxUploadSize = 10 << 20
+	// maxPixels を超える画像はデコードしない｡小さなファイルで大量のメモリを使わせないため
+	maxPixels = 40_000_000
+	// ContentType は保存する画像の形式｡WebP のエンコーダーは cgo が要るので JPEG にそろえる
+	ContentType = "image/jpeg"
+	jpegQuality = 82
+)
+
+var (
+	ErrTooLarge        = errors.New("image is too large")
+	ErrUnsupportedType = errors.New("unsupported image type")
+	ErrInvalidImage    = errors.New("invalid image")
+)
+
+// MaxSize は保存する画像の長辺のピクセル数｡元の画像より大きくはしない
+const MaxSize = 1280
+
+// Widths は配信するときに縮小できる幅｡任意の幅を許すとキャッシュを埋め尽くされるので決め打ちにする
+var Widths = []int{320, 640, 1280}
+
+// allowedTypes は受け付ける画像の形式｡ファイル名や Content-Type ではなく中身で判定する
+var allowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// IsAllowedType は受け付ける画像の形式かどうかを返す
+func IsAllowedType(contentType string) bool {
+	return allowedTypes[contentType]
+}
+
+// Variant は縮小して JPEG にした画像
+type Variant struct {
+	Width  int
+	Height int
+	Data   []byte
+}
+
+// SniffImage は中身から画像の形式を判定する｡受け付けない形式なら ErrUnsupportedType を返す
+func SniffImage(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	if !allowedTypes[contentType] {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+	return contentType, nil
+}
+
+// Normalize は画像をデコードして長辺を MaxSize に収めた JPEG にエンコードし直す｡
+// エンコードし直すので EXIF などのメタデータは残らない｡向きだけは EXIF に従って回転してから捨てる
+func Normalize(data []byte) (*Variant, error) {
+	img, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType, _ := SniffImage(data)
+	if contentType == "image/jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+
+	width, height := fit(img.Bounds().Dx(), img.Bounds().Dy(), MaxSize)
+	return scale(img, width, height)
+}
+
+// Resize は Normalize した画像を幅 width に縮小する｡拡大はしない
+func Resize(data []byte, width int) (*Variant, error) {
+	img, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w > width {
+		w, h = width, max(1, h*width/w)
+	}
+	return scale(img, w, h)
+}
+
+// decode は受け付ける形式で大きすぎない画像だけをデコードし､透過部分を白で塗りつぶす
+func decode(data []byte) (*image.RGBA, error) {
+	if len(data) > MaxUploadSize {
+		return nil, ErrTooLarge
+	}
+
+	if _, err := SniffImage(data); err != nil {
+		return nil, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	return flatten(src), nil
+}
+
+func scale(img *image.RGBA, width, height int) (*Variant, error) {
+	dst := img
+	if width != img.Bounds().Dx() || height != img.Bounds().Dy() {
+		dst = image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+
+	return &Variant{
+		Width:  width,
+		Height: height,
+		Data:   buf.Bytes(),
+	}, nil
+}
+
+// fit は長辺が size に収まる大きさを返す｡拡大はしない
+func fit(width, height, size int) (int, int) {
+	long := max(width, height)
+	if long <= size {
+		return width, height
+	}
+
+	return max(1, width*size/long), max(1, height*size/long)
+}
+
+// flatten は透過部分を白で塗りつぶした RGBA にする｡JPEG には透過がないため
+func flatten(src image.Image) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Over)
+	return dst
+}
+
+// StoredImage は BlobStore に保存した画像｡配信するときに Resize する
+type StoredImage struct {
+	Key string
+}
+
+// SaveImage は画像を Normalize して prefix の下に保存する
+func SaveImage(ctx context.Context, store BlobStore, prefix string, data []byte) (*StoredImage, error) {
+	variant, err := Normalize(data)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%s/%s.jpg", prefix, id)
+	if err := store.Put(ctx, key, ContentType, variant.Data); err != nil {
+		return nil, err
+	}
+
+	return &StoredImage{Key: key}, nil
+}
+
+// DeleteImage は SaveImage で保存した画像を消す｡失敗してもログに残すだけにする
+func DeleteImage(ctx context.Context, store BlobStore, stored *StoredImage) {
+	if err := store.Delete(ctx, stored.Key); err != nil {
+		slog.Error("Failed to delete blob", "error", err, "key", stored.Key)
+	}
+}
+
+// UploadKey はブラウザから直接アップロードさせるファイルのキーを作る
+func UploadKey(userID int64) (string, error) {
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("uploads/%d/%s", userID, id), nil
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
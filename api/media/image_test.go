@@ -0,0 +1,172 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+)
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: 200, A: uint8(x % 256)})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withOrientation は JPEG の先頭に Orientation だけを持つ EXIF を差し込む
+func withOrientation(data []byte, orientation byte) []byte {
+	tiff := []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8,
+		0, 1,
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0,
+		0, 0, 0, 0,
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(payload) + 2
+
+	segment := append([]byte{0xFF, 0xE1, byte(length >> 8), byte(length)}, payload...)
+	return append(append([]byte{0xFF, 0xD8}, segment...), data[2:]...)
+}
+
+func TestNormalize(t *testing.T) {
+	variant, err := Normalize(encodePNG(t, 2000, 1000))
+	if err != nil {
+		t.Fatalf("Normalize() error = %v", err)
+	}
+	if variant.Width != 1280 || variant.Height != 640 {
+		t.Errorf("size = %dx%d, want 1280x640", variant.Width, variant.Height)
+	}
+
+	decoded, format, err := image.Decode(bytes.NewReader(variant.Data))
+	if err != nil || format != "jpeg" {
+		t.Fatalf("normalized image is not a jpeg: %v", err)
+	}
+	if decoded.Bounds().Dx() != variant.Width {
+		t.Errorf("decoded width = %d", decoded.Bounds().Dx())
+	}
+
+	// 小さい画像は拡大しない
+	variant, err = Normalize(encodePNG(t, 300, 200))
+	if err != nil {
+		t.Fatalf("Normalize() error = %v", err)
+	}
+	if variant.Width != 300 || variant.Height != 200 {
+		t.Errorf("size = %dx%d, want 300x200", variant.Width, variant.Height)
+	}
+}
+
+func TestResize(t *testing.T) {
+	normalized, err := Normalize(encodePNG(t, 2000, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		width      int
+		wantWidth  int
+		wantHeight int
+	}{
+		{320, 320, 160},
+		{640, 640, 320},
+		{2000, 1280, 640},
+	}
+
+	for _, tt := range tests {
+		variant, err := Resize(normalized.Data, tt.width)
+		if err != nil {
+			t.Fatalf("Resize(%d) error = %v", tt.width, err)
+		}
+		if variant.Width != tt.wantWidth || variant.Height != tt.wantHeight {
+			t.Errorf("Resize(%d) = %dx%d, want %dx%d", tt.width, variant.Width, variant.Height, tt.wantWidth, tt.wantHeight)
+		}
+		if _, format, err := image.DecodeConfig(bytes.NewReader(variant.Data)); err != nil || format != "jpeg" {
+			t.Errorf("Resize(%d) is not a jpeg: %v", tt.width, err)
+		}
+	}
+}
+
+func TestNormalizeOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 20)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	variant, err := Normalize(withOrientation(buf.Bytes(), 6))
+	if err != nil {
+		t.Fatalf("Normalize() error = %v", err)
+	}
+	if variant.Width != 20 || variant.Height != 40 {
+		t.Errorf("rotated size = %dx%d, want 20x40", variant.Width, variant.Height)
+	}
+	// EXIF はエンコードし直すと残らない
+	if bytes.Contains(variant.Data, []byte("Exif")) {
+		t.Error("EXIF was not stripped")
+	}
+}
+
+func TestNormalizeRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"text", []byte("<html><body>not an image</body></html>"), ErrUnsupportedType},
+		{"truncated png", encodePNG(t, 10, 10)[:40], ErrInvalidImage},
+		{"too large", make([]byte, MaxUploadSize+1), ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		if _, err := Normalize(tt.data); !errors.Is(err, tt.want) {
+			t.Errorf("Normalize(%s) error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	store := NewLocalStore(t.TempDir(), LocalStorePath)
+	ctx := context.Background()
+
+	stored, err := SaveImage(ctx, store, "menu", encodePNG(t, 800, 600))
+	if err != nil {
+		t.Fatalf("SaveImage() error = %v", err)
+	}
+	if !strings.HasPrefix(stored.Key, "menu/") || !strings.HasSuffix(stored.Key, ".jpg") {
+		t.Errorf("stored = %+v", stored)
+	}
+
+	body, err := store.Get(ctx, stored.Key)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if _, format, err := image.DecodeConfig(bytes.NewReader(data)); err != nil || format != "jpeg" {
+		t.Errorf("stored blob is not a jpeg: %v", err)
+	}
+
+	DeleteImage(ctx, store, stored)
+	if _, err := store.Get(ctx, stored.Key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after delete error = %v, want ErrNotFound", err)
+	}
+
+	if err := store.Put(ctx, "../escape.jpg", ContentType, nil); err == nil {
+		t.Error("Put() with a key outside the directory should fail")
+	}
+}
@@ -0,0 +1,51 @@
+package media
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	now := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	signer := NewSigner([]byte("secret"))
+	signer.now = func() time.Time { return now }
+
+	id := "0b7c1f3e-9a8d-4c2b-8e6f-1a2b3c4d5e6f"
+	signed, expiresAt := signer.Sign(id, 640, time.Hour)
+	if !expiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("expiresAt = %v", expiresAt)
+	}
+
+	u, err := url.Parse(signed)
+	if err != nil || u.Path != Path(id) {
+		t.Fatalf("Sign() = %q", signed)
+	}
+	query := u.Query()
+	if query.Get("w") != "640" {
+		t.Errorf("w = %q", query.Get("w"))
+	}
+
+	expires, sig := query.Get("expires"), query.Get("sig")
+	if err := signer.Verify(id, 640, expires, sig); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+
+	// 幅や期限を書き換えたURLは使えない
+	if err := signer.Verify(id, 1280, expires, sig); err != ErrInvalidSignature {
+		t.Errorf("Verify() with another width error = %v, want ErrInvalidSignature", err)
+	}
+	later := strconv.FormatInt(now.Add(48*time.Hour).Unix(), 10)
+	if err := signer.Verify(id, 640, later, sig); err != ErrInvalidSignature {
+		t.Errorf("Verify() with another expiry error = %v, want ErrInvalidSignature", err)
+	}
+	if err := NewSigner([]byte("other")).Verify(id, 640, expires, sig); err != ErrInvalidSignature {
+		t.Errorf("Verify() with another key error = %v, want ErrInvalidSignature", err)
+	}
+
+	signer.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if err := signer.Verify(id, 640, expires, sig); err != ErrExpiredSignature {
+		t.Errorf("Verify() after expiry error = %v, want ErrExpiredSignature", err)
+	}
+}
@@ -0,0 +1,162 @@
+package media
+
+import (
+	"context"
+	"database/sql"
+	"domeal/model"
+	"log/slog"
+	"time"
+)
+
+const (
+	// sweepInterval ごとに確定されなかったアップロードと使われなくなった画像を掃除する
+	sweepInterval = 10 * time.Minute
+	sweepBatch    = 100
+	// orphanGrace の間は､確定したあと料理やレシートに付けられるのを待つ
+	orphanGrace = 24 * time.Hour
+)
+
+// UploadStore は確定されなかったアップロードと使われなくなった画像の掃除に使う
+type UploadStore interface {
+	ListExpiredUploads(tx *sql.Tx, now time.Time, limit int) ([]model.Upload, error)
+	DeleteUpload(tx *sql.Tx, id string) error
+	ListOrphanedMedia(tx *sql.Tx, before time.Time, limit int) ([]model.Media, error)
+	DeleteMedia(tx *sql.Tx, id string) error
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// Sweeper はURLを発行したまま確定されなかったアップロードをストレージと uploads から消す｡
+// 確定したまま料理やレシートに付けられなかった画像や､差し替えられた料理の画像もストレージと media から消す｡
+// 行をロックしてから消すので各レプリカで動かしてよい
+type Sweeper struct {
+	store UploadStore
+	blobs BlobStore
+	now   func() time.Time
+}
+
+func NewSweeper(store UploadStore, blobs BlobStore) *Sweeper {
+	return &Sweeper{
+		store: store,
+		blobs: blobs,
+		now:   time.Now,
+	}
+}
+
+// Run は ctx が終わるまで sweepInterval ごとに Sweep を呼ぶ
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		removed, err := s.Sweep(ctx)
+		if err != nil {
+			slog.Error("Failed to sweep uploads", "error", err)
+		}
+		if removed > 0 {
+			slog.Info("Swept expired uploads", "count", removed)
+		}
+
+		removed, err = s.SweepMedia(ctx)
+		if err != nil {
+			slog.Error("Failed to sweep media", "error", err)
+		}
+		if removed > 0 {
+			slog.Info("Swept orphaned media", "count", removed)
+		}
+	}
+}
+
+// Sweep は期限を過ぎたアップロードがなくなるまで消し､消した数を返す
+func (s *Sweeper) Sweep(ctx context.Context) (int, error) {
+	removed := 0
+	for {
+		n, more, err := s.sweepBatch(ctx)
+		removed += n
+		if err != nil || !more {
+			return removed, err
+		}
+	}
+}
+
+func (s *Sweeper) sweepBatch(ctx context.Context) (int, bool, error) {
+	tx, err := s.store.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	uploads, err := s.store.ListExpiredUploads(tx, s.now(), sweepBatch)
+	if err != nil {
+		return 0, false, err
+	}
+
+	removed := 0
+	for _, upload := range uploads {
+		// ストレージから消せなかったものは行を残して次に回す
+		if err := s.blobs.Delete(ctx, upload.ObjectKey); err != nil {
+			slog.Error("Failed to delete expired upload", "error", err, "upload_id", upload.ID, "key", upload.ObjectKey)
+			continue
+		}
+		if err := s.store.DeleteUpload(tx, upload.ID); err != nil {
+			return 0, false, err
+		}
+		removed++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, err
+	}
+
+	// 消せなかったものが残っていると同じ行を取り続けるので､そのときは次の回に回す
+	return removed, len(uploads) == sweepBatch && removed == len(uploads), nil
+}
+
+// SweepMedia は orphanGrace より前に登録されてどこからも使われていない画像がなくなるまで消し､消した数を返す
+func (s *Sweeper) SweepMedia(ctx context.Context) (int, error) {
+	removed := 0
+	for {
+		n, more, err := s.sweepMediaBatch(ctx)
+		removed += n
+		if err != nil || !more {
+			return removed, err
+		}
+	}
+}
+
+func (s *Sweeper) sweepMediaBatch(ctx context.Context) (int, bool, error) {
+	tx, err := s.store.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	media, err := s.store.ListOrphanedMedia(tx, s.now().Add(-orphanGrace), sweepBatch)
+	if err != nil {
+		return 0, false, err
+	}
+
+	removed := 0
+	for _, image := range media {
+		// ストレージから消せなかったものは行を残して次に回す
+		if err := s.blobs.Delete(ctx, image.ObjectKey); err != nil {
+			slog.Error("Failed to delete orphaned media", "error", err, "media_id", image.ID, "key", image.ObjectKey)
+			continue
+		}
+		if err := s.store.DeleteMedia(tx, image.ID); err != nil {
+			return 0, false, err
+		}
+		removed++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, err
+	}
+
+	return removed, len(media) == sweepBatch && removed == len(media), nil
+}
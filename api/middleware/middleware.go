@@ -3,9 +3,14 @@ package middleware
 import (
 	"context"
 	"database/sql"
+	"domeal/ratelimit"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"time"
 )
 
@@ -19,38 +24,75 @@ type User struct {
 	ID          int
 	DisplayName string
 	LineSub     string
+	// IsAdmin は運営者｡/api/admin を使える
+	IsAdmin bool
+}
+
+var (
+	// ErrMissingSession はCookieにセッションがない場合のエラー
+	ErrMissingSession = errors.New("missing session")
+	// ErrInvalidSession はセッションがDBに存在しない場合のエラー
+	ErrInvalidSession = errors.New("invalid session")
+)
+
+// Authenticate はCookieのセッションからユーザーを取得する｡
+// AuthMiddleware を通らないWebSocketなどのハンドラーでも同じ確認をするために使う
+func Authenticate(db *sql.DB, r *http.Request) (*User, error) {
+	// Cookieからsession_idを取得
+	cookie, err := r.Cookie("session_id")
+	if err != nil {
+		return nil, ErrMissingSession
+	}
+
+	sessionToken := cookie.Value
+
+	slog.Info("Authenticating user with session token", "session_token", sessionToken)
+
+	// セッションをDBから確認
+	var user User
+	var lastUsedAt time.Time
+	err = db.QueryRow(`
+        SELECT
+			u.id, u.display_name, u.line_sub, u.is_admin, s.last_used_at
+        FROM
+			sessions s
+        JOIN
+			users u ON s.user_id = u.id
+        WHERE
+			s.session_token = $1
+    `, sessionToken).Scan(&user.ID, &user.DisplayName, &user.LineSub, &user.IsAdmin, &lastUsedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidSession
+	} else if err != nil {
+		return nil, err
+	}
+
+	// 最終利用日時を更新（任意）
+	_, err = db.Exec(`
+        UPDATE
+			sessions
+        SET
+			last_used_at = NOW()
+        WHERE
+			session_token = $1
+    `, sessionToken)
+	if err != nil {
+		fmt.Println("Failed to update last_used_at:", err)
+	}
+
+	return &user, nil
 }
 
 // 認証ミドルウェア
 func AuthMiddleware(db *sql.DB) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Cookieからsession_idを取得
-			cookie, err := r.Cookie("session_id")
-			if err != nil {
+			user, err := Authenticate(db, r)
+			if errors.Is(err, ErrMissingSession) {
 				http.Error(w, "Unauthorized: missing session", http.StatusUnauthorized)
 				return
-			}
-
-			sessionToken := cookie.Value
-
-			slog.Info("Authenticating user with session token", "session_token", sessionToken)
-
-			// セッションをDBから確認
-			var user User
-			var lastUsedAt time.Time
-			err = db.QueryRow(`
-                SELECT
-					u.id, u.display_name, u.line_sub, s.last_used_at
-                FROM
-					sessions s
-                JOIN
-					users u ON s.user_id = u.id
-                WHERE
-					s.session_token = $1
-            `, sessionToken).Scan(&user.ID, &user.DisplayName, &user.LineSub, &lastUsedAt)
-
-			if err == sql.ErrNoRows {
+			} else if errors.Is(err, ErrInvalidSession) {
 				http.Error(w, "Unauthorized: invalid session", http.StatusUnauthorized)
 				return
 			} else if err != nil {
@@ -59,21 +101,8 @@ func AuthMiddleware(db *sql.DB) func(http.Handler) http.Handler {
 				return
 			}
 
-			// 最終利用日時を更新（任意）
-			_, err = db.Exec(`
-                UPDATE
-					sessions
-                SET
-					last_used_at = NOW()
-                WHERE
-					session_token = $1
-            `, sessionToken)
-			if err != nil {
-				fmt.Println("Failed to update last_used_at:", err)
-			}
-
 			// ユーザー情報をcontextに保存
-			ctx := context.WithValue(r.Context(), userContextKey, &user)
+			ctx := context.WithValue(r.Context(), userContextKey, user)
 
 			slog.Info("User authenticated", "user_id", user.ID)
 
@@ -83,8 +112,92 @@ func AuthMiddleware(db *sql.DB) func(http.Handler) http.Handler {
 	}
 }
 
+// AdminMiddleware は AuthMiddleware で認証したうえで､運営者でなければ 403 を返す
+func AdminMiddleware(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return AuthMiddleware(db)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// AuthMiddleware を通っているので必ず取り出せる
+			user, _ := GetUserFromContext(r.Context())
+			if !user.IsAdmin {
+				slog.Warn("Non-admin user tried to access admin API", "user_id", user.ID, "path", r.URL.Path)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
+
 // contextからUserを取り出すヘルパー
 func GetUserFromContext(ctx context.Context) (*User, bool) {
 	user, ok := ctx.Value(userContextKey).(*User)
 	return user, ok
 }
+
+// RateLimiter はリクエストを IP アドレスやユーザーごとに制限し､超えたら 429 を返す
+type RateLimiter struct {
+	limiter ratelimit.Limiter
+	// proxies は X-Forwarded-For を信用する nginx のアドレス
+	proxies []netip.Prefix
+}
+
+func NewRateLimiter(limiter ratelimit.Limiter, proxies []netip.Prefix) *RateLimiter {
+	return &RateLimiter{
+		limiter: limiter,
+		proxies: proxies,
+	}
+}
+
+// ByIP はクライアントの IP アドレスごとに name の制限をかける｡ログイン前のリクエストにも使える
+func (l *RateLimiter) ByIP(name string, rule ratelimit.Rule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := name + ":ip:" + ratelimit.ClientIP(r, l.proxies)
+			if l.allow(w, r, key, rule) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// ByUser はユーザーごとに name の制限をかける｡AuthMiddleware の内側で使う
+func (l *RateLimiter) ByUser(name string, rule ratelimit.Rule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := GetUserFromContext(r.Context())
+			if !ok {
+				slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡AuthMiddleware の内側で使うべき｡")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			key := name + ":user:" + strconv.Itoa(user.ID)
+			if l.allow(w, r, key, rule) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// allow は key で1回分を使えるか確かめ､使えなければ Retry-After をつけて 429 を返す｡
+// 数えられなかったときはサービスを止めないように通す
+func (l *RateLimiter) allow(w http.ResponseWriter, r *http.Request, key string, rule ratelimit.Rule) bool {
+	allowed, retryAfter, err := l.limiter.Allow(r.Context(), key, rule)
+	if err != nil {
+		slog.Error("Failed to check rate limit", "error", err, "key", key)
+		return true
+	}
+	if allowed {
+		return true
+	}
+
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	slog.Warn("Rate limit exceeded", "key", key, "path", r.URL.Path, "retry_after", seconds)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+	return false
+}
@@ -0,0 +1,480 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"time"
+)
+
+// DeletedUserName は退会したユーザーの表示名
+const DeletedUserName = "退会したユーザー"
+
+type AccountInterface interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	ExportUserData(tx *sql.Tx, userID int64) (*UserExport, error)
+	LockUser(tx *sql.Tx, userID int64) error
+	ListOwnedGroups(tx *sql.Tx, userID int64) ([]Group, error)
+	FindNextOwner(tx *sql.Tx, groupID, userID int64) (int64, bool, error)
+	TransferGroupOwnership(tx *sql.Tx, groupID, fromUserID, toUserID int64) (bool, error)
+	UpdateMemberRole(tx *sql.Tx, groupID, userID int64, role authz.Role) (bool, error)
+	SoftDeleteGroup(tx *sql.Tx, groupID int64) error
+	ListMemberGroups(tx *sql.Tx, userID int64) ([]Group, error)
+	RemoveGroupMember(tx *sql.Tx, groupID, userID int64) (bool, error)
+	CountGroupMembers(tx *sql.Tx, groupID int64) (int64, error)
+	PopWaitlist(tx *sql.Tx, groupID int64) (int64, bool, error)
+	AddGroupMember(tx *sql.Tx, groupID, userID int64, role authz.Role) error
+	DeleteUserToken(tx *sql.Tx, userID int64) (string, error)
+	DeleteUserSessions(tx *sql.Tx, userID int64) (int64, error)
+	AnonymizeUser(tx *sql.Tx, userID int64) error
+	CreateNotification(tx *sql.Tx, notification *Notification) error
+	AppendAuditEvent(tx *sql.Tx, event *AuditEvent) error
+}
+
+// UserExport は GET /api/me/export で返す個人データ
+type UserExport struct {
+	ExportedAt time.Time         `json:"exported_at"`
+	Profile    UserProfile       `json:"profile"`
+	Groups     []ExportedGroup   `json:"groups"`
+	Orders     []ExportedOrder   `json:"orders"`
+	Messages   []ExportedMessage `json:"messages"`
+	Payments   []ExportedPayment `json:"payments"`
+}
+
+type ExportedGroup struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Menu      string     `json:"menu"`
+	Status    string     `json:"status"`
+	Role      authz.Role `json:"role"`
+	JoinedAt  time.Time  `json:"joined_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+}
+
+type ExportedOrder struct {
+	GroupID      int64     `json:"group_id"`
+	MenuItemID   int64     `json:"menu_item_id"`
+	MenuItemName string    `json:"menu_item_name"`
+	Price        int64     `json:"price"`
+	Quantity     int64     `json:"quantity"`
+	Note         string    `json:"note"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type ExportedMessage struct {
+	ID        int64      `json:"id"`
+	GroupID   int64      `json:"group_id"`
+	Body      string     `json:"body"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+}
+
+type ExportedPayment struct {
+	ID          int64      `json:"id"`
+	GroupID     int64      `json:"group_id"`
+	PayerID     int64      `json:"payer_id"`
+	PayeeID     int64      `json:"payee_id"`
+	Amount      int64      `json:"amount"`
+	Status      string     `json:"status"`
+	PaidAt      time.Time  `json:"paid_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+}
+
+// ExportUserData はユーザーの個人データをまとめて返す｡
+// 途中で更新されても食い違わないように REPEATABLE READ のトランザクションで呼ぶ
+func (repo *Repository) ExportUserData(tx *sql.Tx, userID int64) (*UserExport, error) {
+	profile, err := scanUserProfile(tx.QueryRow(userProfileQuery, userID))
+	if err != nil {
+		return nil, err
+	}
+
+	export := &UserExport{
+		ExportedAt: time.Now(),
+		Profile:    *profile,
+		Groups:     []ExportedGroup{},
+		Orders:     []ExportedOrder{},
+		Messages:   []ExportedMessage{},
+		Payments:   []ExportedPayment{},
+	}
+
+	if err := exportGroups(tx, userID, export); err != nil {
+		return nil, err
+	}
+	if err := exportOrders(tx, userID, export); err != nil {
+		return nil, err
+	}
+	if err := exportMessages(tx, userID, export); err != nil {
+		return nil, err
+	}
+	if err := exportPayments(tx, userID, export); err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+func exportGroups(tx *sql.Tx, userID int64, export *UserExport) error {
+	query := `
+		SELECT
+			g.id, g.name, g.menu, g.status, gm.role, gm.joined_at, g.created_at, g.deleted_at
+		FROM
+			group_members gm
+		JOIN
+			groups g ON g.id = gm.group_id
+		WHERE
+			gm.user_id = $1
+		ORDER BY
+			gm.joined_at, g.id
+	`
+
+	rows, err := tx.Query(query, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var group ExportedGroup
+		var deletedAt sql.NullTime
+		err := rows.Scan(
+			&group.ID,
+			&group.Name,
+			&group.Menu,
+			&group.Status,
+			&group.Role,
+			&group.JoinedAt,
+			&group.CreatedAt,
+			&deletedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if deletedAt.Valid {
+			group.DeletedAt = &deletedAt.Time
+		}
+		export.Groups = append(export.Groups, group)
+	}
+
+	return rows.Err()
+}
+
+func exportOrders(tx *sql.Tx, userID int64, export *UserExport) error {
+	query := `
+		SELECT
+			gm.group_id, mi.id, mi.name, mi.price, mo.quantity, mo.note, mo.created_at, mo.updated_at
+		FROM
+			member_orders mo
+		JOIN
+			group_members gm ON gm.id = mo.group_member_id
+		JOIN
+			menu_items mi ON mi.id = mo.menu_item_id
+		WHERE
+			gm.user_id = $1
+		ORDER BY
+			mo.created_at, mo.id
+	`
+
+	rows, err := tx.Query(query, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var order ExportedOrder
+		var note sql.NullString
+		err := rows.Scan(
+			&order.GroupID,
+			&order.MenuItemID,
+			&order.MenuItemName,
+			&order.Price,
+			&order.Quantity,
+			&note,
+			&order.CreatedAt,
+			&order.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		order.Note = note.String
+		export.Orders = append(export.Orders, order)
+	}
+
+	return rows.Err()
+}
+
+func exportMessages(tx *sql.Tx, userID int64, export *UserExport) error {
+	query := `
+		SELECT
+			id, group_id, body, created_at, edited_at, deleted_at
+		FROM
+			group_messages
+		WHERE
+			user_id = $1
+		ORDER BY
+			id
+	`
+
+	rows, err := tx.Query(query, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message ExportedMessage
+		var editedAt, deletedAt sql.NullTime
+		err := rows.Scan(
+			&message.ID,
+			&message.GroupID,
+			&message.Body,
+			&message.CreatedAt,
+			&editedAt,
+			&deletedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if editedAt.Valid {
+			message.EditedAt = &editedAt.Time
+		}
+		if deletedAt.Valid {
+			message.DeletedAt = &deletedAt.Time
+		}
+		export.Messages = append(export.Messages, message)
+	}
+
+	return rows.Err()
+}
+
+func exportPayments(tx *sql.Tx, userID int64, export *UserExport) error {
+	query := `
+		SELECT
+			id, group_id, payer_id, payee_id, amount, status, paid_at, confirmed_at
+		FROM
+			payments
+		WHERE
+			payer_id = $1 OR payee_id = $1
+		ORDER BY
+			paid_at, id
+	`
+
+	rows, err := tx.Query(query, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var payment ExportedPayment
+		var confirmedAt sql.NullTime
+		err := rows.Scan(
+			&payment.ID,
+			&payment.GroupID,
+			&payment.PayerID,
+			&payment.PayeeID,
+			&payment.Amount,
+			&payment.Status,
+			&payment.PaidAt,
+			&confirmedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if confirmedAt.Valid {
+			payment.ConfirmedAt = &confirmedAt.Time
+		}
+		export.Payments = append(export.Payments, payment)
+	}
+
+	return rows.Err()
+}
+
+// LockUser は退会の処理が重ならないようにユーザーの行をロックする｡退会済みなら sql.ErrNoRows を返す
+func (repo *Repository) LockUser(tx *sql.Tx, userID int64) error {
+	query := `
+		SELECT
+			id
+		FROM
+			users
+		WHERE
+			id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`
+
+	var id int64
+	return tx.QueryRow(query, userID).Scan(&id)
+}
+
+// ListOwnedGroups はユーザーがオーナーの削除されていないグループの ID と名前をロックして返す
+func (repo *Repository) ListOwnedGroups(tx *sql.Tx, userID int64) ([]Group, error) {
+	query := `
+		SELECT
+			g.id, g.name
+		FROM
+			group_members gm
+		JOIN
+			groups g ON g.id = gm.group_id
+		WHERE
+			gm.user_id = $1 AND gm.role = 'owner' AND g.deleted_at IS NULL
+		ORDER BY
+			g.id
+		FOR UPDATE OF g
+	`
+
+	rows, err := tx.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []Group
+	for rows.Next() {
+		var group Group
+		if err := rows.Scan(&group.ID, &group.Name); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// ListMemberGroups はユーザーがメンバーになっている削除されていないグループをロックして返す
+func (repo *Repository) ListMemberGroups(tx *sql.Tx, userID int64) ([]Group, error) {
+	query := `
+		SELECT
+			g.id, g.name, g.menu, g.menu_image_url, g.created_by, g.max_members, g.status, g.split_method, g.deadline
+		FROM
+			groups g
+		JOIN
+			group_members gm ON gm.group_id = g.id
+		WHERE
+			gm.user_id = $1 AND g.deleted_at IS NULL
+		ORDER BY
+			g.id
+		FOR UPDATE OF g
+	`
+
+	rows, err := tx.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []Group
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, *group)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// FindNextOwner はオーナーを引き継ぐメンバーを返す｡退会していないメンバーのうち､
+// 幹事､普通のメンバー､見るだけのメンバーの順に､その中で一番先に参加した人にする
+func (repo *Repository) FindNextOwner(tx *sql.Tx, groupID, userID int64) (int64, bool, error) {
+	query := `
+		SELECT
+			gm.user_id
+		FROM
+			group_members gm
+		JOIN
+			users u ON u.id = gm.user_id
+		WHERE
+			gm.group_id = $1 AND gm.user_id <> $2 AND u.deleted_at IS NULL
+		ORDER BY
+			CASE gm.role WHEN 'organiser' THEN 0 WHEN 'member' THEN 1 ELSE 2 END, gm.joined_at, gm.id
+		LIMIT 1
+	`
+
+	var nextOwnerID int64
+	err := tx.QueryRow(query, groupID, userID).Scan(&nextOwnerID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return nextOwnerID, true, nil
+}
+
+// DeleteUserToken は LINE のトークンを消し､取り消すためにアクセストークンを返す｡なければ sql.ErrNoRows を返す
+func (repo *Repository) DeleteUserToken(tx *sql.Tx, userID int64) (string, error) {
+	var accessToken string
+	err := tx.QueryRow("DELETE FROM user_tokens WHERE user_id = $1 RETURNING access_token", userID).Scan(&accessToken)
+	if err != nil {
+		return "", err
+	}
+
+	return accessToken, nil
+}
+
+// DeleteUserSessions はユーザーのすべての端末をログアウトさせ､消したセッションの数を返す
+func (repo *Repository) DeleteUserSessions(tx *sql.Tx, userID int64) (int64, error) {
+	result, err := tx.Exec("DELETE FROM sessions WHERE user_id = $1", userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// AnonymizeUser はユーザーを退会済みにして個人データを消す｡
+// 精算やグループの記録は他のメンバーのものでもあるので､行は残して名前だけを置き換える
+func (repo *Repository) AnonymizeUser(tx *sql.Tx, userID int64) error {
+	// line_sub を置き換えるので､同じ LINE アカウントで再びログインすると別のユーザーになる
+	query := `
+		UPDATE
+			users
+		SET
+			line_sub = 'deleted:' || id,
+			display_name = $2,
+			line_display_name = $2,
+			display_name_overridden = FALSE,
+			picture_url = NULL,
+			paypal_me_username = NULL,
+			email = NULL,
+			email_verified_at = NULL,
+			updated_at = CURRENT_TIMESTAMP,
+			deleted_at = CURRENT_TIMESTAMP
+		WHERE
+			id = $1
+	`
+	if _, err := tx.Exec(query, userID, DeletedUserName); err != nil {
+		return err
+	}
+
+	statements := []string{
+		// チャットの本文は削除したメッセージと同じく空にする
+		"UPDATE group_messages SET body = '', deleted_at = COALESCE(deleted_at, CURRENT_TIMESTAMP) WHERE user_id = $1",
+		"DELETE FROM group_message_reads WHERE user_id = $1",
+		"DELETE FROM group_waitlist WHERE user_id = $1",
+		"DELETE FROM notifications WHERE user_id = $1",
+		"DELETE FROM notification_opt_outs WHERE user_id = $1",
+		"DELETE FROM email_verifications WHERE user_id = $1",
+		"DELETE FROM notification_outbox WHERE user_id = $1 AND status = 'pending'",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, userID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,337 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+type AdminInterface interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	SearchUsers(query string, limit int) ([]AdminUser, error)
+	SearchGroups(query, status string, limit int) ([]AdminGroup, error)
+	LockUser(tx *sql.Tx, userID int64) error
+	DeleteUserSessions(tx *sql.Tx, userID int64) (int64, error)
+	LockGroup(tx *sql.Tx, groupID int64) (*Group, error)
+	UpdateGroup(tx *sql.Tx, group *Group) error
+	SoftDeleteGroup(tx *sql.Tx, groupID int64) error
+	ListGroupMemberIDs(tx *sql.Tx, groupID int64) ([]int64, error)
+	ListWaitlistUserIDs(tx *sql.Tx, groupID int64) ([]int64, error)
+	CreateNotification(tx *sql.Tx, notification *Notification) error
+	GetSettlement(groupID int64, method SplitMethod) (*Settlement, error)
+	ListGroupPayPalMeUsernames(groupID int64) (map[int64]string, error)
+	CreateAdminAction(tx *sql.Tx, action *AdminAction) error
+	ListAdminActions(filter AdminActionFilter) ([]AdminAction, error)
+	AppendAuditEvent(tx *sql.Tx, event *AuditEvent) error
+	ListAuditEvents(filter AuditEventFilter) ([]AuditEvent, error)
+}
+
+// AdminUser は運営者の検索に出すユーザー｡退会したユーザーも含む
+type AdminUser struct {
+	ID              int64      `json:"id"`
+	DisplayName     string     `json:"display_name"`
+	LineDisplayName string     `json:"line_display_name"`
+	Email           *string    `json:"email"`
+	IsAdmin         bool       `json:"is_admin"`
+	SessionCount    int64      `json:"session_count"`
+	CreatedAt       time.Time  `json:"created_at"`
+	DeletedAt       *time.Time `json:"deleted_at"`
+}
+
+// AdminGroup は運営者の検索に出すグループ｡削除したグループも含む
+type AdminGroup struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	CreatedBy   int64      `json:"created_by"`
+	OwnerID     *int64     `json:"owner_id"`
+	MemberCount int64      `json:"member_count"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeletedAt   *time.Time `json:"deleted_at"`
+}
+
+// AdminAction は運営者の操作の記録｡admin_actions は書き足すだけで変更も削除もできない
+type AdminAction struct {
+	ID          int64           `json:"id"`
+	AdminUserID int64           `json:"admin_user_id"`
+	AdminName   string          `json:"admin_name"`
+	Action      string          `json:"action"`
+	TargetType  string          `json:"target_type,omitempty"`
+	TargetID    *int64          `json:"target_id"`
+	Detail      json.RawMessage `json:"detail"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+const (
+	AdminActionSearchUsers     = "search_users"
+	AdminActionSearchGroups    = "search_groups"
+	AdminActionCloseGroup      = "close_group"
+	AdminActionDeleteGroup     = "delete_group"
+	AdminActionRevokeSessions  = "revoke_sessions"
+	AdminActionViewActions     = "view_admin_actions"
+	AdminActionViewAuditEvents = "view_audit_events"
+
+	AdminTargetUser  = "user"
+	AdminTargetGroup = "group"
+)
+
+// GroupStatusDeleted は運営者の検索で削除したグループだけを探すための状態｡groups.status には入らない
+const GroupStatusDeleted = "deleted"
+
+// AdminActionFilter は操作の記録の絞り込み｡ゼロ値の条件は使わない
+type AdminActionFilter struct {
+	AdminUserID int64
+	TargetType  string
+	TargetID    int64
+	BeforeID    int64
+	Limit       int
+}
+
+// likePattern は検索語を部分一致の ILIKE のパターンにする｡% や _ は文字そのものとして扱う
+func likePattern(query string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(query)
+	return "%" + escaped + "%"
+}
+
+// SearchUsers は ID､表示名､LINE の名前､メールアドレスでユーザーを探して新しい順に返す｡空の検索語なら全員
+func (repo *Repository) SearchUsers(query string, limit int) ([]AdminUser, error) {
+	sqlQuery := `
+		SELECT
+			u.id, u.display_name, u.line_display_name, u.email, u.is_admin,
+			(SELECT COUNT(*) FROM sessions s WHERE s.user_id = u.id),
+			u.created_at, u.deleted_at
+		FROM
+			users u
+		WHERE
+			$1::TEXT = ''
+			OR u.id::TEXT = $1::TEXT
+			OR u.display_name ILIKE $2
+			OR u.line_display_name ILIKE $2
+			OR u.email ILIKE $2
+		ORDER BY
+			u.id DESC
+		LIMIT $3
+	`
+
+	stmt, err := repo.db.Prepare(sqlQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(query, likePattern(query), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []AdminUser{}
+	for rows.Next() {
+		var user AdminUser
+		var email sql.NullString
+		var deletedAt sql.NullTime
+		err := rows.Scan(
+			&user.ID,
+			&user.DisplayName,
+			&user.LineDisplayName,
+			&email,
+			&user.IsAdmin,
+			&user.SessionCount,
+			&user.CreatedAt,
+			&deletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if email.Valid {
+			user.Email = &email.String
+		}
+		if deletedAt.Valid {
+			user.DeletedAt = &deletedAt.Time
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// SearchGroups は ID か名前でグループを探して新しい順に返す｡
+// status が open か closed なら削除していないその状態のグループ､deleted なら削除したグループだけにする
+func (repo *Repository) SearchGroups(query, status string, limit int) ([]AdminGroup, error) {
+	sqlQuery := `
+		SELECT
+			g.id, g.name, g.status, g.created_by, owner.user_id,
+			(SELECT COUNT(*) FROM group_members gm WHERE gm.group_id = g.id),
+			g.created_at, g.deleted_at
+		FROM
+			groups g
+		LEFT JOIN
+			group_members owner ON owner.group_id = g.id AND owner.role = 'owner'
+		WHERE
+			($1::TEXT = '' OR g.id::TEXT = $1::TEXT OR g.name ILIKE $2)
+			AND (
+				$3::TEXT = ''
+				OR ($3::TEXT = 'deleted' AND g.deleted_at IS NOT NULL)
+				OR (g.status = $3::TEXT AND g.deleted_at IS NULL)
+			)
+		ORDER BY
+			g.id DESC
+		LIMIT $4
+	`
+
+	stmt, err := repo.db.Prepare(sqlQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(query, likePattern(query), status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []AdminGroup{}
+	for rows.Next() {
+		var group AdminGroup
+		var ownerID sql.NullInt64
+		var deletedAt sql.NullTime
+		err := rows.Scan(
+			&group.ID,
+			&group.Name,
+			&group.Status,
+			&group.CreatedBy,
+			&ownerID,
+			&group.MemberCount,
+			&group.CreatedAt,
+			&deletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if ownerID.Valid {
+			group.OwnerID = &ownerID.Int64
+		}
+		if deletedAt.Valid {
+			group.DeletedAt = &deletedAt.Time
+		}
+		groups = append(groups, group)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// CreateAdminAction は運営者の操作を記録し､ID と作成日時を埋める｡
+// 変更を伴う操作は変更と同じトランザクションで記録する
+func (repo *Repository) CreateAdminAction(tx *sql.Tx, action *AdminAction) error {
+	query := `
+		INSERT INTO
+			admin_actions (admin_user_id, action, target_type, target_id, detail, created_at)
+		VALUES
+			($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+		RETURNING id, created_at
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	detail := "{}"
+	if len(action.Detail) > 0 {
+		detail = string(action.Detail)
+	}
+
+	err = stmt.QueryRow(
+		action.AdminUserID,
+		action.Action,
+		nullString(action.TargetType),
+		action.TargetID,
+		detail,
+	).Scan(&action.ID, &action.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ListAdminActions は操作の記録を新しい順に返す
+func (repo *Repository) ListAdminActions(filter AdminActionFilter) ([]AdminAction, error) {
+	query := `
+		SELECT
+			a.id, a.admin_user_id, u.display_name, a.action, a.target_type, a.target_id, a.detail, a.created_at
+		FROM
+			admin_actions a
+		JOIN
+			users u ON u.id = a.admin_user_id
+		WHERE
+			($1::BIGINT = 0 OR a.admin_user_id = $1::BIGINT)
+			AND ($2::TEXT = '' OR a.target_type = $2::TEXT)
+			AND ($3::BIGINT = 0 OR a.target_id = $3::BIGINT)
+			AND ($4::BIGINT = 0 OR a.id < $4::BIGINT)
+		ORDER BY
+			a.id DESC
+		LIMIT $5
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(filter.AdminUserID, filter.TargetType, filter.TargetID, filter.BeforeID, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []AdminAction{}
+	for rows.Next() {
+		var action AdminAction
+		var targetType sql.NullString
+		var targetID sql.NullInt64
+		var detail []byte
+		err := rows.Scan(
+			&action.ID,
+			&action.AdminUserID,
+			&action.AdminName,
+			&action.Action,
+			&targetType,
+			&targetID,
+			&detail,
+			&action.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		action.TargetType = targetType.String
+		if targetID.Valid {
+			action.TargetID = &targetID.Int64
+		}
+		action.Detail = detail
+		actions = append(actions, action)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return actions, nil
+}
@@ -0,0 +1,22 @@
+package model
+
+import "testing"
+
+func TestLikePattern(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "%%"},
+		{"domeal", "%domeal%"},
+		{"100%", `%100\%%`},
+		{"a_b", `%a\_b%`},
+		{`c:\tmp`, `%c:\\tmp%`},
+	}
+
+	for _, tt := range tests {
+		if got := likePattern(tt.query); got != tt.want {
+			t.Errorf("likePattern(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
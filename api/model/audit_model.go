@@ -0,0 +1,212 @@
+package model
+
+import (
+	"database/sql"
+	"domeal/authz"
+	"encoding/json"
+	"time"
+)
+
+type AuditInterface interface {
+	GetGroup(groupID int64) (*Group, error)
+	GetMemberRole(groupID, userID int64) (authz.Role, bool, error)
+	ListAuditEvents(filter AuditEventFilter) ([]AuditEvent, error)
+}
+
+// AuditEvent は audit_events に書き足す操作の記録｡変更も削除もできない｡
+// Detail には名前などの個人情報を入れず､ID で残す
+type AuditEvent struct {
+	ID            int64          `json:"id"`
+	Action        string         `json:"action"`
+	ActorUserID   *int64         `json:"actor_user_id"`
+	ActorName     string         `json:"actor_name,omitempty"`
+	GroupID       *int64         `json:"group_id"`
+	SubjectUserID *int64         `json:"subject_user_id"`
+	SubjectName   string         `json:"subject_name,omitempty"`
+	Detail        map[string]any `json:"detail"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+const (
+	AuditActionSignUp             = "sign_up"
+	AuditActionLogin              = "login"
+	AuditActionSessionCreated     = "session_created"
+	AuditActionSessionsRevoked    = "sessions_revoked"
+	AuditActionAccountDeleted     = "account_deleted"
+	AuditActionGroupCreated       = "group_created"
+	AuditActionGroupUpdated       = "group_updated"
+	AuditActionGroupDeleted       = "group_deleted"
+	AuditActionMemberJoined       = "member_joined"
+	AuditActionMemberWaitlisted   = "member_waitlisted"
+	AuditActionMemberPromoted     = "member_promoted"
+	AuditActionMemberLeft         = "member_left"
+	AuditActionWaitlistLeft       = "waitlist_left"
+	AuditActionMemberRoleChanged  = "member_role_changed"
+	AuditActionMemberShareChanged = "member_share_changed"
+	AuditActionPaymentPaid        = "payment_paid"
+	AuditActionPaymentConfirmed   = "payment_confirmed"
+	AuditActionExpenseCreated     = "expense_created"
+	AuditActionExpenseUpdated     = "expense_updated"
+	AuditActionExpenseDeleted     = "expense_deleted"
+)
+
+// AuditEventFilter は記録の絞り込み｡ゼロ値の条件は使わない
+type AuditEventFilter struct {
+	ActorUserID   int64
+	GroupID       int64
+	SubjectUserID int64
+	Action        string
+	Since         *time.Time
+	Until         *time.Time
+	BeforeID      int64
+	Limit         int
+}
+
+// NewAuditEvent は actorID が action をした記録を作る｡actorID､groupID､subjectID は 0 なら記録しない
+func NewAuditEvent(action string, actorID, groupID, subjectID int64, detail map[string]any) *AuditEvent {
+	event := &AuditEvent{
+		Action: action,
+		Detail: detail,
+	}
+	if actorID != 0 {
+		event.ActorUserID = &actorID
+	}
+	if groupID != 0 {
+		event.GroupID = &groupID
+	}
+	if subjectID != 0 {
+		event.SubjectUserID = &subjectID
+	}
+
+	return event
+}
+
+// AppendAuditEvent は変更と同じトランザクションで記録を書き足し､ID と作成日時を埋める
+func (repo *Repository) AppendAuditEvent(tx *sql.Tx, event *AuditEvent) error {
+	query := `
+		INSERT INTO
+			audit_events (action, actor_user_id, group_id, subject_user_id, detail, created_at)
+		VALUES
+			($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+		RETURNING id, created_at
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	detail := []byte("{}")
+	if len(event.Detail) > 0 {
+		detail, err = json.Marshal(event.Detail)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = stmt.QueryRow(
+		event.Action,
+		event.ActorUserID,
+		event.GroupID,
+		event.SubjectUserID,
+		string(detail),
+	).Scan(&event.ID, &event.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ListAuditEvents は記録を新しい順に返す
+func (repo *Repository) ListAuditEvents(filter AuditEventFilter) ([]AuditEvent, error) {
+	query := `
+		SELECT
+			e.id, e.action, e.actor_user_id, actor.display_name, e.group_id,
+			e.subject_user_id, subject.display_name, e.detail, e.created_at
+		FROM
+			audit_events e
+		LEFT JOIN
+			users actor ON actor.id = e.actor_user_id
+		LEFT JOIN
+			users subject ON subject.id = e.subject_user_id
+		WHERE
+			($1::BIGINT = 0 OR e.actor_user_id = $1::BIGINT)
+			AND ($2::BIGINT = 0 OR e.group_id = $2::BIGINT)
+			AND ($3::BIGINT = 0 OR e.subject_user_id = $3::BIGINT)
+			AND ($4::TEXT = '' OR e.action = $4::TEXT)
+			AND ($5::TIMESTAMPTZ IS NULL OR e.created_at >= $5::TIMESTAMPTZ)
+			AND ($6::TIMESTAMPTZ IS NULL OR e.created_at < $6::TIMESTAMPTZ)
+			AND ($7::BIGINT = 0 OR e.id < $7::BIGINT)
+		ORDER BY
+			e.id DESC
+		LIMIT $8
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(
+		filter.ActorUserID,
+		filter.GroupID,
+		filter.SubjectUserID,
+		filter.Action,
+		filter.Since,
+		filter.Until,
+		filter.BeforeID,
+		filter.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		var actorID, groupID, subjectID sql.NullInt64
+		var actorName, subjectName sql.NullString
+		var detail []byte
+		err := rows.Scan(
+			&event.ID,
+			&event.Action,
+			&actorID,
+			&actorName,
+			&groupID,
+			&subjectID,
+			&subjectName,
+			&detail,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if actorID.Valid {
+			event.ActorUserID = &actorID.Int64
+		}
+		if groupID.Valid {
+			event.GroupID = &groupID.Int64
+		}
+		if subjectID.Valid {
+			event.SubjectUserID = &subjectID.Int64
+		}
+		event.ActorName = actorName.String
+		event.SubjectName = subjectName.String
+		if err := json.Unmarshal(detail, &event.Detail); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,24 @@
+package model
+
+import "testing"
+
+func TestNewAuditEvent(t *testing.T) {
+	event := NewAuditEvent(AuditActionMemberPromoted, 0, 10, 3, nil)
+	if event.ActorUserID != nil {
+		t.Errorf("ActorUserID = %v, want nil for a system event", *event.ActorUserID)
+	}
+	if event.GroupID == nil || *event.GroupID != 10 {
+		t.Errorf("GroupID = %v, want 10", event.GroupID)
+	}
+	if event.SubjectUserID == nil || *event.SubjectUserID != 3 {
+		t.Errorf("SubjectUserID = %v, want 3", event.SubjectUserID)
+	}
+
+	event = NewAuditEvent(AuditActionLogin, 5, 0, 5, nil)
+	if event.GroupID != nil {
+		t.Errorf("GroupID = %v, want nil for an event outside a group", *event.GroupID)
+	}
+	if event.ActorUserID == nil || *event.ActorUserID != 5 {
+		t.Errorf("ActorUserID = %v, want 5", event.ActorUserID)
+	}
+}
@@ -0,0 +1,177 @@
+package model
+
+import (
+	"log/slog"
+	"sort"
+)
+
+type BalanceInterface interface {
+	GetUserBalances(userID int64) (*UserBalances, error)
+}
+
+// UserBalances はユーザーが参加しているすべてのグループをまたいだ貸し借り
+type UserBalances struct {
+	UserID int64 `json:"user_id"`
+	// NetAmount はプラスなら受け取る側
+	NetAmount int64 `json:"net_amount"`
+	// Balances は相手ごとに相殺した貸し借り
+	Balances []CounterpartyBalance `json:"balances"`
+	// SuggestedTransfers は相手ごとに相殺した貸し借りを清算する送金｡
+	// ほかのメンバー同士の貸し借りは見せず､ユーザーが払うか受け取る送金だけにする
+	SuggestedTransfers []BalanceTransfer `json:"suggested_transfers"`
+}
+
+type CounterpartyBalance struct {
+	UserID      int64  `json:"user_id"`
+	DisplayName string `json:"display_name"`
+	// Amount はプラスなら相手から受け取る､マイナスなら相手に払う
+	Amount int64          `json:"amount"`
+	Groups []GroupBalance `json:"groups"`
+}
+
+type GroupBalance struct {
+	GroupID   int64  `json:"group_id"`
+	GroupName string `json:"group_name"`
+	Amount    int64  `json:"amount"`
+}
+
+type BalanceTransfer struct {
+	Transfer
+	FromDisplayName string `json:"from_display_name"`
+	ToDisplayName   string `json:"to_display_name"`
+}
+
+// GetUserBalances はユーザーが参加しているグループごとに未確認の送金を集め､
+// 相手ごとに相殺した残高と､まとめて清算するための送金を計算する
+func (repo *Repository) GetUserBalances(userID int64) (*UserBalances, error) {
+	groups, err := repo.ListUserGroups(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[int64]string{}
+	counterparties := map[int64]*CounterpartyBalance{}
+	for _, group := range groups {
+		settlement, err := repo.GetSettlement(group.ID, group.SplitMethod)
+		if err != nil {
+			if IsSplitError(err) {
+				// 分担の設定が途中のグループは精算できないので除外する
+				slog.Warn("Skipping group that cannot be settled", "group_id", group.ID, "error", err)
+				continue
+			}
+			return nil, err
+		}
+
+		payments, err := repo.ListPayments(group.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, share := range settlement.Shares {
+			if share.DisplayName != "" {
+				names[share.UserID] = share.DisplayName
+			}
+		}
+
+		for _, status := range BuildPaymentStatuses(settlement, payments, nil) {
+			if status.Outstanding <= 0 {
+				continue
+			}
+			var counterpartyID, amount int64
+			switch userID {
+			case status.ToUserID:
+				counterpartyID, amount = status.FromUserID, status.Outstanding
+			case status.FromUserID:
+				counterpartyID, amount = status.ToUserID, -status.Outstanding
+			default:
+				continue
+			}
+
+			counterparty, ok := counterparties[counterpartyID]
+			if !ok {
+				counterparty = &CounterpartyBalance{UserID: counterpartyID}
+				counterparties[counterpartyID] = counterparty
+			}
+			counterparty.Amount += amount
+			counterparty.Groups = append(counterparty.Groups, GroupBalance{
+				GroupID:   group.ID,
+				GroupName: group.Name,
+				Amount:    amount,
+			})
+		}
+	}
+
+	balances := &UserBalances{
+		UserID:             userID,
+		Balances:           []CounterpartyBalance{},
+		SuggestedTransfers: []BalanceTransfer{},
+	}
+	for _, counterparty := range counterparties {
+		counterparty.DisplayName = names[counterparty.UserID]
+		balances.NetAmount += counterparty.Amount
+		balances.Balances = append(balances.Balances, *counterparty)
+	}
+	sort.Slice(balances.Balances, func(a, b int) bool {
+		return balances.Balances[a].UserID < balances.Balances[b].UserID
+	})
+
+	for _, counterparty := range balances.Balances {
+		transfer := Transfer{FromUserID: counterparty.UserID, ToUserID: userID, Amount: counterparty.Amount}
+		if counterparty.Amount < 0 {
+			transfer = Transfer{FromUserID: userID, ToUserID: counterparty.UserID, Amount: -counterparty.Amount}
+		}
+		if transfer.Amount == 0 {
+			continue
+		}
+		balances.SuggestedTransfers = append(balances.SuggestedTransfers, BalanceTransfer{
+			Transfer:        transfer,
+			FromDisplayName: names[transfer.FromUserID],
+			ToDisplayName:   names[transfer.ToUserID],
+		})
+	}
+
+	return balances, nil
+}
+
+// ListUserGroups はユーザーがメンバーになっている削除されていないグループを返す
+func (repo *Repository) ListUserGroups(userID int64) ([]Group, error) {
+	query := `
+		SELECT
+			g.id, g.name, g.menu, g.menu_image_url, g.created_by, g.max_members, g.status, g.split_method, g.deadline
+		FROM
+			groups g
+		JOIN
+			group_members gm ON gm.group_id = g.id
+		WHERE
+			gm.user_id = $1 AND g.deleted_at IS NULL
+		ORDER BY
+			g.id
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []Group
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, *group)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
@@ -0,0 +1,122 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type DeadlineInterface interface {
+	TryAdvisoryLock(tx *sql.Tx, key int64) (bool, error)
+	ListGroupsPastDeadline(now time.Time, limit int) ([]int64, error)
+	ListGroupsToRemind(now, until time.Time, limit int) ([]int64, error)
+	LockGroup(tx *sql.Tx, groupID int64) (*Group, error)
+	UpdateGroup(tx *sql.Tx, group *Group) error
+	MarkDeadlineReminded(tx *sql.Tx, groupID int64, remindedAt time.Time) (bool, error)
+	ListGroupMemberIDs(tx *sql.Tx, groupID int64) ([]int64, error)
+	CreateNotification(tx *sql.Tx, notification *Notification) error
+	GetSettlement(groupID int64, method SplitMethod) (*Settlement, error)
+	ListGroupPayPalMeUsernames(groupID int64) (map[int64]string, error)
+	DeleteFinishedJobs(before time.Time, limit int) (int64, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// TryAdvisoryLock は key のアドバイザリロックを待たずに取る｡
+// ロックはトランザクションが終わると外れる
+func (repo *Repository) TryAdvisoryLock(tx *sql.Tx, key int64) (bool, error) {
+	var locked bool
+	err := tx.QueryRow("SELECT pg_try_advisory_xact_lock($1)", key).Scan(&locked)
+	if err != nil {
+		return false, err
+	}
+
+	return locked, nil
+}
+
+// ListGroupsPastDeadline は now までに締め切りを過ぎたのにまだ open のグループを古い順に返す
+func (repo *Repository) ListGroupsPastDeadline(now time.Time, limit int) ([]int64, error) {
+	query := `
+		SELECT
+			id
+		FROM
+			groups
+		WHERE
+			status = 'open' AND deleted_at IS NULL AND deadline <= $1
+		ORDER BY
+			deadline, id
+		LIMIT $2
+	`
+
+	return repo.queryGroupIDs(query, now, limit)
+}
+
+// ListGroupsToRemind は now より後､until までに締め切りが来る､まだリマインドしていないグループを返す
+func (repo *Repository) ListGroupsToRemind(now, until time.Time, limit int) ([]int64, error) {
+	query := `
+		SELECT
+			id
+		FROM
+			groups
+		WHERE
+			status = 'open' AND deleted_at IS NULL AND deadline_reminded_at IS NULL
+			AND deadline > $1 AND deadline <= $2
+		ORDER BY
+			deadline, id
+		LIMIT $3
+	`
+
+	return repo.queryGroupIDs(query, now, until, limit)
+}
+
+// MarkDeadlineReminded はリマインドを送ったことを記録する｡すでに送っていれば false を返す
+func (repo *Repository) MarkDeadlineReminded(tx *sql.Tx, groupID int64, remindedAt time.Time) (bool, error) {
+	query := `
+		UPDATE
+			groups
+		SET
+			deadline_reminded_at = $2
+		WHERE
+			id = $1 AND deadline_reminded_at IS NULL
+	`
+
+	result, err := tx.Exec(query, groupID, remindedAt)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (repo *Repository) queryGroupIDs(query string, args ...any) ([]int64, error) {
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groupIDs []int64
+	for rows.Next() {
+		var groupID int64
+		if err := rows.Scan(&groupID); err != nil {
+			return nil, err
+		}
+		groupIDs = append(groupIDs, groupID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groupIDs, nil
+}
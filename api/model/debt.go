@@ -0,0 +1,101 @@
+package model
+
+import "sort"
+
+// maxExactMinimizeUsers を超える人数では部分集合の全探索をせず SettleBalances で近似する
+const maxExactMinimizeUsers = 16
+
+// NetBalances は送金の一覧をユーザーごとの収支(プラスは受け取る側)にまとめる
+func NetBalances(debts []Transfer) map[int64]int64 {
+	balances := map[int64]int64{}
+	for _, debt := range debts {
+		balances[debt.ToUserID] += debt.Amount
+		balances[debt.FromUserID] -= debt.Amount
+	}
+	return balances
+}
+
+// MinimizeTransfers は収支を清算するための送金の件数をできるだけ少なくする｡
+// 収支の合計が0になるグループに分けられるだけ分ければ､各グループは人数-1件の送金で清算できるので､
+// グループの数が最大になる分け方を部分集合のDPで探す
+func MinimizeTransfers(balances map[int64]int64) []Transfer {
+	var userIDs []int64
+	for userID, amount := range balances {
+		if amount != 0 {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	sort.Slice(userIDs, func(a, b int) bool { return userIDs[a] < userIDs[b] })
+
+	n := len(userIDs)
+	if n > maxExactMinimizeUsers {
+		return SettleBalances(balances)
+	}
+
+	full := 1<<n - 1
+	sums := make([]int64, full+1)
+	for mask := 1; mask <= full; mask++ {
+		lowest := mask & -mask
+		i := bitIndex(lowest)
+		sums[mask] = sums[mask^lowest] + balances[userIDs[i]]
+	}
+
+	// groups[mask] は mask の人たちを合計0のグループに分けたときの最大のグループ数
+	groups := make([]int, full+1)
+	for mask := 1; mask <= full; mask++ {
+		best := 0
+		for rest := mask; rest > 0; rest &= rest - 1 {
+			bit := rest & -rest
+			best = max(best, groups[mask^bit])
+		}
+		if sums[mask] == 0 {
+			best++
+		}
+		groups[mask] = best
+	}
+
+	// 1人ずつ取り除きながら並びを復元すると､先頭から足して0になるところがグループの区切りになる
+	order := make([]int, 0, n)
+	for mask := full; mask > 0; {
+		bonus := 0
+		if sums[mask] == 0 {
+			bonus = 1
+		}
+		for rest := mask; rest > 0; rest &= rest - 1 {
+			bit := rest & -rest
+			if groups[mask^bit]+bonus == groups[mask] {
+				order = append(order, bitIndex(bit))
+				mask ^= bit
+				break
+			}
+		}
+	}
+
+	transfers := []Transfer{}
+	group := map[int64]int64{}
+	var sum int64
+	for i := len(order) - 1; i >= 0; i-- {
+		userID := userIDs[order[i]]
+		group[userID] = balances[userID]
+		sum += balances[userID]
+		if sum == 0 {
+			transfers = append(transfers, SettleBalances(group)...)
+			group = map[int64]int64{}
+		}
+	}
+	// 収支の合計が0でない入力でも取りこぼさない
+	if len(group) > 0 {
+		transfers = append(transfers, SettleBalances(group)...)
+	}
+
+	return transfers
+}
+
+func bitIndex(bit int) int {
+	i := 0
+	for bit > 1 {
+		bit >>= 1
+		i++
+	}
+	return i
+}
@@ -0,0 +1,56 @@
+package model
+
+import "testing"
+
+func TestMinimizeTransfers(t *testing.T) {
+	tests := []struct {
+		name     string
+		balances map[int64]int64
+		want     int
+	}{
+		{
+			name:     "already settled",
+			balances: map[int64]int64{1: 0, 2: 0},
+			want:     0,
+		},
+		{
+			name:     "one debt",
+			balances: map[int64]int64{1: 500, 2: -500},
+			want:     1,
+		},
+		{
+			name:     "two independent pairs",
+			balances: map[int64]int64{1: 500, 2: 300, 3: -500, 4: -300},
+			want:     2,
+		},
+		{
+			// 大きい順に突き合わせると4件になるが､{1,3,4}と{2,5}に分ければ3件で済む
+			name:     "better than greedy matching",
+			balances: map[int64]int64{1: 600, 2: 400, 3: -300, 4: -300, 5: -400},
+			want:     3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MinimizeTransfers(tt.balances)
+			if len(got) != tt.want {
+				t.Errorf("MinimizeTransfers() = %v, want %d transfers", got, tt.want)
+			}
+
+			settled := NetBalances(got)
+			for userID, amount := range tt.balances {
+				if settled[userID] != amount {
+					t.Errorf("user %d receives %d, want %d (transfers %v)", userID, settled[userID], amount, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSettleBalancesIsWorseThanMinimizeTransfers(t *testing.T) {
+	balances := map[int64]int64{1: 600, 2: 400, 3: -300, 4: -300, 5: -400}
+	if greedy := SettleBalances(balances); len(greedy) != 4 {
+		t.Fatalf("SettleBalances() = %v, expected the greedy matching to need 4 transfers", greedy)
+	}
+}
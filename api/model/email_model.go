@@ -0,0 +1,144 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+)
+
+type EmailInterface interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	CreateEmailVerification(tx *sql.Tx, verification *EmailVerification) error
+	LockEmailVerification(tx *sql.Tx, tokenHash string) (*EmailVerification, error)
+	MarkEmailVerificationUsed(tx *sql.Tx, id int64) error
+	UpdateUserEmail(tx *sql.Tx, userID int64, email string) error
+}
+
+// EmailVerification はメールアドレスの追加や変更の確認待ち｡
+// 送ったトークンはハッシュだけを保存するので､DBが漏れても確認を済ませられない
+type EmailVerification struct {
+	ID        int64
+	UserID    int64
+	Email     string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+// NewEmailVerificationToken はメールで送るトークンと保存するハッシュを作る
+func NewEmailVerificationToken() (string, string, error) {
+	token, err := generateSessionID(32)
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashEmailVerificationToken(token), nil
+}
+
+func HashEmailVerificationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateEmailVerification は確認待ちを作る｡前に送った確認のリンクは使えなくする
+func (repo *Repository) CreateEmailVerification(tx *sql.Tx, verification *EmailVerification) error {
+	_, err := tx.Exec("DELETE FROM email_verifications WHERE user_id = $1 AND used_at IS NULL", verification.UserID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO
+			email_verifications (user_id, email, token_hash, expires_at, created_at)
+		VALUES
+			($1, $2, $3, $4, CURRENT_TIMESTAMP)
+		RETURNING id, created_at
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(
+		verification.UserID,
+		verification.Email,
+		verification.TokenHash,
+		verification.ExpiresAt,
+	).Scan(&verification.ID, &verification.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// LockEmailVerification はトークンのハッシュから確認待ちをロックして返す｡退会したユーザーのものは返さない
+func (repo *Repository) LockEmailVerification(tx *sql.Tx, tokenHash string) (*EmailVerification, error) {
+	query := `
+		SELECT
+			v.id, v.user_id, v.email, v.token_hash, v.expires_at, v.created_at, v.used_at
+		FROM
+			email_verifications v
+		JOIN
+			users u ON u.id = v.user_id
+		WHERE
+			v.token_hash = $1 AND u.deleted_at IS NULL
+		FOR UPDATE OF v
+	`
+
+	var verification EmailVerification
+	var usedAt sql.NullTime
+	err := tx.QueryRow(query, tokenHash).Scan(
+		&verification.ID,
+		&verification.UserID,
+		&verification.Email,
+		&verification.TokenHash,
+		&verification.ExpiresAt,
+		&verification.CreatedAt,
+		&usedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if usedAt.Valid {
+		verification.UsedAt = &usedAt.Time
+	}
+
+	return &verification, nil
+}
+
+func (repo *Repository) MarkEmailVerificationUsed(tx *sql.Tx, id int64) error {
+	_, err := tx.Exec("UPDATE email_verifications SET used_at = CURRENT_TIMESTAMP WHERE id = $1", id)
+	return err
+}
+
+// UpdateUserEmail は確認できたメールアドレスにする
+func (repo *Repository) UpdateUserEmail(tx *sql.Tx, userID int64, email string) error {
+	query := `
+		UPDATE
+			users
+		SET
+			email = $1, email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE
+			id = $2
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(email, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
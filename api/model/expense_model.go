@@ -0,0 +1,250 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"time"
+)
+
+type ExpenseInterface interface {
+	GetGroup(groupID int64) (*Group, error)
+	IsGroupMember(groupID, userID int64) (bool, error)
+	GetMemberRole(groupID, userID int64) (authz.Role, bool, error)
+	GetMedia(id string) (*Media, error)
+	ListExpenses(groupID int64) ([]Expense, error)
+	GetExpense(groupID, expenseID int64) (*Expense, error)
+	CreateExpense(tx *sql.Tx, expense *Expense) error
+	UpdateExpense(tx *sql.Tx, expense *Expense) error
+	DeleteExpense(tx *sql.Tx, groupID, expenseID int64) (bool, error)
+	AppendAuditEvent(tx *sql.Tx, event *AuditEvent) error
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+type Expense struct {
+	ID      int64 `json:"id"`
+	GroupID int64 `json:"group_id"`
+	// PaidBy は立て替えたメンバー
+	PaidBy          int64     `json:"paid_by"`
+	Amount          int64     `json:"amount"`
+	Description     string    `json:"description"`
+	ReceiptImageURL string    `json:"receipt_image_url"`
+	CreatedBy       int64     `json:"created_by"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+func (repo *Repository) ListExpenses(groupID int64) ([]Expense, error) {
+	query := `
+		SELECT
+			id, group_id, paid_by, amount, description, receipt_image_url, created_by, created_at, updated_at
+		FROM
+			expenses
+		WHERE
+			group_id = $1
+		ORDER BY
+			created_at, id
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	expenses := []Expense{}
+	for rows.Next() {
+		expense, err := scanExpense(rows)
+		if err != nil {
+			return nil, err
+		}
+		expenses = append(expenses, *expense)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return expenses, nil
+}
+
+func (repo *Repository) GetExpense(groupID, expenseID int64) (*Expense, error) {
+	query := `
+		SELECT
+			id, group_id, paid_by, amount, description, receipt_image_url, created_by, created_at, updated_at
+		FROM
+			expenses
+		WHERE
+			group_id = $1 AND id = $2
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	return scanExpense(stmt.QueryRow(groupID, expenseID))
+}
+
+func (repo *Repository) CreateExpense(tx *sql.Tx, expense *Expense) error {
+	query := `
+		INSERT INTO
+			expenses (group_id, paid_by, amount, description, receipt_image_url, created_by, created_at, updated_at)
+		VALUES
+			($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id, created_at, updated_at
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(
+		expense.GroupID,
+		expense.PaidBy,
+		expense.Amount,
+		expense.Description,
+		nullString(expense.ReceiptImageURL),
+		expense.CreatedBy,
+	).Scan(&expense.ID, &expense.CreatedAt, &expense.UpdatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) UpdateExpense(tx *sql.Tx, expense *Expense) error {
+	query := `
+		UPDATE
+			expenses
+		SET
+			paid_by = $1, amount = $2, description = $3, receipt_image_url = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE
+			group_id = $5 AND id = $6
+		RETURNING updated_at
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(
+		expense.PaidBy,
+		expense.Amount,
+		expense.Description,
+		nullString(expense.ReceiptImageURL),
+		expense.GroupID,
+		expense.ID,
+	).Scan(&expense.UpdatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) DeleteExpense(tx *sql.Tx, groupID, expenseID int64) (bool, error) {
+	query := `
+		DELETE FROM
+			expenses
+		WHERE
+			group_id = $1 AND id = $2
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(groupID, expenseID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// ListExpenseTotals は立て替えたメンバーごとの合計額を返す
+func (repo *Repository) ListExpenseTotals(groupID int64) (map[int64]int64, error) {
+	query := `
+		SELECT
+			paid_by, SUM(amount)
+		FROM
+			expenses
+		WHERE
+			group_id = $1
+		GROUP BY
+			paid_by
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := map[int64]int64{}
+	for rows.Next() {
+		var userID, total int64
+		if err := rows.Scan(&userID, &total); err != nil {
+			return nil, err
+		}
+		totals[userID] = total
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return totals, nil
+}
+
+func scanExpense(row rowScanner) (*Expense, error) {
+	var expense Expense
+	var receiptImageURL sql.NullString
+	err := row.Scan(
+		&expense.ID,
+		&expense.GroupID,
+		&expense.PaidBy,
+		&expense.Amount,
+		&expense.Description,
+		&receiptImageURL,
+		&expense.CreatedBy,
+		&expense.CreatedAt,
+		&expense.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	expense.ReceiptImageURL = receiptImageURL.String
+
+	return &expense, nil
+}
@@ -0,0 +1,168 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// GroupEventChannel は記録したイベントの ID を流す NOTIFY のチャネル
+const GroupEventChannel = "group_events"
+
+// groupEventLockClass はイベントを書き込む間のグループごとのアドバイザリロックの1つ目のキー｡2つ目はグループの ID
+const groupEventLockClass int32 = 40_000_002
+
+type GroupEventInterface interface {
+	AppendGroupEvent(tx *sql.Tx, event *GroupEvent) error
+	GetGroupEvent(id int64) (*GroupEvent, error)
+	ListGroupEventsAfter(groupID, afterID int64, limit int) ([]GroupEvent, error)
+	GetLatestGroupEventID(groupID int64) (int64, error)
+}
+
+// GroupEvent は group_events に残すリアルタイムイベント
+type GroupEvent struct {
+	ID        int64
+	GroupID   int64
+	Type      string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
+
+// AppendGroupEvent はグループの変更と同じトランザクションでイベントを記録し､ID と作成日時を埋める｡
+// ID を pg_notify で流すので､コミットされたときだけ各レプリカに届く｡
+// BIGSERIAL の ID は INSERT のときに決まるので､そのままでは後の ID が先にコミットされることがある｡
+// 読む側はグループごとに最後に受け取った ID より後だけを読むので､コミットまでグループのロックを持って
+// グループの中で ID の順とコミットの順をそろえる｡ほかのグループの書き込みは待たせない
+func (repo *Repository) AppendGroupEvent(tx *sql.Tx, event *GroupEvent) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock($1, ($2::bigint % 2147483647)::int4)`, groupEventLockClass, event.GroupID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO
+			group_events (group_id, type, payload, created_at)
+		VALUES
+			($1, $2, $3, CURRENT_TIMESTAMP)
+		RETURNING id, created_at
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var payload any
+	if len(event.Payload) > 0 {
+		payload = string(event.Payload)
+	}
+
+	err = stmt.QueryRow(event.GroupID, event.Type, payload).Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	// ペイロードには 8000 バイトの上限があるので ID だけを流し､受け取った側で読み直す
+	_, err = tx.Exec(`SELECT pg_notify($1, $2)`, GroupEventChannel, strconv.FormatInt(event.ID, 10))
+	return err
+}
+
+func (repo *Repository) GetGroupEvent(id int64) (*GroupEvent, error) {
+	query := `
+		SELECT
+			id, group_id, type, payload, created_at
+		FROM
+			group_events
+		WHERE
+			id = $1
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	return scanGroupEvent(stmt.QueryRow(id))
+}
+
+// ListGroupEventsAfter は afterID より後のイベントを古い順に最大 limit 件返す
+func (repo *Repository) ListGroupEventsAfter(groupID, afterID int64, limit int) ([]GroupEvent, error) {
+	query := `
+		SELECT
+			id, group_id, type, payload, created_at
+		FROM
+			group_events
+		WHERE
+			group_id = $1 AND id > $2
+		ORDER BY
+			id
+		LIMIT $3
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(groupID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanGroupEvents(rows)
+}
+
+// GetLatestGroupEventID はグループのコミット済みの一番新しいイベントの ID を返す｡まだなければ 0
+func (repo *Repository) GetLatestGroupEventID(groupID int64) (int64, error) {
+	var id int64
+	err := repo.db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM group_events WHERE group_id = $1`, groupID).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func scanGroupEvents(rows *sql.Rows) ([]GroupEvent, error) {
+	events := []GroupEvent{}
+	for rows.Next() {
+		event, err := scanGroupEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, *event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func scanGroupEvent(row rowScanner) (*GroupEvent, error) {
+	var event GroupEvent
+	var payload []byte
+	err := row.Scan(
+		&event.ID,
+		&event.GroupID,
+		&event.Type,
+		&payload,
+		&event.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if payload != nil {
+		event.Payload = payload
+	}
+
+	return &event, nil
+}
@@ -3,13 +3,37 @@ package model
 import (
 	"context"
 	"database/sql"
+	"domeal/authz"
+	"time"
 )
 
 type GroupInterface interface {
 	CreateGroup(tx *sql.Tx, group *Group) (int64, error)
-	AddGroupMember(tx *sql.Tx, groupID, userID int64, isOwner bool) error
+	AddGroupMember(tx *sql.Tx, groupID, userID int64, role authz.Role) error
 	GetGroup(groupID int64) (*Group, error)
 	IsGroupMember(groupID, userID int64) (bool, error)
+	GetMemberRole(groupID, userID int64) (authz.Role, bool, error)
+	IsWaitlisted(tx *sql.Tx, groupID, userID int64) (bool, error)
+	LockGroup(tx *sql.Tx, groupID int64) (*Group, error)
+	GetGroupMemberID(tx *sql.Tx, groupID, userID int64) (int64, error)
+	CountGroupMembers(tx *sql.Tx, groupID int64) (int64, error)
+	RemoveGroupMember(tx *sql.Tx, groupID, userID int64) (bool, error)
+	AddWaitlistEntry(tx *sql.Tx, groupID, userID int64) error
+	RemoveWaitlistEntry(tx *sql.Tx, groupID, userID int64) (bool, error)
+	PopWaitlist(tx *sql.Tx, groupID int64) (int64, bool, error)
+	UpdateGroup(tx *sql.Tx, group *Group) error
+	UpdateMemberRole(tx *sql.Tx, groupID, userID int64, role authz.Role) (bool, error)
+	TransferGroupOwnership(tx *sql.Tx, groupID, fromUserID, toUserID int64) (bool, error)
+	SoftDeleteGroup(tx *sql.Tx, groupID int64) error
+	ListGroupMemberIDs(tx *sql.Tx, groupID int64) ([]int64, error)
+	ListWaitlistUserIDs(tx *sql.Tx, groupID int64) ([]int64, error)
+	CreateNotification(tx *sql.Tx, notification *Notification) error
+	CreateMenuItem(tx *sql.Tx, item *MenuItem) error
+	CreateMedia(tx *sql.Tx, media *Media) error
+	UpdateGroupMenuImage(tx *sql.Tx, groupID int64, menuImageURL string) error
+	GetSettlement(groupID int64, method SplitMethod) (*Settlement, error)
+	ListGroupPayPalMeUsernames(groupID int64) (map[int64]string, error)
+	AppendAuditEvent(tx *sql.Tx, event *AuditEvent) error
 	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
 }
 
@@ -19,14 +43,27 @@ type Group struct {
 	Menu         string `json:"menu"`
 	MenuImageURL string `json:"menu_image_url"`
 	CreatedBy    int64  `json:"created_by"`
+	// MaxMembers はオーナーを含む定員｡nilなら無制限
+	MaxMembers  *int64      `json:"max_members"`
+	Status      string      `json:"status"`
+	SplitMethod SplitMethod `json:"split_method"`
+	// Deadline を過ぎると自動で締め切る｡nilなら締め切らない
+	Deadline *time.Time `json:"deadline"`
 }
 
+const (
+	// GroupStatusOpen は参加や注文を受け付けている状態
+	GroupStatusOpen = "open"
+	// GroupStatusClosed は締め切られて注文の変更ができない状態
+	GroupStatusClosed = "closed"
+)
+
 func (repo *Repository) CreateGroup(tx *sql.Tx, group *Group) (int64, error) {
 	query := `
 		INSERT INTO
-			groups (name, menu, menu_image_url, created_by, created_at)
+			groups (name, menu, menu_image_url, created_by, max_members, deadline, created_at)
 		VALUES
-			($1, $2, $3, $4, CURRENT_TIMESTAMP)
+			($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
 		RETURNING id
 	`
 
@@ -42,6 +79,8 @@ func (repo *Repository) CreateGroup(tx *sql.Tx, group *Group) (int64, error) {
 		group.Menu,
 		group.MenuImageURL,
 		group.CreatedBy,
+		group.MaxMembers,
+		group.Deadline,
 	).Scan(&groupID)
 
 	if err != nil {
@@ -51,9 +90,9 @@ func (repo *Repository) CreateGroup(tx *sql.Tx, group *Group) (int64, error) {
 	return groupID, nil
 }
 
-func (repo *Repository) AddGroupMember(tx *sql.Tx, groupID, userID int64, isOwner bool) error {
+func (repo *Repository) AddGroupMember(tx *sql.Tx, groupID, userID int64, role authz.Role) error {
 	query := `
-		INSERT INTO group_members (group_id, user_id, is_owner, joined_at)
+		INSERT INTO group_members (group_id, user_id, role, joined_at)
 		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
 	`
 
@@ -63,7 +102,7 @@ func (repo *Repository) AddGroupMember(tx *sql.Tx, groupID, userID int64, isOwne
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(groupID, userID, isOwner)
+	_, err = stmt.Exec(groupID, userID, role)
 	if err != nil {
 		return err
 	}
@@ -74,11 +113,11 @@ func (repo *Repository) AddGroupMember(tx *sql.Tx, groupID, userID int64, isOwne
 func (repo *Repository) GetGroup(groupID int64) (*Group, error) {
 	query := `
 		SELECT
-			id, name, menu, menu_image_url, created_by
+			id, name, menu, menu_image_url, created_by, max_members, status, split_method, deadline
 		FROM
 			groups
 		WHERE
-			id = $1
+			id = $1 AND deleted_at IS NULL
 	`
 
 	stmt, err := repo.db.Prepare(query)
@@ -87,14 +126,46 @@ func (repo *Repository) GetGroup(groupID int64) (*Group, error) {
 	}
 	defer stmt.Close()
 
+	return scanGroup(stmt.QueryRow(groupID))
+}
+
+// LockGroup はグループの行を FOR UPDATE でロックして取得する｡
+// 定員チェックと参加・脱退を同じグループに対して直列化するために使う
+func (repo *Repository) LockGroup(tx *sql.Tx, groupID int64) (*Group, error) {
+	query := `
+		SELECT
+			id, name, menu, menu_image_url, created_by, max_members, status, split_method, deadline
+		FROM
+			groups
+		WHERE
+			id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	return scanGroup(stmt.QueryRow(groupID))
+}
+
+func scanGroup(row rowScanner) (*Group, error) {
 	var group Group
 	var menuImageURL sql.NullString
-	err = stmt.QueryRow(groupID).Scan(
+	var maxMembers sql.NullInt64
+	var deadline sql.NullTime
+	err := row.Scan(
 		&group.ID,
 		&group.Name,
 		&group.Menu,
 		&menuImageURL,
 		&group.CreatedBy,
+		&maxMembers,
+		&group.Status,
+		&group.SplitMethod,
+		&deadline,
 	)
 
 	if err != nil {
@@ -105,6 +176,14 @@ func (repo *Repository) GetGroup(groupID int64) (*Group, error) {
 		group.MenuImageURL = menuImageURL.String
 	}
 
+	if maxMembers.Valid {
+		group.MaxMembers = &maxMembers.Int64
+	}
+
+	if deadline.Valid {
+		group.Deadline = &deadline.Time
+	}
+
 	return &group, nil
 }
 
@@ -131,3 +210,351 @@ func (repo *Repository) IsGroupMember(groupID, userID int64) (bool, error) {
 
 	return count > 0, nil
 }
+
+// GetMemberRole はグループでのユーザーの役割を返す｡メンバーでないか､グループが削除されていれば ok=false｡
+// 画像やイベントの配信は GetGroup を通さずにこれだけで確かめるので､ここで削除済みを除く
+func (repo *Repository) GetMemberRole(groupID, userID int64) (authz.Role, bool, error) {
+	query := `
+		SELECT
+			gm.role
+		FROM
+			group_members gm
+		JOIN
+			groups g ON g.id = gm.group_id
+		WHERE
+			gm.group_id = $1 AND gm.user_id = $2 AND g.deleted_at IS NULL
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return "", false, err
+	}
+	defer stmt.Close()
+
+	var role authz.Role
+	err = stmt.QueryRow(groupID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return role, true, nil
+}
+
+func (repo *Repository) IsWaitlisted(tx *sql.Tx, groupID, userID int64) (bool, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM
+			group_waitlist
+		WHERE
+			group_id = $1 AND user_id = $2
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	var count int
+	err = stmt.QueryRow(groupID, userID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (repo *Repository) CountGroupMembers(tx *sql.Tx, groupID int64) (int64, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM
+			group_members
+		WHERE
+			group_id = $1
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var count int64
+	err = stmt.QueryRow(groupID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (repo *Repository) RemoveGroupMember(tx *sql.Tx, groupID, userID int64) (bool, error) {
+	query := `
+		DELETE FROM
+			group_members
+		WHERE
+			group_id = $1 AND user_id = $2
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(groupID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (repo *Repository) AddWaitlistEntry(tx *sql.Tx, groupID, userID int64) error {
+	query := `
+		INSERT INTO group_waitlist (group_id, user_id, created_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP)
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(groupID, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) RemoveWaitlistEntry(tx *sql.Tx, groupID, userID int64) (bool, error) {
+	query := `
+		DELETE FROM
+			group_waitlist
+		WHERE
+			group_id = $1 AND user_id = $2
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(groupID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// PopWaitlist は待ちリストの先頭のユーザーを取り出して削除する｡
+// 待ちリストが空の場合は ok=false を返す
+func (repo *Repository) PopWaitlist(tx *sql.Tx, groupID int64) (int64, bool, error) {
+	query := `
+		DELETE FROM
+			group_waitlist
+		WHERE
+			id = (
+				SELECT id
+				FROM
+					group_waitlist
+				WHERE
+					group_id = $1
+				ORDER BY
+					created_at, id
+				LIMIT 1
+				FOR UPDATE
+			)
+		RETURNING user_id
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return 0, false, err
+	}
+	defer stmt.Close()
+
+	var userID int64
+	err = stmt.QueryRow(groupID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return userID, true, nil
+}
+
+// UpdateGroup はグループを更新する｡締め切りが変わったらリマインドを送り直せるようにする
+func (repo *Repository) UpdateGroup(tx *sql.Tx, group *Group) error {
+	query := `
+		UPDATE
+			groups
+		SET
+			name = $1, menu = $2, max_members = $3, status = $4, split_method = $5, deadline = $7,
+			deadline_reminded_at = CASE
+				WHEN deadline IS DISTINCT FROM $7 THEN NULL
+				ELSE deadline_reminded_at
+			END
+		WHERE
+			id = $6 AND deleted_at IS NULL
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(group.Name, group.Menu, group.MaxMembers, group.Status, group.SplitMethod, group.ID, group.Deadline)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateMemberRole はメンバーの役割を変える｡メンバーでなければ false を返す｡
+// オーナーにするときは TransferGroupOwnership を使う
+func (repo *Repository) UpdateMemberRole(tx *sql.Tx, groupID, userID int64, role authz.Role) (bool, error) {
+	query := `
+		UPDATE
+			group_members
+		SET
+			role = $3
+		WHERE
+			group_id = $1 AND user_id = $2
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(groupID, userID, role)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// TransferGroupOwnership はオーナーを譲る｡前のオーナーは幹事として残す｡
+// オーナーは1人だけという一意制約に触れないように､先に前のオーナーの役割を変える｡
+// 譲る相手がメンバーでなければ false を返すので､呼び出し側でロールバックすること
+func (repo *Repository) TransferGroupOwnership(tx *sql.Tx, groupID, fromUserID, toUserID int64) (bool, error) {
+	if _, err := repo.UpdateMemberRole(tx, groupID, fromUserID, authz.RoleOrganiser); err != nil {
+		return false, err
+	}
+
+	return repo.UpdateMemberRole(tx, groupID, toUserID, authz.RoleOwner)
+}
+
+// SoftDeleteGroup はグループを論理削除する｡メンバーや待ちリストの行は履歴として残す
+func (repo *Repository) SoftDeleteGroup(tx *sql.Tx, groupID int64) error {
+	query := `
+		UPDATE
+			groups
+		SET
+			deleted_at = CURRENT_TIMESTAMP
+		WHERE
+			id = $1 AND deleted_at IS NULL
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(groupID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListGroupMemberIDs(tx *sql.Tx, groupID int64) ([]int64, error) {
+	query := `
+		SELECT
+			user_id
+		FROM
+			group_members
+		WHERE
+			group_id = $1
+		ORDER BY
+			joined_at, id
+	`
+
+	return queryUserIDs(tx, query, groupID)
+}
+
+func (repo *Repository) ListWaitlistUserIDs(tx *sql.Tx, groupID int64) ([]int64, error) {
+	query := `
+		SELECT
+			user_id
+		FROM
+			group_waitlist
+		WHERE
+			group_id = $1
+		ORDER BY
+			created_at, id
+	`
+
+	return queryUserIDs(tx, query, groupID)
+}
+
+func queryUserIDs(tx *sql.Tx, query string, args ...any) ([]int64, error) {
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return userIDs, nil
+}
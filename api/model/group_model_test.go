@@ -0,0 +1,92 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// openTestDB は TEST_DATABASE_URL のデータベースにつなぐ｡マイグレーション済みであること｡
+// 設定がなければテストを飛ばす
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestGetMemberRoleDeletedGroup(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewRepository(db)
+
+	tx, err := repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	userID, err := repo.SaveUserInfo(tx, &LineProfile{
+		Sub:  fmt.Sprintf("test-deleted-group-%d", time.Now().UnixNano()),
+		Name: "テスト",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupID, err := repo.CreateGroup(tx, &Group{Name: "削除するグループ", Menu: "カレー", CreatedBy: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AddGroupMember(tx, groupID, userID, authz.RoleOwner); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM group_members WHERE group_id = $1`, groupID)
+		db.Exec(`DELETE FROM groups WHERE id = $1`, groupID)
+		db.Exec(`DELETE FROM users WHERE id = $1`, userID)
+	})
+
+	if role, ok, err := repo.GetMemberRole(groupID, userID); err != nil || !ok || role != authz.RoleOwner {
+		t.Fatalf("GetMemberRole before delete = %q, %v, %v", role, ok, err)
+	}
+
+	tx, err = repo.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := repo.SoftDeleteGroup(tx, groupID); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 削除したグループのメンバーは画像もイベントも見られない
+	if _, ok, err := repo.GetMemberRole(groupID, userID); err != nil || ok {
+		t.Errorf("GetMemberRole after delete ok = %v, %v, want false", ok, err)
+	}
+	if ok, err := authz.Can(repo, groupID, userID, authz.ViewGroup); err != nil || ok {
+		t.Errorf("Can(ViewGroup) after delete = %v, %v, want false", ok, err)
+	}
+}
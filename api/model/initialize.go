@@ -7,19 +7,23 @@ import (
 	_ "github.com/lib/pq" // PostgreSQL driver
 )
 
-func InitDB() (*sql.DB, error) {
-	var conn *sql.DB
+// DSN は接続文字列を返す｡LISTEN 用の専用接続でも同じものを使う
+func DSN() string {
 	user := "postgres"
 	pass := "postgres"
 	host := "db"
 	port := "5432"
 	name := "stg"
 
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, pass, name)
+}
+
+func InitDB() (*sql.DB, error) {
+	var conn *sql.DB
 
 	var err error
-	conn, err = sql.Open("postgres", dsn)
+	conn, err = sql.Open("postgres", DSN())
 	if err != nil {
 		return nil, fmt.Errorf("failed to open DB: %w", err)
 	}
@@ -0,0 +1,168 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Job はジョブキューの1件
+type Job struct {
+	ID      int64
+	Kind    string
+	Payload json.RawMessage
+	// UniqueKey が空でなければ､同じ種類で同じキーの未実行のジョブは1つにまとめる
+	UniqueKey string
+	// RunAt がゼロなら今すぐ実行する
+	RunAt time.Time
+	// Attempts はこれまでに失敗した回数
+	Attempts    int
+	MaxAttempts int
+	CreatedAt   time.Time
+}
+
+// EnqueueJob は呼び出し側のトランザクションでジョブを積む｡コミットされるまでワーカーには見えない｡
+// 同じ種類とキーの未実行のジョブがあれば､ペイロードと実行時刻を上書きする
+func (repo *Repository) EnqueueJob(tx *sql.Tx, job *Job) error {
+	query := `
+		INSERT INTO
+			jobs (kind, payload, unique_key, run_at, max_attempts, created_at, updated_at)
+		VALUES
+			($1, $2, $3, COALESCE($4, CURRENT_TIMESTAMP), $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (kind, unique_key) WHERE status = 'pending' DO UPDATE
+		SET
+			payload = EXCLUDED.payload,
+			run_at = EXCLUDED.run_at,
+			max_attempts = EXCLUDED.max_attempts,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING id, run_at, created_at
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var runAt sql.NullTime
+	if !job.RunAt.IsZero() {
+		runAt = sql.NullTime{Time: job.RunAt, Valid: true}
+	}
+
+	err = stmt.QueryRow(
+		job.Kind,
+		string(job.Payload),
+		nullString(job.UniqueKey),
+		runAt,
+		job.MaxAttempts,
+	).Scan(&job.ID, &job.RunAt, &job.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ClaimJob は実行時刻になったジョブを1件取り出し､完了扱いにしてロックしたまま返す｡
+// ほかのワーカーはロックされた行を飛ばすので同じジョブを重ねて実行しない｡
+// 失敗したときは同じトランザクションで RetryJob か DeadLetterJob を呼ぶこと｡
+// 取り出せるジョブがなければ sql.ErrNoRows を返す
+func (repo *Repository) ClaimJob(tx *sql.Tx) (*Job, error) {
+	query := `
+		UPDATE
+			jobs
+		SET
+			status = 'done', finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE
+			id = (
+				SELECT id
+				FROM jobs
+				WHERE status = 'pending' AND run_at <= CURRENT_TIMESTAMP
+				ORDER BY run_at, id
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+		RETURNING id, kind, payload, unique_key, run_at, attempts, max_attempts, created_at
+	`
+
+	var job Job
+	var payload []byte
+	var uniqueKey sql.NullString
+	err := tx.QueryRow(query).Scan(
+		&job.ID,
+		&job.Kind,
+		&payload,
+		&uniqueKey,
+		&job.RunAt,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Payload = payload
+	if uniqueKey.Valid {
+		job.UniqueKey = uniqueKey.String
+	}
+
+	return &job, nil
+}
+
+// RetryJob は失敗したジョブを runAt にもう一度実行するように戻す
+func (repo *Repository) RetryJob(tx *sql.Tx, id int64, attempts int, runAt time.Time, lastError string) error {
+	query := `
+		UPDATE
+			jobs
+		SET
+			status = 'pending', attempts = $2, run_at = $3, last_error = $4,
+			finished_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE
+			id = $1
+	`
+
+	_, err := tx.Exec(query, id, attempts, runAt, lastError)
+	return err
+}
+
+// DeleteFinishedJobs は before より前に完了したジョブを古い順に limit 件まで消して､消した数を返す｡
+// dead のジョブは調べるために残しておく
+func (repo *Repository) DeleteFinishedJobs(before time.Time, limit int) (int64, error) {
+	query := `
+		DELETE FROM
+			jobs
+		WHERE
+			id IN (
+				SELECT id
+				FROM jobs
+				WHERE status = 'done' AND finished_at < $1
+				ORDER BY finished_at, id
+				LIMIT $2
+			)
+	`
+
+	result, err := repo.db.Exec(query, before, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeadLetterJob は再試行をあきらめたジョブを dead にして残す
+func (repo *Repository) DeadLetterJob(tx *sql.Tx, id int64, attempts int, lastError string) error {
+	query := `
+		UPDATE
+			jobs
+		SET
+			status = 'dead', attempts = $2, last_error = $3,
+			finished_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE
+			id = $1
+	`
+
+	_, err := tx.Exec(query, id, attempts, lastError)
+	return err
+}
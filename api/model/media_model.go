@@ -0,0 +1,134 @@
+package model
+
+import (
+	"database/sql"
+	"domeal/authz"
+	"time"
+)
+
+type MediaInterface interface {
+	GetMedia(id string) (*Media, error)
+	GetGroup(groupID int64) (*Group, error)
+	GetMemberRole(groupID, userID int64) (authz.Role, bool, error)
+}
+
+// Media はグループのメンバーだけに配信する画像
+type Media struct {
+	ID          string
+	GroupID     int64
+	ObjectKey   string
+	ContentType string
+	CreatedAt   time.Time
+}
+
+func (repo *Repository) CreateMedia(tx *sql.Tx, media *Media) error {
+	query := `
+		INSERT INTO
+			media (group_id, object_key, content_type, created_at)
+		VALUES
+			($1, $2, $3, CURRENT_TIMESTAMP)
+		RETURNING id, created_at
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(
+		media.GroupID,
+		media.ObjectKey,
+		media.ContentType,
+	).Scan(&media.ID, &media.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) GetMedia(id string) (*Media, error) {
+	query := `
+		SELECT
+			id, group_id, object_key, content_type, created_at
+		FROM
+			media
+		WHERE
+			id = $1
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var media Media
+	err = stmt.QueryRow(id).Scan(
+		&media.ID,
+		&media.GroupID,
+		&media.ObjectKey,
+		&media.ContentType,
+		&media.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &media, nil
+}
+
+// ListOrphanedMedia は before より前に登録され､グループの料理の画像にも料理ごとの画像にもレシートにも
+// 使われていない画像を古い順にロックして返す｡ほかのレプリカがロックしている行は飛ばす
+func (repo *Repository) ListOrphanedMedia(tx *sql.Tx, before time.Time, limit int) ([]Media, error) {
+	query := `
+		SELECT
+			m.id, m.group_id, m.object_key, m.content_type, m.created_at
+		FROM
+			media m
+		WHERE
+			m.created_at < $1
+			AND NOT EXISTS (SELECT 1 FROM groups g WHERE g.menu_image_url = '/api/media/' || m.id)
+			AND NOT EXISTS (SELECT 1 FROM menu_items i WHERE i.group_id = m.group_id AND i.image_url = '/api/media/' || m.id)
+			AND NOT EXISTS (SELECT 1 FROM expenses e WHERE e.group_id = m.group_id AND e.receipt_image_url = '/api/media/' || m.id)
+		ORDER BY
+			m.created_at
+		LIMIT $2
+		FOR UPDATE OF m SKIP LOCKED
+	`
+
+	rows, err := tx.Query(query, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var media []Media
+	for rows.Next() {
+		var image Media
+		err := rows.Scan(
+			&image.ID,
+			&image.GroupID,
+			&image.ObjectKey,
+			&image.ContentType,
+			&image.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		media = append(media, image)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return media, nil
+}
+
+func (repo *Repository) DeleteMedia(tx *sql.Tx, id string) error {
+	_, err := tx.Exec("DELETE FROM media WHERE id = $1", id)
+	return err
+}
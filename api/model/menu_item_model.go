@@ -0,0 +1,275 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"time"
+)
+
+type MenuItemInterface interface {
+	GetGroup(groupID int64) (*Group, error)
+	GetMemberRole(groupID, userID int64) (authz.Role, bool, error)
+	GetMedia(id string) (*Media, error)
+	ListMenuItems(groupID int64) ([]MenuItem, error)
+	GetMenuItem(groupID, itemID int64) (*MenuItem, error)
+	CreateMenuItem(tx *sql.Tx, item *MenuItem) error
+	UpdateMenuItem(tx *sql.Tx, item *MenuItem) error
+	DeleteMenuItem(tx *sql.Tx, groupID, itemID int64) (bool, error)
+	LockGroup(tx *sql.Tx, groupID int64) (*Group, error)
+	ListMenuItemOrderers(tx *sql.Tx, groupID, itemID int64) ([]MenuItemOrderer, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+type MenuItem struct {
+	ID      int64  `json:"id"`
+	GroupID int64  `json:"group_id"`
+	Name    string `json:"name"`
+	// Price は1単位あたりの価格(円)
+	Price     int64     `json:"price"`
+	Quantity  int64     `json:"quantity"`
+	Unit      string    `json:"unit"`
+	ImageURL  string    `json:"image_url"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (repo *Repository) ListMenuItems(groupID int64) ([]MenuItem, error) {
+	query := `
+		SELECT
+			id, group_id, name, price, quantity, unit, image_url, created_at, updated_at
+		FROM
+			menu_items
+		WHERE
+			group_id = $1
+		ORDER BY
+			id
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []MenuItem{}
+	for rows.Next() {
+		item, err := scanMenuItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (repo *Repository) GetMenuItem(groupID, itemID int64) (*MenuItem, error) {
+	query := `
+		SELECT
+			id, group_id, name, price, quantity, unit, image_url, created_at, updated_at
+		FROM
+			menu_items
+		WHERE
+			group_id = $1 AND id = $2
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	return scanMenuItem(stmt.QueryRow(groupID, itemID))
+}
+
+func (repo *Repository) CreateMenuItem(tx *sql.Tx, item *MenuItem) error {
+	query := `
+		INSERT INTO
+			menu_items (group_id, name, price, quantity, unit, image_url, created_at, updated_at)
+		VALUES
+			($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id, created_at, updated_at
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(
+		item.GroupID,
+		item.Name,
+		item.Price,
+		item.Quantity,
+		item.Unit,
+		nullString(item.ImageURL),
+	).Scan(&item.ID, &item.CreatedAt, &item.UpdatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) UpdateMenuItem(tx *sql.Tx, item *MenuItem) error {
+	query := `
+		UPDATE
+			menu_items
+		SET
+			name = $1, price = $2, quantity = $3, unit = $4, image_url = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE
+			group_id = $6 AND id = $7
+		RETURNING updated_at
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(
+		item.Name,
+		item.Price,
+		item.Quantity,
+		item.Unit,
+		nullString(item.ImageURL),
+		item.GroupID,
+		item.ID,
+	).Scan(&item.UpdatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) DeleteMenuItem(tx *sql.Tx, groupID, itemID int64) (bool, error) {
+	query := `
+		DELETE FROM
+			menu_items
+		WHERE
+			group_id = $1 AND id = $2
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(groupID, itemID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// rowScanner は *sql.Row と *sql.Rows の共通部分
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMenuItem(row rowScanner) (*MenuItem, error) {
+	var item MenuItem
+	var imageURL sql.NullString
+	err := row.Scan(
+		&item.ID,
+		&item.GroupID,
+		&item.Name,
+		&item.Price,
+		&item.Quantity,
+		&item.Unit,
+		&imageURL,
+		&item.CreatedAt,
+		&item.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if imageURL.Valid {
+		item.ImageURL = imageURL.String
+	}
+
+	return &item, nil
+}
+
+// nullString は空文字をNULLとして保存するために使う
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// MenuItemOrderer は料理を注文しているメンバー｡Quantity はその料理を除いた注文の数量
+type MenuItemOrderer struct {
+	UserID   int64
+	Quantity int64
+}
+
+// ListMenuItemOrderers は料理を注文しているメンバーを返す｡
+// 料理を消すと注文も消えるので､消す前に呼んで誰の注文が変わるかを知るのに使う
+func (repo *Repository) ListMenuItemOrderers(tx *sql.Tx, groupID, itemID int64) ([]MenuItemOrderer, error) {
+	query := `
+		SELECT
+			gm.user_id, COALESCE(SUM(mo.quantity) FILTER (WHERE mo.menu_item_id <> $2), 0)
+		FROM
+			group_members gm
+		JOIN
+			member_orders mo ON mo.group_member_id = gm.id
+		WHERE
+			gm.group_id = $1
+			AND gm.id IN (SELECT group_member_id FROM member_orders WHERE menu_item_id = $2)
+		GROUP BY
+			gm.user_id
+		ORDER BY
+			gm.user_id
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(groupID, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orderers []MenuItemOrderer
+	for rows.Next() {
+		var orderer MenuItemOrderer
+		if err := rows.Scan(&orderer.UserID, &orderer.Quantity); err != nil {
+			return nil, err
+		}
+		orderers = append(orderers, orderer)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orderers, nil
+}
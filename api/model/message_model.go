@@ -0,0 +1,307 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"time"
+)
+
+type MessageInterface interface {
+	GetGroup(groupID int64) (*Group, error)
+	GetMemberRole(groupID, userID int64) (authz.Role, bool, error)
+	ListMessages(groupID, beforeID int64, limit int) ([]Message, error)
+	GetMessage(groupID, messageID int64) (*Message, error)
+	CreateMessage(tx *sql.Tx, message *Message) error
+	UpdateMessage(tx *sql.Tx, message *Message) error
+	DeleteMessage(tx *sql.Tx, groupID, messageID int64) (bool, error)
+	MarkMessagesRead(tx *sql.Tx, groupID, userID, messageID int64) (*MessageRead, error)
+	ListMessageReads(groupID int64) ([]MessageRead, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+type Message struct {
+	ID          int64      `json:"id"`
+	GroupID     int64      `json:"group_id"`
+	UserID      int64      `json:"user_id"`
+	DisplayName string     `json:"display_name"`
+	Body        string     `json:"body"`
+	CreatedAt   time.Time  `json:"created_at"`
+	EditedAt    *time.Time `json:"edited_at"`
+	// Deleted のメッセージは本文を返さない
+	Deleted bool `json:"deleted"`
+}
+
+// MessageRead はメンバーがどこまで読んだか
+type MessageRead struct {
+	UserID            int64     `json:"user_id"`
+	DisplayName       string    `json:"display_name"`
+	LastReadMessageID int64     `json:"last_read_message_id"`
+	ReadAt            time.Time `json:"read_at"`
+}
+
+// ListMessages は beforeID より前のメッセージを新しい順に最大 limit 件返す｡beforeID が0なら最新から
+func (repo *Repository) ListMessages(groupID, beforeID int64, limit int) ([]Message, error) {
+	query := `
+		SELECT
+			gm.id, gm.group_id, gm.user_id, u.display_name, gm.body, gm.created_at, gm.edited_at, gm.deleted_at
+		FROM
+			group_messages gm
+		JOIN
+			users u ON u.id = gm.user_id
+		WHERE
+			gm.group_id = $1 AND ($2::BIGINT = 0 OR gm.id < $2::BIGINT)
+		ORDER BY
+			gm.id DESC
+		LIMIT $3
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(groupID, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (repo *Repository) GetMessage(groupID, messageID int64) (*Message, error) {
+	query := `
+		SELECT
+			gm.id, gm.group_id, gm.user_id, u.display_name, gm.body, gm.created_at, gm.edited_at, gm.deleted_at
+		FROM
+			group_messages gm
+		JOIN
+			users u ON u.id = gm.user_id
+		WHERE
+			gm.group_id = $1 AND gm.id = $2
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	return scanMessage(stmt.QueryRow(groupID, messageID))
+}
+
+func (repo *Repository) CreateMessage(tx *sql.Tx, message *Message) error {
+	query := `
+		INSERT INTO
+			group_messages (group_id, user_id, body, created_at)
+		VALUES
+			($1, $2, $3, CURRENT_TIMESTAMP)
+		RETURNING id, created_at
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(
+		message.GroupID,
+		message.UserID,
+		message.Body,
+	).Scan(&message.ID, &message.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateMessage は本文を書き換える｡削除済みのメッセージは sql.ErrNoRows になる
+func (repo *Repository) UpdateMessage(tx *sql.Tx, message *Message) error {
+	query := `
+		UPDATE
+			group_messages
+		SET
+			body = $1, edited_at = CURRENT_TIMESTAMP
+		WHERE
+			group_id = $2 AND id = $3 AND deleted_at IS NULL
+		RETURNING edited_at
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var editedAt time.Time
+	err = stmt.QueryRow(
+		message.Body,
+		message.GroupID,
+		message.ID,
+	).Scan(&editedAt)
+
+	if err != nil {
+		return err
+	}
+
+	message.EditedAt = &editedAt
+	return nil
+}
+
+// DeleteMessage は本文を消して削除済みにする
+func (repo *Repository) DeleteMessage(tx *sql.Tx, groupID, messageID int64) (bool, error) {
+	query := `
+		UPDATE
+			group_messages
+		SET
+			body = '', deleted_at = CURRENT_TIMESTAMP
+		WHERE
+			group_id = $1 AND id = $2 AND deleted_at IS NULL
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(groupID, messageID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// MarkMessagesRead は messageID までを既読にする｡既読位置は戻らない｡
+// messageID がグループのメッセージでなければ sql.ErrNoRows を返す
+func (repo *Repository) MarkMessagesRead(tx *sql.Tx, groupID, userID, messageID int64) (*MessageRead, error) {
+	query := `
+		INSERT INTO
+			group_message_reads (group_id, user_id, last_read_message_id, read_at)
+		SELECT
+			group_id, $2::INT, id, CURRENT_TIMESTAMP
+		FROM
+			group_messages
+		WHERE
+			group_id = $1 AND id = $3
+		ON CONFLICT (group_id, user_id) DO UPDATE
+		SET
+			last_read_message_id = GREATEST(group_message_reads.last_read_message_id, EXCLUDED.last_read_message_id),
+			read_at = EXCLUDED.read_at
+		RETURNING last_read_message_id, read_at
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	read := MessageRead{UserID: userID}
+	err = stmt.QueryRow(groupID, userID, messageID).Scan(&read.LastReadMessageID, &read.ReadAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &read, nil
+}
+
+// ListMessageReads は今のメンバーの既読位置を返す
+func (repo *Repository) ListMessageReads(groupID int64) ([]MessageRead, error) {
+	query := `
+		SELECT
+			r.user_id, u.display_name, r.last_read_message_id, r.read_at
+		FROM
+			group_message_reads r
+		JOIN
+			group_members gm ON gm.group_id = r.group_id AND gm.user_id = r.user_id
+		JOIN
+			users u ON u.id = r.user_id
+		WHERE
+			r.group_id = $1
+		ORDER BY
+			r.user_id
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reads := []MessageRead{}
+	for rows.Next() {
+		var read MessageRead
+		err := rows.Scan(
+			&read.UserID,
+			&read.DisplayName,
+			&read.LastReadMessageID,
+			&read.ReadAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reads = append(reads, read)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reads, nil
+}
+
+func scanMessage(row rowScanner) (*Message, error) {
+	var message Message
+	var editedAt, deletedAt sql.NullTime
+	err := row.Scan(
+		&message.ID,
+		&message.GroupID,
+		&message.UserID,
+		&message.DisplayName,
+		&message.Body,
+		&message.CreatedAt,
+		&editedAt,
+		&deletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if editedAt.Valid {
+		message.EditedAt = &editedAt.Time
+	}
+	message.Deleted = deletedAt.Valid
+
+	return &message, nil
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"errors"
+	"time"
 )
 
 type Repository struct {
@@ -20,13 +21,19 @@ func NewRepository(db *sql.DB) *Repository {
 
 type UserInterface interface {
 	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
-	SaveUserInfo(tx *sql.Tx, userInfo map[string]interface{}) (int64, error)
+	SaveUserInfo(tx *sql.Tx, profile *LineProfile) (int64, error)
+	SyncUserProfile(tx *sql.Tx, userID int64, profile *LineProfile) error
+	GetUserProfile(userID int64) (*UserProfile, error)
+	UpdateDisplayName(tx *sql.Tx, userID int64, displayName *string) error
 	GetUserByLineID(lineID string) (*User, error)
 	CreateSession(tx *sql.Tx, userID int64) (string, error)
 	UpdateSessionIfExists(tx *sql.Tx, userID int64) (string, error)
 	UpdateToken(tx *sql.Tx, userID int64, accessToken, refreshToken string) error
 	SaveUserToken(tx *sql.Tx, userID int64, accessToken, refreshToken string) error
 	GetUserBySessionToken(sessionToken string) (*User, error)
+	GetPayPalMeUsername(userID int64) (string, error)
+	UpdatePayPalMeUsername(tx *sql.Tx, userID int64, username string) error
+	AppendAuditEvent(tx *sql.Tx, event *AuditEvent) error
 }
 
 type User struct {
@@ -42,12 +49,12 @@ func (repo *Repository) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.
 	return repo.db.BeginTx(ctx, opts)
 }
 
-func (repo *Repository) SaveUserInfo(tx *sql.Tx, userInfo map[string]interface{}) (int64, error) {
+func (repo *Repository) SaveUserInfo(tx *sql.Tx, profile *LineProfile) (int64, error) {
 	query := `
 		INSERT INTO
-			users (line_sub, display_name, picture_url, created_at, updated_at)
+			users (line_sub, display_name, line_display_name, picture_url, email, email_verified_at, created_at, updated_at)
 		VALUES
-			($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			($1, $2, $2, $3, $4::VARCHAR, CASE WHEN $4::VARCHAR IS NULL THEN NULL ELSE CURRENT_TIMESTAMP END, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 		RETURNING id
 	`
 
@@ -59,9 +66,10 @@ func (repo *Repository) SaveUserInfo(tx *sql.Tx, userInfo map[string]interface{}
 
 	var userID int64
 	err = stmt.QueryRow(
-		userInfo["sub"],
-		userInfo["name"],
-		userInfo["picture"],
+		profile.Sub,
+		profile.Name,
+		nullString(profile.Picture),
+		nullString(profile.Email),
 	).Scan(&userID)
 
 	if err != nil {
@@ -71,10 +79,41 @@ func (repo *Repository) SaveUserInfo(tx *sql.Tx, userInfo map[string]interface{}
 	return userID, nil
 }
 
+// SyncUserProfile はログインのたびに LINE のプロフィールに合わせる｡
+// ユーザーが表示名を変えていればそちらを残す｡メールアドレスはまだ登録されていないときだけ入れる
+func (repo *Repository) SyncUserProfile(tx *sql.Tx, userID int64, profile *LineProfile) error {
+	query := `
+		UPDATE
+			users
+		SET
+			line_display_name = $1,
+			display_name = CASE WHEN display_name_overridden THEN display_name ELSE $1 END,
+			picture_url = $2,
+			email = COALESCE(email, $4::VARCHAR),
+			email_verified_at = CASE WHEN email IS NULL AND $4::VARCHAR IS NOT NULL THEN CURRENT_TIMESTAMP ELSE email_verified_at END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			id = $3
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(profile.Name, nullString(profile.Picture), userID, nullString(profile.Email))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (repo *Repository) GetUserByLineID(lineID string) (*User, error) {
 	query := `
 		SELECT
-			id,line_sub,display_name,picture_url
+			id,line_sub,display_name,picture_url,email
 		FROM
 			users
 		WHERE
@@ -88,12 +127,13 @@ func (repo *Repository) GetUserByLineID(lineID string) (*User, error) {
 	defer stmt.Close()
 
 	var user User
-	var pictureURL sql.NullString
+	var pictureURL, email sql.NullString
 	err = stmt.QueryRow(lineID).Scan(
 		&user.ID,
 		&user.LineID,
 		&user.Name,
 		&pictureURL,
+		&email,
 	)
 
 	if err != nil {
@@ -103,6 +143,7 @@ func (repo *Repository) GetUserByLineID(lineID string) (*User, error) {
 	if pictureURL.Valid {
 		user.Picture = pictureURL.String
 	}
+	user.Email = email.String
 
 	return &user, nil
 }
@@ -134,6 +175,8 @@ func (repo *Repository) CreateSession(tx *sql.Tx, userID int64) (string, error)
 	return sessionID, nil
 }
 
+// UpdateSessionIfExists はセッションのトークンを作り直す｡
+// 運営者に取り消されたなどでセッションがなければ sql.ErrNoRows を返す
 func (repo *Repository) UpdateSessionIfExists(tx *sql.Tx, userID int64) (string, error) {
 	sessionID, err := generateSessionID(16)
 	if err != nil {
@@ -166,7 +209,7 @@ func (repo *Repository) UpdateSessionIfExists(tx *sql.Tx, userID int64) (string,
 	}
 
 	if rowsAffected == 0 {
-		return "", errors.New("セッションが存在しないのに更新はできません")
+		return "", sql.ErrNoRows
 	}
 
 	return sessionID, nil
@@ -214,6 +257,27 @@ func (repo *Repository) UpdateToken(tx *sql.Tx, userID int64, accessToken, refre
 	return nil
 }
 
+// GetRefreshToken はトークンの更新が重ならないようにロックしてリフレッシュトークンを返す
+func (repo *Repository) GetRefreshToken(tx *sql.Tx, userID int64) (string, error) {
+	query := `
+		SELECT
+			refresh_token
+		FROM
+			user_tokens
+		WHERE
+			user_id = $1
+		FOR UPDATE
+	`
+
+	var refreshToken string
+	err := tx.QueryRow(query, userID).Scan(&refreshToken)
+	if err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
 func (repo *Repository) SaveUserToken(tx *sql.Tx, userID int64, accessToken, refreshToken string) error {
 	query := `
 		INSERT INTO
@@ -239,7 +303,7 @@ func (repo *Repository) SaveUserToken(tx *sql.Tx, userID int64, accessToken, ref
 func (repo *Repository) GetUserBySessionToken(sessionToken string) (*User, error) {
 	query := `
 		SELECT
-			u.id, u.line_sub, u.display_name, u.picture_url
+			u.id, u.line_sub, u.display_name, u.picture_url, u.email
 		FROM
 			users u
 		INNER
@@ -255,12 +319,13 @@ func (repo *Repository) GetUserBySessionToken(sessionToken string) (*User, error
 	defer stmt.Close()
 
 	var user User
-	var pictureURL sql.NullString
+	var pictureURL, email sql.NullString
 	err = stmt.QueryRow(sessionToken).Scan(
 		&user.ID,
 		&user.LineID,
 		&user.Name,
 		&pictureURL,
+		&email,
 	)
 
 	if err != nil {
@@ -273,6 +338,148 @@ func (repo *Repository) GetUserBySessionToken(sessionToken string) (*User, error
 	if pictureURL.Valid {
 		user.Picture = pictureURL.String
 	}
+	user.Email = email.String
 
 	return &user, nil
 }
+
+func (repo *Repository) GetPayPalMeUsername(userID int64) (string, error) {
+	query := `
+		SELECT
+			paypal_me_username
+		FROM
+			users
+		WHERE
+			id = $1
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return "", err
+	}
+	defer stmt.Close()
+
+	var username sql.NullString
+	err = stmt.QueryRow(userID).Scan(&username)
+	if err != nil {
+		return "", err
+	}
+
+	return username.String, nil
+}
+
+// UpdatePayPalMeUsername は空文字を渡すと未設定に戻す
+func (repo *Repository) UpdatePayPalMeUsername(tx *sql.Tx, userID int64, username string) error {
+	query := `
+		UPDATE
+			users
+		SET
+			paypal_me_username = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE
+			id = $2
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(nullString(username), userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UserProfile は /api/me で返すログインユーザーのプロフィール
+type UserProfile struct {
+	ID                    int64      `json:"id"`
+	DisplayName           string     `json:"display_name"`
+	LineDisplayName       string     `json:"line_display_name"`
+	DisplayNameOverridden bool       `json:"display_name_overridden"`
+	PictureURL            string     `json:"picture_url"`
+	PayPalMeUsername      string     `json:"paypal_me_username"`
+	Email                 string     `json:"email"`
+	EmailVerifiedAt       *time.Time `json:"email_verified_at"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+}
+
+const userProfileQuery = `
+	SELECT
+		id, display_name, line_display_name, display_name_overridden, picture_url, paypal_me_username,
+		email, email_verified_at, created_at, updated_at
+	FROM
+		users
+	WHERE
+		id = $1
+`
+
+func (repo *Repository) GetUserProfile(userID int64) (*UserProfile, error) {
+	stmt, err := repo.db.Prepare(userProfileQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	return scanUserProfile(stmt.QueryRow(userID))
+}
+
+func scanUserProfile(row rowScanner) (*UserProfile, error) {
+	var profile UserProfile
+	var pictureURL, payPalMeUsername, email sql.NullString
+	var emailVerifiedAt sql.NullTime
+	err := row.Scan(
+		&profile.ID,
+		&profile.DisplayName,
+		&profile.LineDisplayName,
+		&profile.DisplayNameOverridden,
+		&pictureURL,
+		&payPalMeUsername,
+		&email,
+		&emailVerifiedAt,
+		&profile.CreatedAt,
+		&profile.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.PictureURL = pictureURL.String
+	profile.PayPalMeUsername = payPalMeUsername.String
+	profile.Email = email.String
+	if emailVerifiedAt.Valid {
+		profile.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
+
+	return &profile, nil
+}
+
+// UpdateDisplayName はユーザーが付けた表示名にする｡nil なら LINE の名前に戻す
+func (repo *Repository) UpdateDisplayName(tx *sql.Tx, userID int64, displayName *string) error {
+	query := `
+		UPDATE
+			users
+		SET
+			display_name = COALESCE($1::VARCHAR, line_display_name),
+			display_name_overridden = $1::VARCHAR IS NOT NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			id = $2
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(displayName, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type NotificationInterface interface {
+	ListNotifications(userID int64, limit int) ([]Notification, error)
+	MarkNotificationsRead(userID int64) error
+	ListNotificationOptOuts(userID int64) ([]string, error)
+	SetNotificationOptOut(tx *sql.Tx, userID int64, kind string, optedOut bool) error
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+type Notification struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"`
+	GroupID   *int64     `json:"group_id"`
+	Kind      string     `json:"kind"`
+	Message   string     `json:"message"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at"`
+}
+
+const (
+	NotificationKindGroupDeleted = "group_deleted"
+	NotificationKindGroupClosed  = "group_closed"
+	// NotificationKindOwnershipTransferred はオーナーが退会してグループを引き継いだ
+	NotificationKindOwnershipTransferred = "ownership_transferred"
+	// NotificationKindRoleChanged はオーナーがグループでの役割を変えた
+	NotificationKindRoleChanged = "role_changed"
+)
+
+func (repo *Repository) CreateNotification(tx *sql.Tx, notification *Notification) error {
+	query := `
+		INSERT INTO
+			notifications (user_id, group_id, kind, message, created_at)
+		VALUES
+			($1, $2, $3, $4, CURRENT_TIMESTAMP)
+		RETURNING id, created_at
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(
+		notification.UserID,
+		notification.GroupID,
+		notification.Kind,
+		notification.Message,
+	).Scan(&notification.ID, &notification.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListNotifications(userID int64, limit int) ([]Notification, error) {
+	query := `
+		SELECT
+			id, user_id, group_id, kind, message, created_at, read_at
+		FROM
+			notifications
+		WHERE
+			user_id = $1
+		ORDER BY
+			created_at DESC, id DESC
+		LIMIT $2
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var notification Notification
+		var groupID sql.NullInt64
+		var readAt sql.NullTime
+		err := rows.Scan(
+			&notification.ID,
+			&notification.UserID,
+			&groupID,
+			&notification.Kind,
+			&notification.Message,
+			&notification.CreatedAt,
+			&readAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if groupID.Valid {
+			notification.GroupID = &groupID.Int64
+		}
+		if readAt.Valid {
+			notification.ReadAt = &readAt.Time
+		}
+
+		notifications = append(notifications, notification)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+func (repo *Repository) MarkNotificationsRead(userID int64) error {
+	query := `
+		UPDATE
+			notifications
+		SET
+			read_at = CURRENT_TIMESTAMP
+		WHERE
+			user_id = $1 AND read_at IS NULL
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,340 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"errors"
+	"time"
+)
+
+// ErrMenuItemNotInGroup は注文しようとした料理がそのグループのものではない場合のエラー
+var ErrMenuItemNotInGroup = errors.New("menu item does not belong to the group")
+
+type OrderInterface interface {
+	GetGroup(groupID int64) (*Group, error)
+	GetMemberRole(groupID, userID int64) (authz.Role, bool, error)
+	LockGroup(tx *sql.Tx, groupID int64) (*Group, error)
+	GetGroupMemberID(tx *sql.Tx, groupID, userID int64) (int64, error)
+	ListMemberOrder(groupID, userID int64) ([]OrderLine, error)
+	ReplaceMemberOrder(tx *sql.Tx, groupID, groupMemberID int64, lines []OrderLine) error
+	DeleteMemberOrder(tx *sql.Tx, groupMemberID int64) (bool, error)
+	GetOrderSummary(groupID int64) (*OrderSummary, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// OrderLine はメンバーの注文の1行
+type OrderLine struct {
+	MenuItemID int64     `json:"menu_item_id"`
+	Name       string    `json:"name"`
+	Price      int64     `json:"price"`
+	Unit       string    `json:"unit"`
+	Quantity   int64     `json:"quantity"`
+	Note       string    `json:"note"`
+	Subtotal   int64     `json:"subtotal"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// OrderSummary はオーナーが実際に買い出しをするための集計
+type OrderSummary struct {
+	GroupID     int64              `json:"group_id"`
+	Items       []OrderSummaryItem `json:"items"`
+	Members     []MemberOrder      `json:"members"`
+	TotalAmount int64              `json:"total_amount"`
+}
+
+type OrderSummaryItem struct {
+	MenuItemID    int64  `json:"menu_item_id"`
+	Name          string `json:"name"`
+	Price         int64  `json:"price"`
+	Unit          string `json:"unit"`
+	TotalQuantity int64  `json:"total_quantity"`
+	Subtotal      int64  `json:"subtotal"`
+}
+
+type MemberOrder struct {
+	UserID      int64       `json:"user_id"`
+	DisplayName string      `json:"display_name"`
+	Lines       []OrderLine `json:"lines"`
+	Total       int64       `json:"total"`
+}
+
+func (repo *Repository) GetGroupMemberID(tx *sql.Tx, groupID, userID int64) (int64, error) {
+	query := `
+		SELECT
+			id
+		FROM
+			group_members
+		WHERE
+			group_id = $1 AND user_id = $2
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var groupMemberID int64
+	err = stmt.QueryRow(groupID, userID).Scan(&groupMemberID)
+	if err != nil {
+		return 0, err
+	}
+
+	return groupMemberID, nil
+}
+
+func (repo *Repository) ListMemberOrder(groupID, userID int64) ([]OrderLine, error) {
+	query := `
+		SELECT
+			mi.id, mi.name, mi.price, mi.unit, mo.quantity, mo.note, mo.updated_at
+		FROM
+			member_orders mo
+		JOIN
+			group_members gm ON mo.group_member_id = gm.id
+		JOIN
+			menu_items mi ON mo.menu_item_id = mi.id
+		WHERE
+			gm.group_id = $1 AND gm.user_id = $2
+		ORDER BY
+			mi.id
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []OrderLine{}
+	for rows.Next() {
+		var line OrderLine
+		var note sql.NullString
+		err := rows.Scan(
+			&line.MenuItemID,
+			&line.Name,
+			&line.Price,
+			&line.Unit,
+			&line.Quantity,
+			&note,
+			&line.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		line.Note = note.String
+		line.Subtotal = line.Price * line.Quantity
+		lines = append(lines, line)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+// ReplaceMemberOrder はメンバーの注文をまるごと置き換える｡
+// グループに属さない料理が含まれていれば ErrMenuItemNotInGroup を返す
+func (repo *Repository) ReplaceMemberOrder(tx *sql.Tx, groupID, groupMemberID int64, lines []OrderLine) error {
+	if _, err := repo.DeleteMemberOrder(tx, groupMemberID); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO
+			member_orders (group_member_id, menu_item_id, quantity, note, created_at, updated_at)
+		SELECT
+			$1, id, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+		FROM
+			menu_items
+		WHERE
+			id = $4 AND group_id = $5
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, line := range lines {
+		result, err := stmt.Exec(groupMemberID, line.Quantity, nullString(line.Note), line.MenuItemID, groupID)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrMenuItemNotInGroup
+		}
+	}
+
+	return nil
+}
+
+func (repo *Repository) DeleteMemberOrder(tx *sql.Tx, groupMemberID int64) (bool, error) {
+	query := `
+		DELETE FROM
+			member_orders
+		WHERE
+			group_member_id = $1
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(groupMemberID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (repo *Repository) GetOrderSummary(groupID int64) (*OrderSummary, error) {
+	itemsQuery := `
+		SELECT
+			mi.id, mi.name, mi.price, mi.unit, COALESCE(SUM(mo.quantity), 0)
+		FROM
+			menu_items mi
+		LEFT JOIN
+			member_orders mo ON mo.menu_item_id = mi.id
+		WHERE
+			mi.group_id = $1
+		GROUP BY
+			mi.id
+		ORDER BY
+			mi.id
+	`
+
+	stmt, err := repo.db.Prepare(itemsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summary := &OrderSummary{
+		GroupID: groupID,
+		Items:   []OrderSummaryItem{},
+		Members: []MemberOrder{},
+	}
+	for rows.Next() {
+		var item OrderSummaryItem
+		err := rows.Scan(
+			&item.MenuItemID,
+			&item.Name,
+			&item.Price,
+			&item.Unit,
+			&item.TotalQuantity,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		item.Subtotal = item.Price * item.TotalQuantity
+		summary.TotalAmount += item.Subtotal
+		summary.Items = append(summary.Items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	membersQuery := `
+		SELECT
+			u.id, u.display_name, mi.id, mi.name, mi.price, mi.unit, mo.quantity, mo.note, mo.updated_at
+		FROM
+			member_orders mo
+		JOIN
+			group_members gm ON mo.group_member_id = gm.id
+		JOIN
+			users u ON gm.user_id = u.id
+		JOIN
+			menu_items mi ON mo.menu_item_id = mi.id
+		WHERE
+			gm.group_id = $1
+		ORDER BY
+			gm.joined_at, gm.id, mi.id
+	`
+
+	memberStmt, err := repo.db.Prepare(membersQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer memberStmt.Close()
+
+	memberRows, err := memberStmt.Query(groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer memberRows.Close()
+
+	for memberRows.Next() {
+		var userID int64
+		var displayName string
+		var line OrderLine
+		var note sql.NullString
+		err := memberRows.Scan(
+			&userID,
+			&displayName,
+			&line.MenuItemID,
+			&line.Name,
+			&line.Price,
+			&line.Unit,
+			&line.Quantity,
+			&note,
+			&line.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		line.Note = note.String
+		line.Subtotal = line.Price * line.Quantity
+
+		// メンバーごとにまとめる｡行はメンバー順に並んでいる
+		last := len(summary.Members) - 1
+		if last < 0 || summary.Members[last].UserID != userID {
+			summary.Members = append(summary.Members, MemberOrder{
+				UserID:      userID,
+				DisplayName: displayName,
+				Lines:       []OrderLine{},
+			})
+			last++
+		}
+		summary.Members[last].Lines = append(summary.Members[last].Lines, line)
+		summary.Members[last].Total += line.Subtotal
+	}
+
+	if err := memberRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,192 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSent    = "sent"
+	OutboxStatusFailed  = "failed"
+)
+
+// OutboxMessage はアウトボックスに積まれたプッシュ通知｡送信と再送はジョブキューで行う
+type OutboxMessage struct {
+	ID     int64
+	UserID int64
+	// LineSub は送り先｡取り出すときに users から埋める
+	LineSub   string
+	Kind      string
+	Params    json.RawMessage
+	RetryKey  string
+	Status    string
+	CreatedAt time.Time
+}
+
+// EnqueueOutboxMessage はユーザーがその種類の通知を止めておらず､退会もしていなければアウトボックスに積む｡
+// 積んだかどうかを返す
+func (repo *Repository) EnqueueOutboxMessage(tx *sql.Tx, message *OutboxMessage) (bool, error) {
+	query := `
+		INSERT INTO
+			notification_outbox (user_id, kind, params, created_at)
+		SELECT
+			$1::INT, $2::VARCHAR, $3::JSONB, CURRENT_TIMESTAMP
+		WHERE NOT EXISTS (
+			SELECT 1 FROM notification_opt_outs WHERE user_id = $1 AND kind = $2
+		) AND NOT EXISTS (
+			SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NOT NULL
+		)
+		RETURNING id, retry_key, created_at
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(
+		message.UserID,
+		message.Kind,
+		string(message.Params),
+	).Scan(&message.ID, &message.RetryKey, &message.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetOutboxMessage は送る通知を送り先と一緒にロックして返す
+func (repo *Repository) GetOutboxMessage(tx *sql.Tx, id int64) (*OutboxMessage, error) {
+	query := `
+		SELECT
+			o.id, o.user_id, u.line_sub, o.kind, o.params, o.retry_key, o.status, o.created_at
+		FROM
+			notification_outbox o
+		JOIN
+			users u ON u.id = o.user_id
+		WHERE
+			o.id = $1
+		FOR UPDATE OF o
+	`
+
+	var message OutboxMessage
+	var params []byte
+	err := tx.QueryRow(query, id).Scan(
+		&message.ID,
+		&message.UserID,
+		&message.LineSub,
+		&message.Kind,
+		&params,
+		&message.RetryKey,
+		&message.Status,
+		&message.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	message.Params = params
+
+	return &message, nil
+}
+
+func (repo *Repository) MarkOutboxSent(tx *sql.Tx, id int64) error {
+	query := `
+		UPDATE
+			notification_outbox
+		SET
+			status = 'sent', sent_at = CURRENT_TIMESTAMP, last_error = NULL
+		WHERE
+			id = $1
+	`
+
+	_, err := tx.Exec(query, id)
+	return err
+}
+
+// MarkOutboxFailed は送っても届かない通知をあきらめる
+func (repo *Repository) MarkOutboxFailed(tx *sql.Tx, id int64, lastError string) error {
+	query := `
+		UPDATE
+			notification_outbox
+		SET
+			status = 'failed', last_error = $2
+		WHERE
+			id = $1
+	`
+
+	_, err := tx.Exec(query, id, lastError)
+	return err
+}
+
+// ListNotificationOptOuts はユーザーが止めている通知の種類を返す
+func (repo *Repository) ListNotificationOptOuts(userID int64) ([]string, error) {
+	query := `
+		SELECT
+			kind
+		FROM
+			notification_opt_outs
+		WHERE
+			user_id = $1
+		ORDER BY
+			kind
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	kinds := []string{}
+	for rows.Next() {
+		var kind string
+		if err := rows.Scan(&kind); err != nil {
+			return nil, err
+		}
+		kinds = append(kinds, kind)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return kinds, nil
+}
+
+// SetNotificationOptOut は通知の種類ごとに受け取るかどうかを切り替える
+func (repo *Repository) SetNotificationOptOut(tx *sql.Tx, userID int64, kind string, optedOut bool) error {
+	var query string
+	if optedOut {
+		query = `
+			INSERT INTO
+				notification_opt_outs (user_id, kind, created_at)
+			VALUES
+				($1, $2, CURRENT_TIMESTAMP)
+			ON CONFLICT (user_id, kind) DO NOTHING
+		`
+	} else {
+		query = `
+			DELETE FROM
+				notification_opt_outs
+			WHERE
+				user_id = $1 AND kind = $2
+		`
+	}
+
+	_, err := tx.Exec(query, userID, kind)
+	return err
+}
@@ -0,0 +1,263 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"time"
+)
+
+type PaymentInterface interface {
+	GetGroup(groupID int64) (*Group, error)
+	GetMemberRole(groupID, userID int64) (authz.Role, bool, error)
+	GetSettlement(groupID int64, method SplitMethod) (*Settlement, error)
+	ListPayments(groupID int64) ([]Payment, error)
+	ListGroupPayPalMeUsernames(groupID int64) (map[int64]string, error)
+	MarkPaymentPaid(tx *sql.Tx, groupID, payerID, payeeID, amount int64) error
+	ConfirmPayment(tx *sql.Tx, groupID, payerID, payeeID int64) (bool, error)
+	AppendAuditEvent(tx *sql.Tx, event *AuditEvent) error
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+const (
+	// PaymentStatusUnpaid は送金の記録がまだない状態｡payments テーブルには保存しない
+	PaymentStatusUnpaid = "unpaid"
+	// PaymentStatusPaid は送金した側が支払い済みにした状態
+	PaymentStatusPaid = "paid"
+	// PaymentStatusConfirmed は受け取った側が確認した状態
+	PaymentStatusConfirmed = "confirmed"
+)
+
+type Payment struct {
+	ID          int64      `json:"id"`
+	GroupID     int64      `json:"group_id"`
+	PayerID     int64      `json:"payer_id"`
+	PayeeID     int64      `json:"payee_id"`
+	Amount      int64      `json:"amount"`
+	Status      string     `json:"status"`
+	PaidAt      time.Time  `json:"paid_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+}
+
+// PaymentStatus は精算の送金1件ごとの支払い状況
+type PaymentStatus struct {
+	FromUserID      int64  `json:"from_user_id"`
+	FromDisplayName string `json:"from_display_name"`
+	ToUserID        int64  `json:"to_user_id"`
+	ToDisplayName   string `json:"to_display_name"`
+	// Amount は現在の精算で送金すべき額
+	Amount int64  `json:"amount"`
+	Status string `json:"status"`
+	// Outstanding は受け取った側がまだ確認していない額
+	Outstanding int64      `json:"outstanding"`
+	PayPalMeURL string     `json:"paypal_me_url"`
+	PaidAt      *time.Time `json:"paid_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+}
+
+// BuildPaymentStatuses は精算の送金と支払いの記録を突き合わせる｡
+// 支払い後に精算額が増えた場合は差額を未払いとして扱う
+func BuildPaymentStatuses(settlement *Settlement, payments []Payment, payPalMeUsernames map[int64]string) []PaymentStatus {
+	names := make(map[int64]string, len(settlement.Shares))
+	for _, share := range settlement.Shares {
+		names[share.UserID] = share.DisplayName
+	}
+
+	type pair struct{ payerID, payeeID int64 }
+	recorded := make(map[pair]Payment, len(payments))
+	for _, payment := range payments {
+		recorded[pair{payment.PayerID, payment.PayeeID}] = payment
+	}
+
+	statuses := make([]PaymentStatus, 0, len(settlement.Transfers))
+	for _, transfer := range settlement.Transfers {
+		status := PaymentStatus{
+			FromUserID:      transfer.FromUserID,
+			FromDisplayName: names[transfer.FromUserID],
+			ToUserID:        transfer.ToUserID,
+			ToDisplayName:   names[transfer.ToUserID],
+			Amount:          transfer.Amount,
+			Status:          PaymentStatusUnpaid,
+			Outstanding:     transfer.Amount,
+		}
+
+		due := transfer.Amount
+		if payment, ok := recorded[pair{transfer.FromUserID, transfer.ToUserID}]; ok {
+			paidAt := payment.PaidAt
+			status.PaidAt = &paidAt
+			status.ConfirmedAt = payment.ConfirmedAt
+
+			if payment.Amount >= transfer.Amount {
+				status.Status = payment.Status
+				due = 0
+			} else {
+				due = transfer.Amount - payment.Amount
+			}
+
+			if payment.Status == PaymentStatusConfirmed {
+				status.Outstanding = max(transfer.Amount-payment.Amount, 0)
+			}
+		}
+
+		status.PayPalMeURL = PayPalMeLink(payPalMeUsernames[transfer.ToUserID], due)
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+func (repo *Repository) ListPayments(groupID int64) ([]Payment, error) {
+	query := `
+		SELECT
+			id, group_id, payer_id, payee_id, amount, status, paid_at, confirmed_at
+		FROM
+			payments
+		WHERE
+			group_id = $1
+		ORDER BY
+			id
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []Payment
+	for rows.Next() {
+		var payment Payment
+		var confirmedAt sql.NullTime
+		err := rows.Scan(
+			&payment.ID,
+			&payment.GroupID,
+			&payment.PayerID,
+			&payment.PayeeID,
+			&payment.Amount,
+			&payment.Status,
+			&payment.PaidAt,
+			&confirmedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if confirmedAt.Valid {
+			payment.ConfirmedAt = &confirmedAt.Time
+		}
+		payments = append(payments, payment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return payments, nil
+}
+
+// ListGroupPayPalMeUsernames はPayPal.meを設定しているメンバーのユーザー名を返す
+func (repo *Repository) ListGroupPayPalMeUsernames(groupID int64) (map[int64]string, error) {
+	query := `
+		SELECT
+			u.id, u.paypal_me_username
+		FROM
+			group_members gm
+		JOIN
+			users u ON gm.user_id = u.id
+		WHERE
+			gm.group_id = $1 AND u.paypal_me_username IS NOT NULL
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usernames := map[int64]string{}
+	for rows.Next() {
+		var userID int64
+		var username string
+		if err := rows.Scan(&userID, &username); err != nil {
+			return nil, err
+		}
+		usernames[userID] = username
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return usernames, nil
+}
+
+// MarkPaymentPaid は送金を支払い済みにする｡すでに記録がある場合は金額を更新して確認をやり直す
+func (repo *Repository) MarkPaymentPaid(tx *sql.Tx, groupID, payerID, payeeID, amount int64) error {
+	query := `
+		INSERT INTO
+			payments (group_id, payer_id, payee_id, amount, status, paid_at, created_at, updated_at)
+		VALUES
+			($1, $2, $3, $4, 'paid', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (group_id, payer_id, payee_id) DO UPDATE
+		SET
+			amount = EXCLUDED.amount,
+			status = 'paid',
+			paid_at = CURRENT_TIMESTAMP,
+			confirmed_at = NULL,
+			updated_at = CURRENT_TIMESTAMP
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(groupID, payerID, payeeID, amount)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) ConfirmPayment(tx *sql.Tx, groupID, payerID, payeeID int64) (bool, error) {
+	query := `
+		UPDATE
+			payments
+		SET
+			status = 'confirmed', confirmed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE
+			group_id = $1 AND payer_id = $2 AND payee_id = $3
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(groupID, payerID, payeeID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
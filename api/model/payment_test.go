@@ -0,0 +1,79 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidatePayPalMeUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		valid    bool
+	}{
+		{"domeal", true},
+		{"Domeal2024", true},
+		{"abcdefghijklmnopqrst", true},
+		{"", false},
+		{"abcdefghijklmnopqrstu", false},
+		{"do-meal", false},
+		{"paypal.me/domeal", false},
+		{"ドミール", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidatePayPalMeUsername(tt.username)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidatePayPalMeUsername(%q) error = %v, want valid %v", tt.username, err, tt.valid)
+		}
+	}
+}
+
+func TestBuildPaymentStatuses(t *testing.T) {
+	settlement := &Settlement{
+		GroupID: 1,
+		Shares: []SettlementShare{
+			{UserID: 1, DisplayName: "owner"},
+			{UserID: 2, DisplayName: "a"},
+			{UserID: 3, DisplayName: "b"},
+			{UserID: 4, DisplayName: "c"},
+		},
+		Transfers: []Transfer{
+			{FromUserID: 2, ToUserID: 1, Amount: 1000},
+			{FromUserID: 3, ToUserID: 1, Amount: 800},
+			{FromUserID: 4, ToUserID: 1, Amount: 1200},
+		},
+	}
+	confirmedAt := time.Now()
+	payments := []Payment{
+		{PayerID: 2, PayeeID: 1, Amount: 1000, Status: PaymentStatusConfirmed, ConfirmedAt: &confirmedAt},
+		{PayerID: 3, PayeeID: 1, Amount: 800, Status: PaymentStatusPaid},
+		// 支払い後に注文が増えた
+		{PayerID: 4, PayeeID: 1, Amount: 1000, Status: PaymentStatusConfirmed, ConfirmedAt: &confirmedAt},
+	}
+
+	statuses := BuildPaymentStatuses(settlement, payments, map[int64]string{1: "owner"})
+
+	tests := []struct {
+		status      string
+		outstanding int64
+		url         string
+	}{
+		{PaymentStatusConfirmed, 0, ""},
+		{PaymentStatusPaid, 800, ""},
+		{PaymentStatusUnpaid, 200, "https://paypal.me/owner/200JPY"},
+	}
+
+	if len(statuses) != len(tests) {
+		t.Fatalf("got %d statuses, want %d", len(statuses), len(tests))
+	}
+	for i, tt := range tests {
+		got := statuses[i]
+		if got.Status != tt.status || got.Outstanding != tt.outstanding || got.PayPalMeURL != tt.url {
+			t.Errorf("statuses[%d] = {%s %d %q}, want {%s %d %q}",
+				i, got.Status, got.Outstanding, got.PayPalMeURL, tt.status, tt.outstanding, tt.url)
+		}
+	}
+	if statuses[0].FromDisplayName != "a" || statuses[0].ToDisplayName != "owner" {
+		t.Errorf("display names = %q -> %q", statuses[0].FromDisplayName, statuses[0].ToDisplayName)
+	}
+}
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ErrInvalidPayPalMeUsername はPayPal.meのユーザー名として使えない文字列の場合のエラー
+var ErrInvalidPayPalMeUsername = errors.New("paypal.me username must be 1 to 20 letters or digits")
+
+// PayPal.meのユーザー名は英数字のみで20文字まで
+var payPalMeUsernamePattern = regexp.MustCompile(`^[A-Za-z0-9]{1,20}$`)
+
+func ValidatePayPalMeUsername(username string) error {
+	if !payPalMeUsernamePattern.MatchString(username) {
+		return ErrInvalidPayPalMeUsername
+	}
+	return nil
+}
+
+// PayPalMeLink は金額入りの支払いリンクを作る｡ユーザー名が未設定なら空文字を返す
+func PayPalMeLink(username string, amount int64) string {
+	if username == "" || amount <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("https://paypal.me/%s/%dJPY", username, amount)
+}
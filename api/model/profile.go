@@ -0,0 +1,111 @@
+package model
+
+import (
+	"errors"
+	"net/mail"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxDisplayNameLength はユーザーが自分で付ける表示名の上限
+	MaxDisplayNameLength = 50
+	// maxLineDisplayNameLength は LINE の名前を保存するときの上限｡カラムの長さに合わせる
+	maxLineDisplayNameLength = 255
+)
+
+var (
+	ErrInvalidLineSub     = errors.New("line profile has no valid subject")
+	ErrMissingLineName    = errors.New("line profile has no display name")
+	ErrInvalidDisplayName = errors.New("display name must be 1 to 50 characters without control characters")
+	ErrInvalidPictureURL  = errors.New("picture must be an https URL")
+	ErrInvalidEmail       = errors.New("email must be a valid address of at most 254 characters")
+)
+
+// LineProfile はLINEログインの id_token から取り出したプロフィール
+type LineProfile struct {
+	Sub     string
+	Name    string
+	Picture string
+	Email   string
+}
+
+// Normalize は前後の空白と制御文字を取り除き､長すぎる名前を切り詰める｡
+// LINE の名前はユーザーが自由に付けるので､保存できない形でもログインは止めない
+func (p *LineProfile) Normalize() {
+	p.Sub = strings.TrimSpace(p.Sub)
+	p.Name = truncateRunes(stripControl(strings.TrimSpace(p.Name)), maxLineDisplayNameLength)
+	p.Picture = strings.TrimSpace(p.Picture)
+	p.Email = strings.TrimSpace(p.Email)
+
+	// 表示できない画像のURLや送れないメールアドレスは保存しない
+	if ValidatePictureURL(p.Picture) != nil {
+		p.Picture = ""
+	}
+	if p.Email != "" && ValidateEmail(p.Email) != nil {
+		p.Email = ""
+	}
+}
+
+// Validate はユーザーとして保存できるプロフィールかどうかを確認する｡先に Normalize しておく
+func (p *LineProfile) Validate() error {
+	if p.Sub == "" || len(p.Sub) > 255 {
+		return ErrInvalidLineSub
+	}
+	if p.Name == "" {
+		return ErrMissingLineName
+	}
+	return nil
+}
+
+// ValidateDisplayName はユーザーが自分で付ける表示名を確認する
+func ValidateDisplayName(name string) error {
+	if name == "" || utf8.RuneCountInString(name) > MaxDisplayNameLength || stripControl(name) != name {
+		return ErrInvalidDisplayName
+	}
+	return nil
+}
+
+// ValidatePictureURL は空文字か https のURLだけを受け付ける
+func ValidatePictureURL(picture string) error {
+	if picture == "" {
+		return nil
+	}
+
+	u, err := url.Parse(picture)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return ErrInvalidPictureURL
+	}
+	return nil
+}
+
+// ValidateEmail は表示名の付かないアドレスだけを受け付ける
+func ValidateEmail(email string) error {
+	if len(email) > 254 {
+		return ErrInvalidEmail
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Name != "" || address.Address != email {
+		return ErrInvalidEmail
+	}
+	return nil
+}
+
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
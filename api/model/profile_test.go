@@ -0,0 +1,85 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLineProfileNormalize(t *testing.T) {
+	profile := &LineProfile{
+		Sub:     " U1234 ",
+		Name:    "  たろう\n" + strings.Repeat("あ", 300),
+		Picture: "http://example.com/icon.png",
+	}
+	profile.Normalize()
+
+	if profile.Sub != "U1234" {
+		t.Errorf("Sub = %q", profile.Sub)
+	}
+	if !strings.HasPrefix(profile.Name, "たろうあ") || len([]rune(profile.Name)) != maxLineDisplayNameLength {
+		t.Errorf("Name = %q", profile.Name)
+	}
+	// https でない画像は保存しない
+	if profile.Picture != "" {
+		t.Errorf("Picture = %q", profile.Picture)
+	}
+	if err := profile.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+
+	if err := (&LineProfile{Name: "たろう"}).Validate(); err != ErrInvalidLineSub {
+		t.Errorf("Validate() without sub error = %v", err)
+	}
+	if err := (&LineProfile{Sub: "U1234"}).Validate(); err != ErrMissingLineName {
+		t.Errorf("Validate() without name error = %v", err)
+	}
+}
+
+func TestValidateDisplayName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"たろう", true},
+		{strings.Repeat("あ", MaxDisplayNameLength), true},
+		{strings.Repeat("あ", MaxDisplayNameLength+1), false},
+		{"", false},
+		{"たろう\x00", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateDisplayName(tt.name)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateDisplayName(%q) error = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		valid bool
+	}{
+		{"taro@example.com", true},
+		{"taro+domeal@example.co.jp", true},
+		{"", false},
+		{"taro", false},
+		{"Taro <taro@example.com>", false},
+		{"taro@example.com\r\nBcc: evil@example.com", false},
+		{strings.Repeat("a", 250) + "@example.com", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateEmail(tt.email)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateEmail(%q) error = %v, want valid %v", tt.email, err, tt.valid)
+		}
+	}
+
+	// LINE から受け取った使えないアドレスは捨てる
+	profile := &LineProfile{Sub: "U1234", Name: "たろう", Email: "not an address"}
+	profile.Normalize()
+	if profile.Email != "" {
+		t.Errorf("Email = %q", profile.Email)
+	}
+}
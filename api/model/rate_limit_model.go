@@ -0,0 +1,98 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RateLimitBucket はレート制限のトークンバケット｡Tokens は UpdatedAt の時点で残っている回数
+type RateLimitBucket struct {
+	Key       string
+	Tokens    float64
+	UpdatedAt time.Time
+	FullAt    time.Time
+}
+
+// LockRateLimitBucket はバケットをロックして返す｡なければ burst 回分が残った状態で作る｡
+// レプリカの時計のずれで回復が速くならないように､データベースの現在時刻も返す
+func (repo *Repository) LockRateLimitBucket(tx *sql.Tx, key string, burst float64) (*RateLimitBucket, time.Time, error) {
+	insertQuery := `
+		INSERT INTO
+			rate_limit_buckets (key, tokens, updated_at, full_at)
+		VALUES
+			($1, $2, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (key) DO NOTHING
+	`
+
+	if _, err := tx.Exec(insertQuery, key, burst); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	selectQuery := `
+		SELECT
+			key, tokens, updated_at, full_at, CURRENT_TIMESTAMP
+		FROM
+			rate_limit_buckets
+		WHERE
+			key = $1
+		FOR UPDATE
+	`
+
+	var bucket RateLimitBucket
+	var now time.Time
+	err := tx.QueryRow(selectQuery, key).Scan(
+		&bucket.Key,
+		&bucket.Tokens,
+		&bucket.UpdatedAt,
+		&bucket.FullAt,
+		&now,
+	)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return &bucket, now, nil
+}
+
+// SaveRateLimitBucket は LockRateLimitBucket でロックしたバケットを書き戻す
+func (repo *Repository) SaveRateLimitBucket(tx *sql.Tx, bucket *RateLimitBucket) error {
+	query := `
+		UPDATE
+			rate_limit_buckets
+		SET
+			tokens = $2, updated_at = $3, full_at = $4
+		WHERE
+			key = $1
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(bucket.Key, bucket.Tokens, bucket.UpdatedAt, bucket.FullAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteFullRateLimitBuckets は満タンに戻ったバケットを消して消した数を返す｡
+// 満タンのバケットはないのと同じなので､いつ消してもよい
+func (repo *Repository) DeleteFullRateLimitBuckets() (int64, error) {
+	query := `
+		DELETE FROM
+			rate_limit_buckets
+		WHERE
+			full_at <= CURRENT_TIMESTAMP
+	`
+
+	result, err := repo.db.Exec(query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,271 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"sort"
+)
+
+type SettlementInterface interface {
+	GetGroup(groupID int64) (*Group, error)
+	GetMemberRole(groupID, userID int64) (authz.Role, bool, error)
+	ListSettlementMembers(groupID int64) ([]SettlementMember, error)
+	GetSettlement(groupID int64, method SplitMethod) (*Settlement, error)
+	LockGroup(tx *sql.Tx, groupID int64) (*Group, error)
+	UpdateMemberShare(tx *sql.Tx, groupID, userID, weight int64, fixedAmount *int64) (bool, error)
+	AppendAuditEvent(tx *sql.Tx, event *AuditEvent) error
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// SettlementMember は精算の計算に必要なメンバーの情報
+type SettlementMember struct {
+	UserID      int64
+	DisplayName string
+	IsOwner     bool
+	Weight      int64
+	FixedAmount *int64
+	// ItemsAmount は注文した料理の小計
+	ItemsAmount int64
+}
+
+// Settlement はグループの費用を誰がいくら負担し､誰が誰にいくら払うかの内訳
+type Settlement struct {
+	GroupID int64       `json:"group_id"`
+	Method  SplitMethod `json:"method"`
+	// Source は合計額の出どころ｡expenses なら実際の立て替え､menu なら注文からの見積もり
+	Source      string            `json:"source"`
+	TotalAmount int64             `json:"total_amount"`
+	Shares      []SettlementShare `json:"shares"`
+	Transfers   []Transfer        `json:"transfers"`
+}
+
+type SettlementShare struct {
+	UserID      int64  `json:"user_id"`
+	DisplayName string `json:"display_name"`
+	// Share はこのメンバーが負担する額
+	Share int64 `json:"share"`
+	// Paid はこのメンバーが立て替えた額
+	Paid int64 `json:"paid"`
+	// Balance は Paid - Share｡プラスなら受け取る側
+	Balance int64 `json:"balance"`
+}
+
+// BuildSettlement は total 円を method で分担し､立て替えた額 paid との差から送金の一覧を作る｡
+// paid の合計は total と一致していること
+func BuildSettlement(groupID int64, method SplitMethod, total int64, members []SettlementMember, paid map[int64]int64) (*Settlement, error) {
+	participants := make([]SplitParticipant, 0, len(members))
+	for _, member := range members {
+		participants = append(participants, SplitParticipant{
+			UserID:      member.UserID,
+			Weight:      member.Weight,
+			FixedAmount: member.FixedAmount,
+			ItemsAmount: member.ItemsAmount,
+		})
+	}
+
+	shares, err := SplitCost(total, method, participants)
+	if err != nil {
+		return nil, err
+	}
+
+	settlement := &Settlement{
+		GroupID:     groupID,
+		Method:      method,
+		TotalAmount: total,
+		Shares:      make([]SettlementShare, 0, len(members)),
+	}
+	balances := make(map[int64]int64, len(members))
+	for i, member := range members {
+		share := SettlementShare{
+			UserID:      member.UserID,
+			DisplayName: member.DisplayName,
+			Share:       shares[i],
+			Paid:        paid[member.UserID],
+		}
+		share.Balance = share.Paid - share.Share
+		balances[member.UserID] = share.Balance
+		settlement.Shares = append(settlement.Shares, share)
+	}
+
+	// 立て替えたあとにグループを抜けた人にも精算で返す
+	var formerPayers []int64
+	for userID := range paid {
+		if _, ok := balances[userID]; !ok && paid[userID] != 0 {
+			formerPayers = append(formerPayers, userID)
+		}
+	}
+	sort.Slice(formerPayers, func(a, b int) bool { return formerPayers[a] < formerPayers[b] })
+	for _, userID := range formerPayers {
+		balances[userID] = paid[userID]
+		settlement.Shares = append(settlement.Shares, SettlementShare{
+			UserID:  userID,
+			Paid:    paid[userID],
+			Balance: paid[userID],
+		})
+	}
+	settlement.Transfers = SettleBalances(balances)
+
+	return settlement, nil
+}
+
+const (
+	SettlementSourceExpenses = "expenses"
+	SettlementSourceMenu     = "menu"
+)
+
+// GetSettlement はグループの精算を計算する｡
+// 立て替えの記録があればその合計を実際に払った人ごとに精算し､
+// なければ注文した料理の合計をオーナーが立て替えたものとして見積もる
+func (repo *Repository) GetSettlement(groupID int64, method SplitMethod) (*Settlement, error) {
+	members, err := repo.ListSettlementMembers(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	paid, err := repo.ListExpenseTotals(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, amount := range paid {
+		total += amount
+	}
+
+	source := SettlementSourceExpenses
+	if len(paid) == 0 {
+		source = SettlementSourceMenu
+
+		total, paid, err = menuPayments(members)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	settlement, err := BuildSettlement(groupID, method, total, members, paid)
+	if err != nil {
+		return nil, err
+	}
+	settlement.Source = source
+
+	return settlement, nil
+}
+
+// menuPayments は注文した料理の合計をオーナーが立て替えたものとして返す｡
+// オーナーが分担するメンバーにいなければ誰が払ったかわからないので ErrNoMenuPayer を返す
+func menuPayments(members []SettlementMember) (int64, map[int64]int64, error) {
+	var total int64
+	ownerID, found := int64(0), false
+	for _, member := range members {
+		total += member.ItemsAmount
+		if member.IsOwner && !found {
+			ownerID, found = member.UserID, true
+		}
+	}
+
+	paid := map[int64]int64{}
+	if total == 0 {
+		return total, paid, nil
+	}
+	if !found {
+		return 0, nil, ErrNoMenuPayer
+	}
+	paid[ownerID] = total
+
+	return total, paid, nil
+}
+
+// ListSettlementMembers は費用を分担するメンバーを返す｡見るだけのメンバーは分担しないので含めない
+func (repo *Repository) ListSettlementMembers(groupID int64) ([]SettlementMember, error) {
+	query := `
+		SELECT
+			gm.user_id, u.display_name, gm.role = 'owner', gm.share_weight, gm.fixed_share,
+			COALESCE(SUM(mi.price * mo.quantity), 0)
+		FROM
+			group_members gm
+		JOIN
+			users u ON gm.user_id = u.id
+		LEFT JOIN
+			member_orders mo ON mo.group_member_id = gm.id
+		LEFT JOIN
+			menu_items mi ON mo.menu_item_id = mi.id
+		WHERE
+			gm.group_id = $1 AND gm.role <> 'viewer'
+		GROUP BY
+			gm.id, u.id
+		ORDER BY
+			gm.joined_at, gm.id
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []SettlementMember
+	for rows.Next() {
+		var member SettlementMember
+		var isOwner sql.NullBool
+		var fixedShare sql.NullInt64
+		err := rows.Scan(
+			&member.UserID,
+			&member.DisplayName,
+			&isOwner,
+			&member.Weight,
+			&fixedShare,
+			&member.ItemsAmount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		member.IsOwner = isOwner.Bool
+		if fixedShare.Valid {
+			member.FixedAmount = &fixedShare.Int64
+		}
+		members = append(members, member)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (repo *Repository) UpdateMemberShare(tx *sql.Tx, groupID, userID, weight int64, fixedAmount *int64) (bool, error) {
+	query := `
+		UPDATE
+			group_members
+		SET
+			share_weight = $1, fixed_share = $2
+		WHERE
+			group_id = $3 AND user_id = $4
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(weight, fixedAmount, groupID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
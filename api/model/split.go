@@ -0,0 +1,264 @@
+package model
+
+import (
+	"errors"
+	"math/bits"
+	"sort"
+)
+
+// SplitMethod はグループの費用を誰がいくら負担するかの決め方
+type SplitMethod string
+
+const (
+	// SplitEqual は全員で均等に割る
+	SplitEqual SplitMethod = "equal"
+	// SplitItems は各自が注文した料理の小計の比率で割る
+	SplitItems SplitMethod = "items"
+	// SplitFixed は固定額を決めたメンバーがその額を払い､残りを他のメンバーで均等に割る
+	SplitFixed SplitMethod = "fixed"
+	// SplitWeighted はメンバーごとの重みの比率で割る
+	SplitWeighted SplitMethod = "weighted"
+)
+
+var (
+	ErrUnknownSplitMethod     = errors.New("unknown split method")
+	ErrNoParticipants         = errors.New("no participants to split the cost")
+	ErrNegativeAmount         = errors.New("amount must not be negative")
+	ErrInvalidWeight          = errors.New("weights must not be negative and must not all be zero")
+	ErrNoOrderedItems         = errors.New("nobody has ordered anything to split the cost by")
+	ErrFixedSharesExceedTotal = errors.New("fixed shares exceed the total")
+	ErrFixedSharesMismatch    = errors.New("fixed shares do not add up to the total")
+	ErrNoMenuPayer            = errors.New("nobody is recorded as paying for the menu; record the expense instead")
+)
+
+// IsSplitError は設定の組み合わせが原因で分担を計算できなかったエラーかどうか
+func IsSplitError(err error) bool {
+	for _, target := range []error{
+		ErrNoParticipants,
+		ErrNegativeAmount,
+		ErrInvalidWeight,
+		ErrNoOrderedItems,
+		ErrFixedSharesExceedTotal,
+		ErrFixedSharesMismatch,
+		ErrNoMenuPayer,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSplitMethod は文字列を SplitMethod に変換する
+func ParseSplitMethod(s string) (SplitMethod, error) {
+	switch method := SplitMethod(s); method {
+	case SplitEqual, SplitItems, SplitFixed, SplitWeighted:
+		return method, nil
+	default:
+		return "", ErrUnknownSplitMethod
+	}
+}
+
+// SplitParticipant は費用を分担するメンバー1人分の入力
+type SplitParticipant struct {
+	UserID int64
+	// Weight は weighted のときの比率
+	Weight int64
+	// FixedAmount は fixed のときの負担額｡nil なら残りを均等に負担する
+	FixedAmount *int64
+	// ItemsAmount は items のときに使う､注文した料理の小計
+	ItemsAmount int64
+}
+
+// SplitCost は total 円を participants の順に分担額へ割り振る｡
+// 端数は最大剰余法で1円ずつ配るので､戻り値の合計は必ず total に一致する
+func SplitCost(total int64, method SplitMethod, participants []SplitParticipant) ([]int64, error) {
+	if total < 0 {
+		return nil, ErrNegativeAmount
+	}
+	if len(participants) == 0 {
+		return nil, ErrNoParticipants
+	}
+
+	weights := make([]int64, len(participants))
+	switch method {
+	case SplitEqual:
+		for i := range participants {
+			weights[i] = 1
+		}
+		return allocate(total, weights)
+
+	case SplitWeighted:
+		for i, p := range participants {
+			weights[i] = p.Weight
+		}
+		return allocate(total, weights)
+
+	case SplitItems:
+		var ordered int64
+		for i, p := range participants {
+			if p.ItemsAmount < 0 {
+				return nil, ErrNegativeAmount
+			}
+			weights[i] = p.ItemsAmount
+			ordered += p.ItemsAmount
+		}
+		if ordered == 0 {
+			if total == 0 {
+				return make([]int64, len(participants)), nil
+			}
+			return nil, ErrNoOrderedItems
+		}
+		return allocate(total, weights)
+
+	case SplitFixed:
+		return splitFixed(total, participants)
+
+	default:
+		return nil, ErrUnknownSplitMethod
+	}
+}
+
+func splitFixed(total int64, participants []SplitParticipant) ([]int64, error) {
+	shares := make([]int64, len(participants))
+	var fixedTotal int64
+	var restIndexes []int
+	for i, p := range participants {
+		if p.FixedAmount == nil {
+			restIndexes = append(restIndexes, i)
+			continue
+		}
+		if *p.FixedAmount < 0 {
+			return nil, ErrNegativeAmount
+		}
+		shares[i] = *p.FixedAmount
+		fixedTotal += *p.FixedAmount
+	}
+
+	if fixedTotal > total {
+		return nil, ErrFixedSharesExceedTotal
+	}
+
+	rest := total - fixedTotal
+	if len(restIndexes) == 0 {
+		if rest != 0 {
+			return nil, ErrFixedSharesMismatch
+		}
+		return shares, nil
+	}
+
+	weights := make([]int64, len(restIndexes))
+	for i := range weights {
+		weights[i] = 1
+	}
+	restShares, err := allocate(rest, weights)
+	if err != nil {
+		return nil, err
+	}
+	for i, index := range restIndexes {
+		shares[index] = restShares[i]
+	}
+
+	return shares, nil
+}
+
+// allocate は total を weights の比率で整数に割り振る(最大剰余法)｡
+// 剰余が同じ場合は先に並んでいる方に1円を配る
+func allocate(total int64, weights []int64) ([]int64, error) {
+	var weightSum uint64
+	for _, w := range weights {
+		if w < 0 {
+			return nil, ErrInvalidWeight
+		}
+		weightSum += uint64(w)
+	}
+	if weightSum == 0 {
+		return nil, ErrInvalidWeight
+	}
+
+	shares := make([]int64, len(weights))
+	remainders := make([]uint64, len(weights))
+	var allocated int64
+	for i, w := range weights {
+		// total * w がint64に収まらなくても正しく計算できるように128bitで計算する
+		hi, lo := bits.Mul64(uint64(total), uint64(w))
+		quo, rem := bits.Div64(hi, lo, weightSum)
+		shares[i] = int64(quo)
+		remainders[i] = rem
+		allocated += shares[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+
+	// 切り捨てた分は参加者数未満なので､剰余の大きい順に1円ずつ配れば必ず配り切れる
+	for i := 0; allocated < total; i++ {
+		shares[order[i]]++
+		allocated++
+	}
+
+	return shares, nil
+}
+
+// Transfer は精算のための送金1件
+type Transfer struct {
+	FromUserID int64 `json:"from_user_id"`
+	ToUserID   int64 `json:"to_user_id"`
+	Amount     int64 `json:"amount"`
+}
+
+// SettleBalances は各ユーザーの収支(プラスは受け取る側)から送金の一覧を作る｡
+// 受け取る額と払う額が大きい順に突き合わせるので､送金は最大でも人数-1件になる
+func SettleBalances(balances map[int64]int64) []Transfer {
+	type balance struct {
+		userID int64
+		amount int64
+	}
+
+	var creditors, debtors []balance
+	for userID, amount := range balances {
+		switch {
+		case amount > 0:
+			creditors = append(creditors, balance{userID, amount})
+		case amount < 0:
+			debtors = append(debtors, balance{userID, -amount})
+		}
+	}
+
+	byAmount := func(list []balance) func(a, b int) bool {
+		return func(a, b int) bool {
+			if list[a].amount != list[b].amount {
+				return list[a].amount > list[b].amount
+			}
+			return list[a].userID < list[b].userID
+		}
+	}
+	sort.Slice(creditors, byAmount(creditors))
+	sort.Slice(debtors, byAmount(debtors))
+
+	transfers := []Transfer{}
+	for c, d := 0, 0; c < len(creditors) && d < len(debtors); {
+		amount := min(creditors[c].amount, debtors[d].amount)
+		transfers = append(transfers, Transfer{
+			FromUserID: debtors[d].userID,
+			ToUserID:   creditors[c].userID,
+			Amount:     amount,
+		})
+
+		creditors[c].amount -= amount
+		debtors[d].amount -= amount
+		if creditors[c].amount == 0 {
+			c++
+		}
+		if debtors[d].amount == 0 {
+			d++
+		}
+	}
+
+	return transfers
+}
@@ -0,0 +1,392 @@
+package model
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func TestSplitCost(t *testing.T) {
+	tests := []struct {
+		name         string
+		total        int64
+		method       SplitMethod
+		participants []SplitParticipant
+		want         []int64
+		wantErr      error
+	}{
+		{
+			name:         "equal split divides evenly",
+			total:        3000,
+			method:       SplitEqual,
+			participants: []SplitParticipant{{UserID: 1}, {UserID: 2}, {UserID: 3}},
+			want:         []int64{1000, 1000, 1000},
+		},
+		{
+			name:         "equal split gives leftover yen to earlier participants",
+			total:        1000,
+			method:       SplitEqual,
+			participants: []SplitParticipant{{UserID: 1}, {UserID: 2}, {UserID: 3}},
+			want:         []int64{334, 333, 333},
+		},
+		{
+			name:         "equal split with two leftover yen",
+			total:        1001,
+			method:       SplitEqual,
+			participants: []SplitParticipant{{UserID: 1}, {UserID: 2}, {UserID: 3}},
+			want:         []int64{334, 334, 333},
+		},
+		{
+			name:         "equal split of zero",
+			total:        0,
+			method:       SplitEqual,
+			participants: []SplitParticipant{{UserID: 1}, {UserID: 2}},
+			want:         []int64{0, 0},
+		},
+		{
+			name:   "items split proportional to ordered amount",
+			total:  3000,
+			method: SplitItems,
+			participants: []SplitParticipant{
+				{UserID: 1, ItemsAmount: 1800},
+				{UserID: 2, ItemsAmount: 1200},
+				{UserID: 3, ItemsAmount: 0},
+			},
+			want: []int64{1800, 1200, 0},
+		},
+		{
+			name:   "items split rounds by largest remainder",
+			total:  1000,
+			method: SplitItems,
+			participants: []SplitParticipant{
+				{UserID: 1, ItemsAmount: 100},
+				{UserID: 2, ItemsAmount: 200},
+			},
+			// 333.33... と 666.66... なので剰余の大きい2人目に1円
+			want: []int64{333, 667},
+		},
+		{
+			name:   "items split with nothing ordered",
+			total:  1000,
+			method: SplitItems,
+			participants: []SplitParticipant{
+				{UserID: 1},
+				{UserID: 2},
+			},
+			wantErr: ErrNoOrderedItems,
+		},
+		{
+			name:   "weighted split",
+			total:  1000,
+			method: SplitWeighted,
+			participants: []SplitParticipant{
+				{UserID: 1, Weight: 2},
+				{UserID: 2, Weight: 1},
+				{UserID: 3, Weight: 1},
+			},
+			want: []int64{500, 250, 250},
+		},
+		{
+			name:   "weighted split with remainder",
+			total:  100,
+			method: SplitWeighted,
+			participants: []SplitParticipant{
+				{UserID: 1, Weight: 1},
+				{UserID: 2, Weight: 1},
+				{UserID: 3, Weight: 1},
+				{UserID: 4, Weight: 0},
+			},
+			want: []int64{34, 33, 33, 0},
+		},
+		{
+			name:   "weighted split with all zero weights",
+			total:  100,
+			method: SplitWeighted,
+			participants: []SplitParticipant{
+				{UserID: 1, Weight: 0},
+				{UserID: 2, Weight: 0},
+			},
+			wantErr: ErrInvalidWeight,
+		},
+		{
+			name:   "weighted split with negative weight",
+			total:  100,
+			method: SplitWeighted,
+			participants: []SplitParticipant{
+				{UserID: 1, Weight: -1},
+				{UserID: 2, Weight: 2},
+			},
+			wantErr: ErrInvalidWeight,
+		},
+		{
+			name:   "fixed shares with the rest split equally",
+			total:  1000,
+			method: SplitFixed,
+			participants: []SplitParticipant{
+				{UserID: 1, FixedAmount: int64Ptr(500)},
+				{UserID: 2},
+				{UserID: 3},
+				{UserID: 4},
+			},
+			want: []int64{500, 167, 167, 166},
+		},
+		{
+			name:   "all fixed shares matching the total",
+			total:  1000,
+			method: SplitFixed,
+			participants: []SplitParticipant{
+				{UserID: 1, FixedAmount: int64Ptr(400)},
+				{UserID: 2, FixedAmount: int64Ptr(600)},
+			},
+			want: []int64{400, 600},
+		},
+		{
+			name:   "all fixed shares not matching the total",
+			total:  1000,
+			method: SplitFixed,
+			participants: []SplitParticipant{
+				{UserID: 1, FixedAmount: int64Ptr(400)},
+				{UserID: 2, FixedAmount: int64Ptr(500)},
+			},
+			wantErr: ErrFixedSharesMismatch,
+		},
+		{
+			name:   "fixed shares exceeding the total",
+			total:  1000,
+			method: SplitFixed,
+			participants: []SplitParticipant{
+				{UserID: 1, FixedAmount: int64Ptr(1200)},
+				{UserID: 2},
+			},
+			wantErr: ErrFixedSharesExceedTotal,
+		},
+		{
+			name:    "no participants",
+			total:   1000,
+			method:  SplitEqual,
+			wantErr: ErrNoParticipants,
+		},
+		{
+			name:         "negative total",
+			total:        -1,
+			method:       SplitEqual,
+			participants: []SplitParticipant{{UserID: 1}},
+			wantErr:      ErrNegativeAmount,
+		},
+		{
+			name:         "unknown method",
+			total:        1000,
+			method:       SplitMethod("random"),
+			participants: []SplitParticipant{{UserID: 1}},
+			wantErr:      ErrUnknownSplitMethod,
+		},
+		{
+			name:   "large amounts do not overflow",
+			total:  9_000_000_000_000_000,
+			method: SplitWeighted,
+			participants: []SplitParticipant{
+				{UserID: 1, Weight: 3_000_000_000},
+				{UserID: 2, Weight: 6_000_000_000},
+			},
+			want: []int64{3_000_000_000_000_000, 6_000_000_000_000_000},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitCost(tt.total, tt.method, tt.participants)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("SplitCost() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SplitCost() unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitCost() = %v, want %v", got, tt.want)
+			}
+
+			var sum int64
+			for _, share := range got {
+				sum += share
+			}
+			if sum != tt.total {
+				t.Errorf("shares add up to %d, want %d", sum, tt.total)
+			}
+		})
+	}
+}
+
+func TestSplitCostAlwaysAddsUp(t *testing.T) {
+	for total := int64(0); total <= 200; total++ {
+		for n := 1; n <= 7; n++ {
+			participants := make([]SplitParticipant, n)
+			for i := range participants {
+				participants[i] = SplitParticipant{
+					UserID:      int64(i + 1),
+					Weight:      int64(i%3 + 1),
+					ItemsAmount: int64(i*137%500 + 1),
+				}
+			}
+
+			for _, method := range []SplitMethod{SplitEqual, SplitItems, SplitWeighted, SplitFixed} {
+				shares, err := SplitCost(total, method, participants)
+				if err != nil {
+					t.Fatalf("SplitCost(%d, %s, %d participants) unexpected error: %v", total, method, n, err)
+				}
+
+				var sum int64
+				for _, share := range shares {
+					if share < 0 {
+						t.Fatalf("SplitCost(%d, %s, %d participants) gave negative share %v", total, method, n, shares)
+					}
+					sum += share
+				}
+				if sum != total {
+					t.Fatalf("SplitCost(%d, %s, %d participants) = %v, adds up to %d", total, method, n, shares, sum)
+				}
+			}
+		}
+	}
+}
+
+func TestSettleBalances(t *testing.T) {
+	tests := []struct {
+		name     string
+		balances map[int64]int64
+		want     []Transfer
+	}{
+		{
+			name:     "nobody owes anything",
+			balances: map[int64]int64{1: 0, 2: 0},
+			want:     []Transfer{},
+		},
+		{
+			name:     "everyone pays the owner",
+			balances: map[int64]int64{1: 2000, 2: -1000, 3: -1000},
+			want: []Transfer{
+				{FromUserID: 2, ToUserID: 1, Amount: 1000},
+				{FromUserID: 3, ToUserID: 1, Amount: 1000},
+			},
+		},
+		{
+			name:     "several payers",
+			balances: map[int64]int64{1: 700, 2: 300, 3: -600, 4: -400},
+			want: []Transfer{
+				{FromUserID: 3, ToUserID: 1, Amount: 600},
+				{FromUserID: 4, ToUserID: 1, Amount: 100},
+				{FromUserID: 4, ToUserID: 2, Amount: 300},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SettleBalances(tt.balances)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SettleBalances() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildSettlement(t *testing.T) {
+	members := []SettlementMember{
+		{UserID: 1, DisplayName: "owner", IsOwner: true, Weight: 1, ItemsAmount: 1200},
+		{UserID: 2, DisplayName: "a", Weight: 1, ItemsAmount: 800},
+		{UserID: 3, DisplayName: "b", Weight: 1, ItemsAmount: 1000},
+	}
+
+	settlement, err := BuildSettlement(10, SplitItems, 3000, members, map[int64]int64{1: 3000})
+	if err != nil {
+		t.Fatalf("BuildSettlement() unexpected error: %v", err)
+	}
+
+	wantShares := []SettlementShare{
+		{UserID: 1, DisplayName: "owner", Share: 1200, Paid: 3000, Balance: 1800},
+		{UserID: 2, DisplayName: "a", Share: 800, Paid: 0, Balance: -800},
+		{UserID: 3, DisplayName: "b", Share: 1000, Paid: 0, Balance: -1000},
+	}
+	if !reflect.DeepEqual(settlement.Shares, wantShares) {
+		t.Errorf("Shares = %v, want %v", settlement.Shares, wantShares)
+	}
+
+	wantTransfers := []Transfer{
+		{FromUserID: 3, ToUserID: 1, Amount: 1000},
+		{FromUserID: 2, ToUserID: 1, Amount: 800},
+	}
+	if !reflect.DeepEqual(settlement.Transfers, wantTransfers) {
+		t.Errorf("Transfers = %v, want %v", settlement.Transfers, wantTransfers)
+	}
+}
+
+func TestBuildSettlementWithSeveralPayers(t *testing.T) {
+	members := []SettlementMember{
+		{UserID: 1, DisplayName: "owner", IsOwner: true, Weight: 1},
+		{UserID: 2, DisplayName: "a", Weight: 1},
+		{UserID: 3, DisplayName: "b", Weight: 1},
+	}
+	// 1が2000円､2が1001円を立て替え､4は立て替えたあとに抜けた
+	paid := map[int64]int64{1: 2000, 2: 1001, 4: 300}
+
+	settlement, err := BuildSettlement(10, SplitEqual, 3301, members, paid)
+	if err != nil {
+		t.Fatalf("BuildSettlement() unexpected error: %v", err)
+	}
+
+	wantShares := []SettlementShare{
+		{UserID: 1, DisplayName: "owner", Share: 1101, Paid: 2000, Balance: 899},
+		{UserID: 2, DisplayName: "a", Share: 1100, Paid: 1001, Balance: -99},
+		{UserID: 3, DisplayName: "b", Share: 1100, Paid: 0, Balance: -1100},
+		{UserID: 4, Share: 0, Paid: 300, Balance: 300},
+	}
+	if !reflect.DeepEqual(settlement.Shares, wantShares) {
+		t.Errorf("Shares = %v, want %v", settlement.Shares, wantShares)
+	}
+
+	var received, sent int64
+	for _, transfer := range settlement.Transfers {
+		if transfer.ToUserID == 1 || transfer.ToUserID == 4 {
+			received += transfer.Amount
+		}
+		if transfer.FromUserID == 2 || transfer.FromUserID == 3 {
+			sent += transfer.Amount
+		}
+	}
+	if received != 1199 || sent != 1199 {
+		t.Errorf("Transfers = %v, want 1199 yen moved from 2 and 3 to 1 and 4", settlement.Transfers)
+	}
+}
+
+func TestMenuPayments(t *testing.T) {
+	members := []SettlementMember{
+		{UserID: 2, ItemsAmount: 800},
+		{UserID: 1, IsOwner: true, ItemsAmount: 1200},
+	}
+
+	total, paid, err := menuPayments(members)
+	if err != nil {
+		t.Fatalf("menuPayments() unexpected error: %v", err)
+	}
+	if total != 2000 || !reflect.DeepEqual(paid, map[int64]int64{1: 2000}) {
+		t.Errorf("menuPayments() = %d, %v, want 2000, map[1:2000]", total, paid)
+	}
+
+	// オーナーの行がなければ払った人がいない
+	_, _, err = menuPayments(members[:1])
+	if !errors.Is(err, ErrNoMenuPayer) {
+		t.Errorf("menuPayments() without owner error = %v, want %v", err, ErrNoMenuPayer)
+	}
+
+	total, paid, err = menuPayments([]SettlementMember{{UserID: 2}})
+	if err != nil || total != 0 || len(paid) != 0 {
+		t.Errorf("menuPayments() with nothing ordered = %d, %v, %v, want 0, empty, nil", total, paid, err)
+	}
+}
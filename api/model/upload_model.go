@@ -0,0 +1,209 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"domeal/authz"
+	"time"
+)
+
+type UploadInterface interface {
+	GetGroup(groupID int64) (*Group, error)
+	GetMemberRole(groupID, userID int64) (authz.Role, bool, error)
+	CreateUpload(tx *sql.Tx, upload *Upload) error
+	GetUpload(id string) (*Upload, error)
+	LockUpload(tx *sql.Tx, id string) (*Upload, error)
+	MarkUploadAttached(tx *sql.Tx, id string, groupID int64) error
+	LockGroup(tx *sql.Tx, groupID int64) (*Group, error)
+	UpdateGroupMenuImage(tx *sql.Tx, groupID int64, menuImageURL string) error
+	CreateMedia(tx *sql.Tx, media *Media) error
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+const (
+	// UploadStatusPending はURLを発行しただけで､まだグループに付けていない
+	UploadStatusPending = "pending"
+	// UploadStatusAttached はグループの画像として確定した
+	UploadStatusAttached = "attached"
+)
+
+// Upload はブラウザからストレージに直接アップロードされるファイル
+type Upload struct {
+	ID          string
+	UserID      int64
+	ObjectKey   string
+	ContentType string
+	Size        int64
+	Status      string
+	GroupID     *int64
+	// ExpiresAt までに確定されなければ掃除される
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+func (repo *Repository) CreateUpload(tx *sql.Tx, upload *Upload) error {
+	query := `
+		INSERT INTO
+			uploads (user_id, object_key, content_type, size, expires_at, created_at)
+		VALUES
+			($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+		RETURNING id, status, created_at
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(
+		upload.UserID,
+		upload.ObjectKey,
+		upload.ContentType,
+		upload.Size,
+		upload.ExpiresAt,
+	).Scan(&upload.ID, &upload.Status, &upload.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) GetUpload(id string) (*Upload, error) {
+	query := `
+		SELECT
+			id, user_id, object_key, content_type, size, status, group_id, expires_at, created_at
+		FROM
+			uploads
+		WHERE
+			id = $1
+	`
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	return scanUpload(stmt.QueryRow(id))
+}
+
+// LockUpload は確定と掃除が重ならないようにアップロードの行をロックして取得する
+func (repo *Repository) LockUpload(tx *sql.Tx, id string) (*Upload, error) {
+	query := `
+		SELECT
+			id, user_id, object_key, content_type, size, status, group_id, expires_at, created_at
+		FROM
+			uploads
+		WHERE
+			id = $1
+		FOR UPDATE
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	return scanUpload(stmt.QueryRow(id))
+}
+
+func (repo *Repository) MarkUploadAttached(tx *sql.Tx, id string, groupID int64) error {
+	query := `
+		UPDATE
+			uploads
+		SET
+			status = 'attached', group_id = $2, attached_at = CURRENT_TIMESTAMP
+		WHERE
+			id = $1
+	`
+
+	_, err := tx.Exec(query, id, groupID)
+	return err
+}
+
+// ListExpiredUploads は期限までに確定されなかったアップロードを古い順にロックして返す｡
+// ほかのレプリカがロックしている行は飛ばす
+func (repo *Repository) ListExpiredUploads(tx *sql.Tx, now time.Time, limit int) ([]Upload, error) {
+	query := `
+		SELECT
+			id, user_id, object_key, content_type, size, status, group_id, expires_at, created_at
+		FROM
+			uploads
+		WHERE
+			status = 'pending' AND expires_at < $1
+		ORDER BY
+			expires_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`
+
+	rows, err := tx.Query(query, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []Upload
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, *upload)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return uploads, nil
+}
+
+func (repo *Repository) DeleteUpload(tx *sql.Tx, id string) error {
+	_, err := tx.Exec("DELETE FROM uploads WHERE id = $1", id)
+	return err
+}
+
+// UpdateGroupMenuImage は料理の画像だけを差し替える
+func (repo *Repository) UpdateGroupMenuImage(tx *sql.Tx, groupID int64, menuImageURL string) error {
+	query := `
+		UPDATE
+			groups
+		SET
+			menu_image_url = $2
+		WHERE
+			id = $1 AND deleted_at IS NULL
+	`
+
+	_, err := tx.Exec(query, groupID, menuImageURL)
+	return err
+}
+
+func scanUpload(row rowScanner) (*Upload, error) {
+	var upload Upload
+	var groupID sql.NullInt64
+	err := row.Scan(
+		&upload.ID,
+		&upload.UserID,
+		&upload.ObjectKey,
+		&upload.ContentType,
+		&upload.Size,
+		&upload.Status,
+		&groupID,
+		&upload.ExpiresAt,
+		&upload.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if groupID.Valid {
+		upload.GroupID = &groupID.Int64
+	}
+
+	return &upload, nil
+}
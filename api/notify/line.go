@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultLineEndpoint は LINE Messaging API のプッシュメッセージのエンドポイント
+const DefaultLineEndpoint = "https://api.line.me/v2/bot/message/push"
+
+// LineNotifier は LINE Messaging API でプッシュメッセージを送る｡
+// 宛先の users.line_sub は LINEログインのチャネルと同じプロバイダーの Messaging API チャネルでのみ使える
+type LineNotifier struct {
+	Endpoint           string
+	ChannelAccessToken string
+	Client             *http.Client
+}
+
+func NewLineNotifier(channelAccessToken string) *LineNotifier {
+	return &LineNotifier{
+		Endpoint:           DefaultLineEndpoint,
+		ChannelAccessToken: channelAccessToken,
+		Client:             &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type linePushRequest struct {
+	To       string            `json:"to"`
+	Messages []lineTextMessage `json:"messages"`
+}
+
+type lineTextMessage struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func (n *LineNotifier) Push(ctx context.Context, to, text, retryKey string) error {
+	body, err := json.Marshal(linePushRequest{
+		To:       to,
+		Messages: []lineTextMessage{{Type: "text", Text: text}},
+	})
+	if err != nil {
+		return &PermanentError{Err: err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return &PermanentError{Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+n.ChannelAccessToken)
+	if retryKey != "" {
+		req.Header.Set("X-Line-Retry-Key", retryKey)
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// エラーの中身はログに残す程度なので先頭だけ読む
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusConflict:
+		// 同じ retry key のリクエストはすでに受け付けられている
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("line push failed: status %d: %s", resp.StatusCode, respBody)
+	default:
+		return &PermanentError{Err: fmt.Errorf("line push rejected: status %d: %s", resp.StatusCode, respBody)}
+	}
+}
@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLineNotifierPush(t *testing.T) {
+	var got linePushRequest
+	var gotAuth, gotRetryKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotRetryKey = r.Header.Get("X-Line-Retry-Key")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	notifier := NewLineNotifier("token")
+	notifier.Endpoint = server.URL
+
+	err := notifier.Push(context.Background(), "U123", "hello", "123e4567-e89b-12d3-a456-426614174000")
+	if err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+
+	if gotAuth != "Bearer token" {
+		t.Errorf("Authorization = %q", gotAuth)
+	}
+	if gotRetryKey != "123e4567-e89b-12d3-a456-426614174000" {
+		t.Errorf("X-Line-Retry-Key = %q", gotRetryKey)
+	}
+	if got.To != "U123" || len(got.Messages) != 1 || got.Messages[0].Type != "text" || got.Messages[0].Text != "hello" {
+		t.Errorf("request = %+v", got)
+	}
+}
+
+func TestLineNotifierPushErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		wantErr   bool
+		permanent bool
+	}{
+		{http.StatusOK, false, false},
+		// 同じ retry key で受け付け済み
+		{http.StatusConflict, false, false},
+		{http.StatusBadRequest, true, true},
+		{http.StatusForbidden, true, true},
+		{http.StatusTooManyRequests, true, false},
+		{http.StatusInternalServerError, true, false},
+	}
+
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte(`{"message":"error"}`))
+		}))
+
+		notifier := NewLineNotifier("token")
+		notifier.Endpoint = server.URL
+		err := notifier.Push(context.Background(), "U123", "hello", "")
+		server.Close()
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("status %d: error = %v, want error %v", tt.status, err, tt.wantErr)
+			continue
+		}
+		if err != nil && IsPermanent(err) != tt.permanent {
+			t.Errorf("status %d: IsPermanent = %v, want %v", tt.status, IsPermanent(err), tt.permanent)
+		}
+	}
+}
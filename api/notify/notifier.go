@@ -0,0 +1,31 @@
+package notify
+
+import (
+	"context"
+	"errors"
+)
+
+// Notifier はユーザーにメッセージを届ける
+type Notifier interface {
+	// Push は to にテキストを送る｡retryKey が同じ送信は相手側で重複として扱われる
+	Push(ctx context.Context, to, text, retryKey string) error
+}
+
+// PermanentError は再送しても届かない失敗｡宛先が存在しない､リクエストが不正など
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// IsPermanent は err が再送しても無駄な失敗かどうかを返す
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
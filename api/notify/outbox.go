@@ -0,0 +1,138 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"domeal/jobs"
+	"domeal/model"
+	"encoding/json"
+	"log/slog"
+)
+
+// Message はアウトボックスに積む通知｡Params は種類ごとの文面に埋める値
+type Message struct {
+	UserID int64
+	Kind   string
+	Params map[string]any
+}
+
+// Enqueuer はグループの変更と同じトランザクションで通知を積む
+type Enqueuer interface {
+	Enqueue(tx *sql.Tx, message Message) error
+}
+
+// OutboxStore はアウトボックスの永続化に使う
+type OutboxStore interface {
+	EnqueueOutboxMessage(tx *sql.Tx, message *model.OutboxMessage) (bool, error)
+	GetOutboxMessage(tx *sql.Tx, id int64) (*model.OutboxMessage, error)
+	MarkOutboxSent(tx *sql.Tx, id int64) error
+	MarkOutboxFailed(tx *sql.Tx, id int64, lastError string) error
+}
+
+// SendPayload はアウトボックスの通知を1件送るジョブのペイロード
+type SendPayload struct {
+	OutboxID int64 `json:"outbox_id"`
+}
+
+// SendKind はアウトボックスの通知を送るジョブ｡失敗したらジョブキューが間隔を空けて再送する
+var SendKind = jobs.NewKind[SendPayload]("send_notification")
+
+type Outbox struct {
+	store OutboxStore
+	jobs  jobs.Enqueuer
+}
+
+func NewOutbox(store OutboxStore, jobs jobs.Enqueuer) *Outbox {
+	return &Outbox{
+		store: store,
+		jobs:  jobs,
+	}
+}
+
+// Enqueue は通知を積み､送るジョブを同じトランザクションで予約する｡
+// ユーザーがその種類を止めていれば何もしない｡文面を作れない通知は積む前にエラーにする
+func (o *Outbox) Enqueue(tx *sql.Tx, message Message) error {
+	params, err := json.Marshal(message.Params)
+	if err != nil {
+		return err
+	}
+
+	if _, err := Render(message.Kind, params); err != nil {
+		return err
+	}
+
+	outboxMessage := &model.OutboxMessage{
+		UserID: message.UserID,
+		Kind:   message.Kind,
+		Params: params,
+	}
+	enqueued, err := o.store.EnqueueOutboxMessage(tx, outboxMessage)
+	if err != nil || !enqueued {
+		return err
+	}
+
+	return SendKind.Enqueue(o.jobs, tx, SendPayload{OutboxID: outboxMessage.ID})
+}
+
+// Sender は SendKind のジョブを処理する
+type Sender struct {
+	store    OutboxStore
+	notifier Notifier
+}
+
+func NewSender(store OutboxStore, notifier Notifier) *Sender {
+	return &Sender{
+		store:    store,
+		notifier: notifier,
+	}
+}
+
+// Register は通知を送るジョブのハンドラーを登録する
+func (s *Sender) Register(runner *jobs.Runner) {
+	jobs.Register(runner, SendKind, s.Send)
+}
+
+// Send は通知を1件送る｡届かない宛先や文面はあきらめて failed にし､
+// 一時的な失敗はエラーを返してジョブキューに再送させる｡retry key があるので再送しても重複しない
+func (s *Sender) Send(ctx context.Context, tx *sql.Tx, payload SendPayload) error {
+	message, err := s.store.GetOutboxMessage(tx, payload.OutboxID)
+	if err == sql.ErrNoRows {
+		// ユーザーが退会して消えた
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if message.Status != model.OutboxStatusPending {
+		return nil
+	}
+
+	text, err := Render(message.Kind, message.Params)
+	if err != nil {
+		// 文面を作れない通知は何度やっても送れない
+		err = &PermanentError{Err: err}
+	} else {
+		err = s.notifier.Push(ctx, message.LineSub, text, message.RetryKey)
+	}
+
+	if err == nil {
+		slog.Info("Notification sent", "id", message.ID, "user_id", message.UserID, "kind", message.Kind)
+		return s.store.MarkOutboxSent(tx, message.ID)
+	}
+
+	if IsPermanent(err) {
+		slog.Error("Giving up notification", "error", err, "id", message.ID, "user_id", message.UserID)
+		return s.store.MarkOutboxFailed(tx, message.ID, err.Error())
+	}
+
+	return err
+}
+
+// LogNotifier は送らずにログに出すだけの Notifier｡LINE のトークンがない開発環境で使う
+type LogNotifier struct{}
+
+func (LogNotifier) Push(ctx context.Context, to, text, retryKey string) error {
+	slog.Info("Push notification (not sent)", "to", to, "text", text)
+	return nil
+}
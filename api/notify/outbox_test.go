@@ -0,0 +1,165 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"domeal/model"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeOutboxStore はトランザクションを使わずにメモリ上で通知を持つ
+type fakeOutboxStore struct {
+	messages map[int64]*model.OutboxMessage
+}
+
+func (s *fakeOutboxStore) EnqueueOutboxMessage(tx *sql.Tx, message *model.OutboxMessage) (bool, error) {
+	message.ID = int64(len(s.messages) + 1)
+	message.Status = model.OutboxStatusPending
+	s.messages[message.ID] = message
+	return true, nil
+}
+
+func (s *fakeOutboxStore) GetOutboxMessage(tx *sql.Tx, id int64) (*model.OutboxMessage, error) {
+	message, ok := s.messages[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *message
+	return &copied, nil
+}
+
+func (s *fakeOutboxStore) MarkOutboxSent(tx *sql.Tx, id int64) error {
+	s.messages[id].Status = model.OutboxStatusSent
+	return nil
+}
+
+func (s *fakeOutboxStore) MarkOutboxFailed(tx *sql.Tx, id int64, lastError string) error {
+	s.messages[id].Status = model.OutboxStatusFailed
+	return nil
+}
+
+// fakeJobQueue は積まれたジョブを覚えておく
+type fakeJobQueue struct {
+	jobs []*model.Job
+}
+
+func (q *fakeJobQueue) EnqueueJob(tx *sql.Tx, job *model.Job) error {
+	q.jobs = append(q.jobs, job)
+	return nil
+}
+
+func TestOutboxEnqueue(t *testing.T) {
+	store := &fakeOutboxStore{messages: map[int64]*model.OutboxMessage{}}
+	queue := &fakeJobQueue{}
+	outbox := NewOutbox(store, queue)
+
+	err := outbox.Enqueue(nil, Message{
+		UserID: 1,
+		Kind:   KindGroupFull,
+		Params: map[string]any{"group_name": "ランチ", "max_members": 5},
+	})
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	if len(queue.jobs) != 1 || queue.jobs[0].Kind != SendKind.Name() || string(queue.jobs[0].Payload) != `{"outbox_id":1}` {
+		t.Errorf("jobs = %+v", queue.jobs)
+	}
+
+	// 文面を作れない通知は積まない
+	if err := outbox.Enqueue(nil, Message{UserID: 1, Kind: KindPaymentDue, Params: map[string]any{}}); err == nil {
+		t.Error("Enqueue() with missing params should fail")
+	}
+	if len(store.messages) != 1 || len(queue.jobs) != 1 {
+		t.Errorf("messages = %d, jobs = %d, want 1 each", len(store.messages), len(queue.jobs))
+	}
+}
+
+func TestSenderSend(t *testing.T) {
+	// 宛先ごとに LINE の応答を変える
+	var texts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req linePushRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		switch req.To {
+		case "ok":
+			texts = append(texts, req.Messages[0].Text)
+			w.WriteHeader(http.StatusOK)
+		case "unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	notifier := NewLineNotifier("token")
+	notifier.Endpoint = server.URL
+
+	params := []byte(`{"group_name":"ランチ","max_members":5}`)
+	store := &fakeOutboxStore{messages: map[int64]*model.OutboxMessage{
+		1: {ID: 1, LineSub: "ok", Kind: KindGroupFull, Params: params, Status: model.OutboxStatusPending},
+		2: {ID: 2, LineSub: "unavailable", Kind: KindGroupFull, Params: params, Status: model.OutboxStatusPending},
+		3: {ID: 3, LineSub: "bad", Kind: KindGroupFull, Params: params, Status: model.OutboxStatusPending},
+		// 文面を作れない
+		4: {ID: 4, LineSub: "ok", Kind: KindPaymentDue, Params: []byte(`{"group_name":"ランチ"}`), Status: model.OutboxStatusPending},
+		// 送信済みのものは送り直さない
+		5: {ID: 5, LineSub: "ok", Kind: KindGroupFull, Params: params, Status: model.OutboxStatusSent},
+	}}
+	sender := NewSender(store, notifier)
+
+	tests := []struct {
+		id         int64
+		wantErr    bool
+		wantStatus string
+	}{
+		{1, false, model.OutboxStatusSent},
+		// ジョブキューに再送させる
+		{2, true, model.OutboxStatusPending},
+		{3, false, model.OutboxStatusFailed},
+		{4, false, model.OutboxStatusFailed},
+		{5, false, model.OutboxStatusSent},
+		// 退会などで消えた
+		{6, false, ""},
+	}
+
+	for _, tt := range tests {
+		err := sender.Send(context.Background(), nil, SendPayload{OutboxID: tt.id})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Send(%d) error = %v, want error %v", tt.id, err, tt.wantErr)
+		}
+		if message, ok := store.messages[tt.id]; ok && message.Status != tt.wantStatus {
+			t.Errorf("Send(%d) status = %q, want %q", tt.id, message.Status, tt.wantStatus)
+		}
+	}
+
+	if len(texts) != 1 || texts[0] != "「ランチ」のメンバーが定員の5人に達しました。" {
+		t.Errorf("texts = %q", texts)
+	}
+}
+
+func TestRender(t *testing.T) {
+	text, err := Render(KindPaymentDue, []byte(`{"group_name":"ランチ","payee_name":"たろう","amount":1200000,"paypal_me_url":"https://paypal.me/taro/1200000JPY"}`))
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	want := "「ランチ」の精算です。たろうさんに1200000円を支払ってください。\nhttps://paypal.me/taro/1200000JPY"
+	if text != want {
+		t.Errorf("Render() = %q, want %q", text, want)
+	}
+
+	if _, err := Render(KindPaymentDue, []byte(`{"group_name":"ランチ"}`)); err == nil || !strings.Contains(err.Error(), "payee_name") {
+		t.Errorf("Render() with missing params error = %v", err)
+	}
+
+	if _, err := Render("unknown", nil); err == nil {
+		t.Error("Render() with unknown kind should fail")
+	}
+}
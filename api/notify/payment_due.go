@@ -0,0 +1,59 @@
+package notify
+
+import (
+	"database/sql"
+	"domeal/model"
+	"log/slog"
+)
+
+// SettlementSource は精算の通知を組み立てるのに使う
+type SettlementSource interface {
+	GetSettlement(groupID int64, method model.SplitMethod) (*model.Settlement, error)
+	ListGroupPayPalMeUsernames(groupID int64) (map[int64]string, error)
+}
+
+// EnqueuePaymentDue は精算で支払う側に金額と支払い先を知らせる｡
+// 分け方の設定が足りず精算できない場合は知らせない
+func EnqueuePaymentDue(tx *sql.Tx, notifications Enqueuer, source SettlementSource, group *model.Group) error {
+	settlement, err := source.GetSettlement(group.ID, group.SplitMethod)
+	if err != nil {
+		if model.IsSplitError(err) {
+			slog.Warn("Skipping payment due notifications", "group_id", group.ID, "reason", err)
+			return nil
+		}
+		return err
+	}
+
+	payPalMeUsernames, err := source.ListGroupPayPalMeUsernames(group.ID)
+	if err != nil {
+		return err
+	}
+
+	displayNames := map[int64]string{}
+	for _, share := range settlement.Shares {
+		displayNames[share.UserID] = share.DisplayName
+	}
+
+	for _, transfer := range settlement.Transfers {
+		payPalMeURL := ""
+		if username, ok := payPalMeUsernames[transfer.ToUserID]; ok {
+			payPalMeURL = model.PayPalMeLink(username, transfer.Amount)
+		}
+
+		err := notifications.Enqueue(tx, Message{
+			UserID: transfer.FromUserID,
+			Kind:   KindPaymentDue,
+			Params: map[string]any{
+				"group_name":    group.Name,
+				"payee_name":    displayNames[transfer.ToUserID],
+				"amount":        transfer.Amount,
+				"paypal_me_url": payPalMeURL,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
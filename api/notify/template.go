@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// 通知の種類｡ユーザーは種類ごとに受け取らないようにできる
+const (
+	KindGroupFull           = "group_full"
+	KindDeadlineApproaching = "deadline_approaching"
+	KindPaymentDue          = "payment_due"
+)
+
+// Kinds は設定画面に出す通知の種類
+var Kinds = []string{
+	KindGroupFull,
+	KindDeadlineApproaching,
+	KindPaymentDue,
+}
+
+// templates は種類ごとの文面｡送るときに params を埋める｡
+// 足りないキーはエラーになるので､空でもすべてのキーを渡すこと
+var templates = map[string]*template.Template{
+	KindGroupFull: mustTemplate(KindGroupFull,
+		`「{{.group_name}}」のメンバーが定員の{{.max_members}}人に達しました。`),
+	KindDeadlineApproaching: mustTemplate(KindDeadlineApproaching,
+		`「{{.group_name}}」の注文の締め切りは{{.deadline}}です。まだの方はお早めに注文してください。`),
+	KindPaymentDue: mustTemplate(KindPaymentDue,
+		`「{{.group_name}}」の精算です。{{.payee_name}}さんに{{.amount}}円を支払ってください。`+
+			`{{if .paypal_me_url}}
+{{.paypal_me_url}}{{end}}`),
+}
+
+func mustTemplate(name, text string) *template.Template {
+	return template.Must(template.New(name).Option("missingkey=error").Parse(text))
+}
+
+// IsKind は kind が知っている通知の種類かどうかを返す
+func IsKind(kind string) bool {
+	_, ok := templates[kind]
+	return ok
+}
+
+// Render は種類ごとの文面に params を埋めたテキストを返す
+func Render(kind string, params json.RawMessage) (string, error) {
+	tmpl, ok := templates[kind]
+	if !ok {
+		return "", fmt.Errorf("unknown notification kind: %q", kind)
+	}
+
+	values := map[string]any{}
+	if len(params) > 0 {
+		// 金額が 1.2e+06 のように表示されないように数値は文字列のまま扱う
+		decoder := json.NewDecoder(bytes.NewReader(params))
+		decoder.UseNumber()
+		if err := decoder.Decode(&values); err != nil {
+			return "", fmt.Errorf("invalid params for %q: %w", kind, err)
+		}
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, values); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
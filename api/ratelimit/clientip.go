@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// ParseTrustedProxies はカンマ区切りの IP アドレスか CIDR を読む
+func ParseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if strings.Contains(field, "/") {
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", field, err)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", field, err)
+		}
+		addr = addr.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return proxies, nil
+}
+
+// TrustedProxiesFromEnv は TRUSTED_PROXIES から nginx のアドレスを読む｡
+// 設定がないか読めなければ X-Forwarded-For を信用しない
+func TrustedProxiesFromEnv() []netip.Prefix {
+	value := os.Getenv("TRUSTED_PROXIES")
+	if value == "" {
+		slog.Warn("TRUSTED_PROXIES is not set; X-Forwarded-For is ignored and clients are keyed by the proxy address")
+		return nil
+	}
+
+	proxies, err := ParseTrustedProxies(value)
+	if err != nil {
+		slog.Error("Failed to parse TRUSTED_PROXIES; X-Forwarded-For is ignored", "error", err)
+		return nil
+	}
+
+	return proxies
+}
+
+// ClientIP はリクエストを送ってきた人の IP アドレスを返す｡
+// X-Forwarded-For はクライアントが好きに書けるので､直接つないできたのが信用するプロキシのときだけ使い､
+// 右から見て最初の信用しないアドレスをクライアントとする
+func ClientIP(r *http.Request, trusted []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	remote = remote.Unmap()
+
+	if !isTrusted(remote, trusted) {
+		return remote.String()
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	client := remote
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			// 読めないアドレスより左は誰が書いたかわからないので使わない
+			break
+		}
+		client = addr.Unmap()
+		if !isTrusted(client, trusted) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
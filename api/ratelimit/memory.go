@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryLimiter はプロセスのメモリで数える Limiter｡レプリカが1つのときに使う
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	now     func() time.Time
+}
+
+type memoryBucket struct {
+	bucket
+	full time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets: map[string]*memoryBucket{},
+		now:     time.Now,
+	}
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, rule Rule) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &memoryBucket{bucket: bucket{tokens: float64(rule.Burst), updatedAt: now}}
+		l.buckets[key] = b
+	}
+
+	allowed, retryAfter := b.take(rule, now)
+	b.full = b.fullAt(rule)
+
+	return allowed, retryAfter, nil
+}
+
+// Run は ctx が終わるまで sweepInterval ごとに Sweep を呼ぶ
+func (l *MemoryLimiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		l.Sweep()
+	}
+}
+
+// Sweep は満タンに戻ったバケットを消して消した数を返す
+func (l *MemoryLimiter) Sweep() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	removed := 0
+	for key, b := range l.buckets {
+		if !now.Before(b.full) {
+			delete(l.buckets, key)
+			removed++
+		}
+	}
+
+	return removed
+}
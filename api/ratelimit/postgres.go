@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"domeal/model"
+	"log/slog"
+	"time"
+)
+
+// Store は Postgres でバケットを数えるのに使う
+type Store interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	LockRateLimitBucket(tx *sql.Tx, key string, burst float64) (*model.RateLimitBucket, time.Time, error)
+	SaveRateLimitBucket(tx *sql.Tx, bucket *model.RateLimitBucket) error
+	DeleteFullRateLimitBuckets() (int64, error)
+}
+
+// PostgresLimiter は rate_limit_buckets で数える Limiter｡
+// 行をロックしてから数えるので､どのレプリカに来たリクエストも同じ制限になる
+type PostgresLimiter struct {
+	store Store
+}
+
+func NewPostgresLimiter(store Store) *PostgresLimiter {
+	return &PostgresLimiter{
+		store: store,
+	}
+}
+
+func (l *PostgresLimiter) Allow(ctx context.Context, key string, rule Rule) (bool, time.Duration, error) {
+	tx, err := l.store.BeginTx(ctx, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+
+	row, now, err := l.store.LockRateLimitBucket(tx, key, float64(rule.Burst))
+	if err != nil {
+		return false, 0, err
+	}
+
+	b := bucket{tokens: row.Tokens, updatedAt: row.UpdatedAt}
+	allowed, retryAfter := b.take(rule, now)
+
+	row.Tokens = b.tokens
+	row.UpdatedAt = b.updatedAt
+	row.FullAt = b.fullAt(rule)
+	if err := l.store.SaveRateLimitBucket(tx, row); err != nil {
+		return false, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, 0, err
+	}
+
+	return allowed, retryAfter, nil
+}
+
+// Run は ctx が終わるまで sweepInterval ごとに満タンに戻ったバケットを消す｡
+// 消すのは満タンの行だけなので各レプリカで動かしてよい
+func (l *PostgresLimiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		removed, err := l.store.DeleteFullRateLimitBuckets()
+		if err != nil {
+			slog.Error("Failed to sweep rate limit buckets", "error", err)
+		}
+		if removed > 0 {
+			slog.Info("Swept rate limit buckets", "count", removed)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Package ratelimit はトークンバケットでリクエストの回数を制限する｡
+// レプリカが1つならメモリで数え､複数なら Postgres で数える
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// sweepInterval ごとに満タンに戻ったバケットを消す
+const sweepInterval = 10 * time.Minute
+
+// Rule は Burst 回まで続けて許し､その後は Every ごとに1回ずつ回復する制限
+type Rule struct {
+	Burst int
+	Every time.Duration
+}
+
+// Limiter はキーごとにリクエストを数える
+type Limiter interface {
+	// Allow は key で1回分を使う｡使えなければ false と次に使えるまでの時間を返す
+	Allow(ctx context.Context, key string, rule Rule) (bool, time.Duration, error)
+	// Run は ctx が終わるまで使われなくなったバケットを掃除する
+	Run(ctx context.Context)
+}
+
+// NewLimiterFromEnv は RATE_LIMIT_STORE=postgres なら Postgres で､それ以外はメモリで数える Limiter を作る｡
+// レプリカを増やすときは postgres にしないと､レプリカの数だけ制限が緩くなる
+func NewLimiterFromEnv(store Store) Limiter {
+	if strings.EqualFold(os.Getenv("RATE_LIMIT_STORE"), "postgres") {
+		slog.Info("Using Postgres rate limiter")
+		return NewPostgresLimiter(store)
+	}
+
+	slog.Info("Using in-memory rate limiter")
+	return NewMemoryLimiter()
+}
+
+// bucket はトークンバケットの状態｡tokens は updatedAt の時点で残っている回数
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// take は now の時点までに回復した分を足してから1回分を使う｡
+// 使えなければ使わずに false と次に使えるまでの時間を返す
+func (b *bucket) take(rule Rule, now time.Time) (bool, time.Duration) {
+	burst := float64(rule.Burst)
+	if elapsed := now.Sub(b.updatedAt); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+float64(elapsed)/float64(rule.Every))
+		b.updatedAt = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration(math.Ceil((1 - b.tokens) * float64(rule.Every)))
+}
+
+// fullAt はバケットが満タンに戻る時刻｡それ以降は消してもないのと同じになる
+func (b *bucket) fullAt(rule Rule) time.Time {
+	missing := float64(rule.Burst) - b.tokens
+	return b.updatedAt.Add(time.Duration(math.Ceil(missing * float64(rule.Every))))
+}
@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiterAllow(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewMemoryLimiter()
+	limiter.now = func() time.Time { return now }
+	rule := Rule{Burst: 2, Every: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		if ok, _, err := limiter.Allow(context.Background(), "k", rule); err != nil || !ok {
+			t.Fatalf("request %d: Allow = %v, %v, want allowed", i, ok, err)
+		}
+	}
+
+	ok, retryAfter, err := limiter.Allow(context.Background(), "k", rule)
+	if err != nil || ok {
+		t.Fatalf("third request: Allow = %v, %v, want denied", ok, err)
+	}
+	if retryAfter != time.Minute {
+		t.Errorf("retryAfter = %v, want 1m", retryAfter)
+	}
+
+	// 別のキーは別に数える
+	if ok, _, _ := limiter.Allow(context.Background(), "other", rule); !ok {
+		t.Error("other key was denied")
+	}
+
+	now = now.Add(30 * time.Second)
+	if _, retryAfter, _ := limiter.Allow(context.Background(), "k", rule); retryAfter != 30*time.Second {
+		t.Errorf("retryAfter after 30s = %v, want 30s", retryAfter)
+	}
+
+	now = now.Add(30 * time.Second)
+	if ok, _, _ := limiter.Allow(context.Background(), "k", rule); !ok {
+		t.Error("request after refill was denied")
+	}
+}
+
+func TestMemoryLimiterSweep(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewMemoryLimiter()
+	limiter.now = func() time.Time { return now }
+	rule := Rule{Burst: 3, Every: time.Minute}
+
+	limiter.Allow(context.Background(), "k", rule)
+	limiter.Allow(context.Background(), "k", rule)
+
+	now = now.Add(time.Minute)
+	if removed := limiter.Sweep(); removed != 0 {
+		t.Errorf("Sweep before full = %d, want 0", removed)
+	}
+
+	now = now.Add(time.Minute)
+	if removed := limiter.Sweep(); removed != 1 {
+		t.Errorf("Sweep after full = %d, want 1", removed)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("172.16.0.0/12, 10.0.0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"direct", "203.0.113.7:5000", "", "203.0.113.7"},
+		{"untrusted sender", "203.0.113.7:5000", "198.51.100.1", "203.0.113.7"},
+		{"from nginx", "172.18.0.3:5000", "198.51.100.1", "198.51.100.1"},
+		{"spoofed by client", "172.18.0.3:5000", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"through two proxies", "172.18.0.3:5000", "198.51.100.1, 10.0.0.5", "198.51.100.1"},
+		{"unreadable entry", "172.18.0.3:5000", "198.51.100.1, junk", "172.18.0.3"},
+		{"no header from nginx", "172.18.0.3:5000", "", "172.18.0.3"},
+		{"ipv6", "[2001:db8::1]:5000", "", "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := ClientIP(r, proxies); got != tt.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	if _, err := ParseTrustedProxies("nginx"); err == nil {
+		t.Error("ParseTrustedProxies(nginx) error = nil, want error")
+	}
+	if proxies, err := ParseTrustedProxies(""); err != nil || len(proxies) != 0 {
+		t.Errorf("ParseTrustedProxies(\"\") = %v, %v", proxies, err)
+	}
+}
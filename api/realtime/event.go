@@ -0,0 +1,135 @@
+package realtime
+
+import (
+	"database/sql"
+	"domeal/model"
+	"encoding/json"
+	"time"
+)
+
+// Event はグループのルームに流すイベント｡ID は group_events の連番で､
+// SSE の Last-Event-ID にもそのまま使う
+type Event struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	GroupID   int64           `json:"group_id"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+const (
+	EventMemberJoined       = "member_joined"
+	EventMemberWaitlisted   = "member_waitlisted"
+	EventMemberLeft         = "member_left"
+	EventMemberPromoted     = "member_promoted"
+	EventWaitlistLeft       = "waitlist_left"
+	EventMemberRoleChanged  = "member_role_changed"
+	EventGroupUpdated       = "group_updated"
+	EventGroupStatusChanged = "group_status_changed"
+	EventGroupDeleted       = "group_deleted"
+	EventOrderUpdated       = "order_updated"
+	EventOrderCancelled     = "order_cancelled"
+	EventPaymentUpdated     = "payment_updated"
+	EventShareUpdated       = "share_updated"
+	EventExpenseCreated     = "expense_created"
+	EventExpenseUpdated     = "expense_updated"
+	EventExpenseDeleted     = "expense_deleted"
+	EventMessagePosted      = "message_posted"
+	EventMessageEdited      = "message_edited"
+	EventMessageDeleted     = "message_deleted"
+	EventMessagesRead       = "messages_read"
+)
+
+// NewEvent は payload をJSONにしてイベントを作る｡payload が nil ならペイロードなし
+func NewEvent(eventType string, groupID int64, payload any) Event {
+	event := Event{
+		Type:      eventType,
+		GroupID:   groupID,
+		CreatedAt: time.Now(),
+	}
+
+	if payload != nil {
+		// ペイロードはコントローラーで組み立てる単純な構造体なので失敗しない
+		data, err := json.Marshal(payload)
+		if err == nil {
+			event.Payload = data
+		}
+	}
+
+	return event
+}
+
+// Publisher はグループのイベントを記録する
+type Publisher interface {
+	// Record はグループの変更と同じトランザクションでイベントを group_events に書き込む｡
+	// コミットされると NOTIFY を受けた各レプリカが自分の購読者に届ける
+	Record(tx *sql.Tx, event Event) error
+}
+
+// EventStore はイベントの永続化に使う
+type EventStore interface {
+	AppendGroupEvent(tx *sql.Tx, event *model.GroupEvent) error
+	GetGroupEvent(id int64) (*model.GroupEvent, error)
+	ListGroupEventsAfter(groupID, afterID int64, limit int) ([]model.GroupEvent, error)
+	GetLatestGroupEventID(groupID int64) (int64, error)
+}
+
+func eventFromModel(groupEvent model.GroupEvent) Event {
+	return Event{
+		ID:        groupEvent.ID,
+		Type:      groupEvent.Type,
+		GroupID:   groupEvent.GroupID,
+		Payload:   groupEvent.Payload,
+		CreatedAt: groupEvent.CreatedAt,
+	}
+}
+
+type MemberPayload struct {
+	UserID      int64  `json:"user_id"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+type RolePayload struct {
+	UserID int64  `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+type StatusPayload struct {
+	Status string `json:"status"`
+}
+
+// OrderPayload の中身は載せないので､必要なクライアントは注文を取り直す
+type OrderPayload struct {
+	UserID   int64 `json:"user_id"`
+	Quantity int64 `json:"quantity"`
+}
+
+type SharePayload struct {
+	UserID      int64  `json:"user_id"`
+	Weight      int64  `json:"weight"`
+	FixedAmount *int64 `json:"fixed_amount"`
+}
+
+// ExpensePayload の立て替えが変わると精算の金額も変わるので､必要なクライアントは精算を取り直す
+type ExpensePayload struct {
+	ExpenseID int64 `json:"expense_id"`
+	PaidBy    int64 `json:"paid_by,omitempty"`
+	Amount    int64 `json:"amount,omitempty"`
+}
+
+type PaymentPayload struct {
+	PayerID int64  `json:"payer_id"`
+	PayeeID int64  `json:"payee_id"`
+	Amount  int64  `json:"amount,omitempty"`
+	Status  string `json:"status"`
+}
+
+type MessagePayload struct {
+	MessageID int64 `json:"message_id"`
+	UserID    int64 `json:"user_id"`
+}
+
+type MessageReadPayload struct {
+	UserID            int64 `json:"user_id"`
+	LastReadMessageID int64 `json:"last_read_message_id"`
+}
@@ -0,0 +1,163 @@
+package realtime
+
+import (
+	"database/sql"
+	"domeal/model"
+	"log/slog"
+	"sync"
+)
+
+// subscriberBuffer は購読者ごとに溜めておけるイベントの数｡
+// これを超えて溜まる遅い購読者は切断して､ほかの購読者やPublishを待たせない
+const subscriberBuffer = 64
+
+// Subscription はグループのイベントの購読
+type Subscription struct {
+	// C はイベントを受け取るチャネル｡購読が終わるか遅すぎて切断されると閉じられる
+	C <-chan Event
+
+	ch      chan Event
+	groupID int64
+	once    sync.Once
+}
+
+// hub はグループごとの購読者の集まり
+type hub struct {
+	subscribers map[*Subscription]struct{}
+	// lastID は hub に配った最後のイベントの ID｡グループの中では ID の順にコミットされるので､
+	// これより前のイベントは配り済みか hub ができる前のもの
+	lastID int64
+}
+
+// Broker はグループごとの hub を管理し､イベントを同じプロセス内の購読者に配る｡
+// WebSocket と SSE は同じ Broker を購読する｡ほかのレプリカで起きたイベントも Listen で受け取る
+type Broker struct {
+	store EventStore
+
+	mu   sync.Mutex
+	hubs map[int64]*hub
+}
+
+func NewBroker(store EventStore) *Broker {
+	return &Broker{
+		store: store,
+		hubs:  map[int64]*hub{},
+	}
+}
+
+func (b *Broker) Record(tx *sql.Tx, event Event) error {
+	return b.store.AppendGroupEvent(tx, &model.GroupEvent{
+		GroupID: event.GroupID,
+		Type:    event.Type,
+		Payload: event.Payload,
+	})
+}
+
+// Replay は afterID より後に記録されたイベントを古い順に最大 limit 件返す
+func (b *Broker) Replay(groupID, afterID int64, limit int) ([]Event, error) {
+	groupEvents, err := b.store.ListGroupEventsAfter(groupID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0, len(groupEvents))
+	for _, groupEvent := range groupEvents {
+		events = append(events, eventFromModel(groupEvent))
+	}
+
+	return events, nil
+}
+
+// Subscribe はグループのイベントの購読を始める｡終わったら Unsubscribe すること
+func (b *Broker) Subscribe(groupID int64) (*Subscription, error) {
+	ch := make(chan Event, subscriberBuffer)
+	sub := &Subscription{
+		C:       ch,
+		ch:      ch,
+		groupID: groupID,
+	}
+
+	b.mu.Lock()
+	_, ok := b.hubs[groupID]
+	b.mu.Unlock()
+
+	// 新しい hub は今のグループの最新の ID から始め､再接続のときにそれより後を取り直す
+	var lastID int64
+	if !ok {
+		var err error
+		lastID, err = b.store.GetLatestGroupEventID(groupID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	h, ok := b.hubs[groupID]
+	if !ok {
+		h = &hub{subscribers: map[*Subscription]struct{}{}, lastID: lastID}
+		b.hubs[groupID] = h
+	}
+	h.subscribers[sub] = struct{}{}
+
+	return sub, nil
+}
+
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remove(sub)
+}
+
+// Publish はイベントをこのプロセスにいるグループの購読者に配る｡購読者の受信を待たない｡
+// 通常は Listen が NOTIFY を受けて呼ぶ
+func (b *Broker) Publish(events ...Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, event := range events {
+		b.deliver(event)
+	}
+}
+
+// deliver は b.mu をロックした状態で呼ぶ
+func (b *Broker) deliver(event Event) {
+	h, ok := b.hubs[event.GroupID]
+	if !ok {
+		return
+	}
+
+	// 通知と取り直しの両方で届いたイベントは一度だけ配る
+	if event.ID <= h.lastID {
+		return
+	}
+	h.lastID = event.ID
+
+	for sub := range h.subscribers {
+		select {
+		case sub.ch <- event:
+		default:
+			slog.Warn("Dropping slow subscriber", "group_id", event.GroupID)
+			b.remove(sub)
+		}
+	}
+}
+
+// remove は b.mu をロックした状態で呼ぶ
+func (b *Broker) remove(sub *Subscription) {
+	h, ok := b.hubs[sub.groupID]
+	if !ok {
+		return
+	}
+
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		sub.once.Do(func() { close(sub.ch) })
+	}
+
+	if len(h.subscribers) == 0 {
+		delete(b.hubs, sub.groupID)
+	}
+}
@@ -0,0 +1,107 @@
+package realtime
+
+import (
+	"context"
+	"domeal/model"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	listenerMinReconnect = 10 * time.Second
+	listenerMaxReconnect = time.Minute
+	// listenerPingPeriod の間に通知がなければ接続が生きているか確かめる
+	listenerPingPeriod = 90 * time.Second
+	// catchUpBatchSize は再接続後に一度に取り直すイベントの数
+	catchUpBatchSize = 1000
+)
+
+// Listen は group_events チャネルを LISTEN し､どのレプリカで記録されたイベントも
+// このプロセスの購読者に配る｡ctx が終わるまで戻らない
+func (b *Broker) Listen(ctx context.Context, dsn string) error {
+	listener := pq.NewListener(dsn, listenerMinReconnect, listenerMaxReconnect, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Error("Group event listener error", "event", ev, "error", err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(model.GroupEventChannel); err != nil {
+		return err
+	}
+	slog.Info("Listening for group events", "channel", model.GroupEventChannel)
+
+	ticker := time.NewTicker(listenerPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case notification := <-listener.Notify:
+			if notification == nil {
+				// 再接続した｡切れていた間の通知は届かないので取り直す
+				slog.Warn("Group event listener reconnected")
+				b.catchUp()
+				continue
+			}
+
+			id, err := strconv.ParseInt(notification.Extra, 10, 64)
+			if err != nil {
+				slog.Error("Invalid group event notification", "payload", notification.Extra)
+				continue
+			}
+
+			groupEvent, err := b.store.GetGroupEvent(id)
+			if err != nil {
+				slog.Error("Failed to get group event", "error", err, "id", id)
+				continue
+			}
+
+			b.Publish(eventFromModel(*groupEvent))
+
+		case <-ticker.C:
+			go func() {
+				if err := listener.Ping(); err != nil {
+					slog.Warn("Group event listener ping failed", "error", err)
+				}
+			}()
+		}
+	}
+}
+
+// catchUp はこのプロセスに購読者がいるグループごとに､hub に最後に配った ID より後のイベントを配る｡
+// AppendGroupEvent がグループの中で ID の順にコミットさせるので､それより前のイベントが後から増えることはない
+func (b *Broker) catchUp() {
+	b.mu.Lock()
+	cursors := make(map[int64]int64, len(b.hubs))
+	for groupID, h := range b.hubs {
+		cursors[groupID] = h.lastID
+	}
+	b.mu.Unlock()
+
+	for groupID, lastID := range cursors {
+		for {
+			groupEvents, err := b.store.ListGroupEventsAfter(groupID, lastID, catchUpBatchSize)
+			if err != nil {
+				slog.Error("Failed to catch up group events", "error", err, "group_id", groupID, "last_id", lastID)
+				break
+			}
+
+			events := make([]Event, 0, len(groupEvents))
+			for _, groupEvent := range groupEvents {
+				events = append(events, eventFromModel(groupEvent))
+				lastID = groupEvent.ID
+			}
+			b.Publish(events...)
+
+			if len(groupEvents) < catchUpBatchSize {
+				break
+			}
+		}
+	}
+}
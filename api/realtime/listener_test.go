@@ -0,0 +1,74 @@
+package realtime
+
+import (
+	"database/sql"
+	"domeal/model"
+	"testing"
+)
+
+type fakeEventStore struct {
+	events []model.GroupEvent
+}
+
+func (s *fakeEventStore) AppendGroupEvent(tx *sql.Tx, event *model.GroupEvent) error {
+	return nil
+}
+
+func (s *fakeEventStore) GetGroupEvent(id int64) (*model.GroupEvent, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeEventStore) ListGroupEventsAfter(groupID, afterID int64, limit int) ([]model.GroupEvent, error) {
+	events := []model.GroupEvent{}
+	for _, event := range s.events {
+		if event.GroupID == groupID && event.ID > afterID && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s *fakeEventStore) GetLatestGroupEventID(groupID int64) (int64, error) {
+	var id int64
+	for _, event := range s.events {
+		if event.GroupID == groupID {
+			id = max(id, event.ID)
+		}
+	}
+	return id, nil
+}
+
+func TestCatchUp(t *testing.T) {
+	store := &fakeEventStore{}
+	for id := int64(1); id < catchUpBatchSize; id++ {
+		store.events = append(store.events, model.GroupEvent{ID: id, GroupID: 7, Type: "test"})
+	}
+	broker := NewBroker(store)
+	sub, err := broker.Subscribe(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Unsubscribe(sub)
+
+	// ほかのグループのイベントが後からコミットされても､グループごとの ID より後を取り直す
+	for id := int64(catchUpBatchSize); id <= catchUpBatchSize+2; id++ {
+		store.events = append(store.events, model.GroupEvent{ID: id, GroupID: 7, Type: "test"})
+	}
+	store.events = append(store.events, model.GroupEvent{ID: catchUpBatchSize + 3, GroupID: 8, Type: "test"})
+	broker.catchUp()
+
+	for want := int64(catchUpBatchSize); want <= catchUpBatchSize+2; want++ {
+		event := <-sub.C
+		if event.ID != want {
+			t.Fatalf("event id = %d, want %d", event.ID, want)
+		}
+	}
+
+	// 取り直したあとに遅れて届いた通知は配らない
+	broker.Publish(eventFromModel(store.events[len(store.events)-2]))
+	select {
+	case event := <-sub.C:
+		t.Fatalf("event %d was delivered twice", event.ID)
+	default:
+	}
+}
@@ -0,0 +1,184 @@
+package realtime
+
+import (
+	"domeal/authz"
+	"domeal/middleware"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// sseHeartbeatPeriod はコメント行を送る間隔｡nginx の proxy_read_timeout (60秒) より短くする
+	sseHeartbeatPeriod = 30 * time.Second
+	// sseReplayBatchSize は Last-Event-ID からの取り直しで1回に読むイベントの数
+	sseReplayBatchSize = 100
+	// sseRetry は再接続までの待ち時間としてクライアントに伝えるミリ秒
+	sseRetry = 3000
+)
+
+// SSEHandler は WebSocket を通せないクライアント向けに同じイベントを Server-Sent Events で流す
+type SSEHandler struct {
+	repo   authz.RoleStore
+	broker *Broker
+}
+
+func NewSSEHandler(repo authz.RoleStore, broker *Broker) *SSEHandler {
+	return &SSEHandler{
+		repo:   repo,
+		broker: broker,
+	}
+}
+
+// ServeGroup は /api/groups/{id}/events でグループのイベントを流す｡
+// Last-Event-ID が付いていれば group_events からその後のイベントを先に送る
+func (h *SSEHandler) ServeGroup(w http.ResponseWriter, r *http.Request) {
+	// ミドルウェアで設定されたユーザーIDを取得
+	tmpUser, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		slog.Error("ミドルウェアからユーザー情報を取得できませんでした｡Cookieなどを確認すべき｡")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := int64(tmpUser.ID)
+
+	groupID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || groupID <= 0 {
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	lastEventID, err := parseLastEventID(r)
+	if err != nil {
+		http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+		return
+	}
+
+	// ルームに入れるかどうかは authz で確かめる
+	canView, err := authz.Can(h.repo, groupID, userID, authz.ViewGroup)
+	if err != nil {
+		slog.Error("Failed to check group permission", "error", err)
+		http.Error(w, "Failed to check group permission", http.StatusInternalServerError)
+		return
+	}
+
+	if !canView {
+		http.Error(w, "You are not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	// 取り直しの間に起きたイベントを取りこぼさないように､先に購読してから履歴を読む
+	sub, err := h.broker.Subscribe(groupID)
+	if err != nil {
+		slog.Error("Failed to subscribe to group events", "error", err, "group_id", groupID)
+		http.Error(w, "Failed to subscribe to group events", http.StatusInternalServerError)
+		return
+	}
+	defer h.broker.Unsubscribe(sub)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// nginx にバッファさせない
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetry); err != nil {
+		return
+	}
+
+	slog.Info("SSE connected", "group_id", groupID, "user_id", userID, "last_event_id", lastEventID)
+	defer slog.Info("SSE disconnected", "group_id", groupID, "user_id", userID)
+
+	if lastEventID > 0 {
+		for {
+			events, err := h.broker.Replay(groupID, lastEventID, sseReplayBatchSize)
+			if err != nil {
+				slog.Error("Failed to replay group events", "error", err, "group_id", groupID)
+				return
+			}
+
+			for _, event := range events {
+				if err := writeSSEEvent(w, event); err != nil {
+					return
+				}
+				lastEventID = event.ID
+			}
+
+			if len(events) < sseReplayBatchSize {
+				break
+			}
+		}
+	}
+
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(sseHeartbeatPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				// 遅すぎて Broker から切断された｡クライアントは Last-Event-ID を付けて再接続してくる
+				return
+			}
+
+			// 取り直しで送ったイベントは飛ばす
+			if event.ID <= lastEventID {
+				continue
+			}
+
+			if err := writeSSEEvent(w, event); err != nil {
+				return
+			}
+			lastEventID = event.ID
+
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+
+		case <-r.Context().Done():
+			return
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// parseLastEventID は再接続時にブラウザが付ける Last-Event-ID を読む｡
+// EventSource はヘッダーを変えられないので､初回接続用に last_event_id クエリも受け付ける
+func parseLastEventID(r *http.Request) (int64, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("invalid last event id: %q", value)
+	}
+
+	return id, nil
+}
+
+func writeSSEEvent(w http.ResponseWriter, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
@@ -0,0 +1,188 @@
+package realtime
+
+import (
+	"database/sql"
+	"domeal/authz"
+	"domeal/middleware"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait はクライアントへの1回の書き込みに許す時間
+	writeWait = 10 * time.Second
+	// pongWait はpongを待つ時間｡これを過ぎたら切断されたとみなす
+	pongWait = 60 * time.Second
+	// pingPeriod はpingを送る間隔｡pongWait より短くする
+	pingPeriod = pongWait * 9 / 10
+	// maxMessageSize はクライアントから受け付けるメッセージの大きさ｡サーバーからの一方通行なので小さくてよい
+	maxMessageSize = 512
+	// defaultFrontendOrigin は FRONTEND_ORIGIN がないときのフロントエンド｡開発環境の nginx を指す
+	defaultFrontendOrigin = "http://localhost"
+)
+
+type WebSocketHandler struct {
+	db       *sql.DB
+	repo     authz.RoleStore
+	broker   *Broker
+	upgrader websocket.Upgrader
+}
+
+func NewWebSocketHandler(db *sql.DB, repo authz.RoleStore, broker *Broker) *WebSocketHandler {
+	origins := os.Getenv("FRONTEND_ORIGIN")
+	if origins == "" {
+		origins = defaultFrontendOrigin
+	}
+
+	return &WebSocketHandler{
+		db:       db,
+		repo:     repo,
+		broker:   broker,
+		upgrader: newUpgrader(strings.Split(origins, ",")),
+	}
+}
+
+// newUpgrader は Origin がフロントエンドのものだけを受け付ける Upgrader を作る｡
+// gorilla の既定は Host と比べるが､プロキシの設定で Host が変わっても困らないように設定した Origin と比べる
+func newUpgrader(origins []string) websocket.Upgrader {
+	allowed := map[string]bool{}
+	for _, origin := range origins {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			allowed[strings.ToLower(origin)] = true
+		}
+	}
+
+	return websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			// ブラウザ以外のクライアントは Origin を送らない｡Cookie の確認は済んでいる
+			if origin == "" {
+				return true
+			}
+			if allowed[strings.ToLower(origin)] {
+				return true
+			}
+			slog.Warn("Rejected websocket from unknown origin", "origin", origin)
+			return false
+		},
+	}
+}
+
+// ServeGroup は /ws/groups/{id} でグループのイベントをJSONで流す
+func (h *WebSocketHandler) ServeGroup(w http.ResponseWriter, r *http.Request) {
+	// AuthMiddleware と同じ確認をする
+	user, err := middleware.Authenticate(h.db, r)
+	if errors.Is(err, middleware.ErrMissingSession) || errors.Is(err, middleware.ErrInvalidSession) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		slog.Error("Failed to authenticate websocket", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	userID := int64(user.ID)
+
+	groupID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || groupID <= 0 {
+		http.Error(w, "Valid group ID is required", http.StatusBadRequest)
+		return
+	}
+
+	// ルームに入れるかどうかは authz で確かめる
+	canView, err := authz.Can(h.repo, groupID, userID, authz.ViewGroup)
+	if err != nil {
+		slog.Error("Failed to check group permission", "error", err)
+		http.Error(w, "Failed to check group permission", http.StatusInternalServerError)
+		return
+	}
+
+	if !canView {
+		http.Error(w, "You are not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	sub, err := h.broker.Subscribe(groupID)
+	if err != nil {
+		slog.Error("Failed to subscribe to group events", "error", err, "group_id", groupID)
+		http.Error(w, "Failed to subscribe to group events", http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade がエラーレスポンスを書き込み済み
+		slog.Error("Failed to upgrade websocket", "error", err)
+		h.broker.Unsubscribe(sub)
+		return
+	}
+
+	slog.Info("WebSocket connected", "group_id", groupID, "user_id", userID)
+
+	done := make(chan struct{})
+	go readPump(conn, done)
+	writePump(conn, sub, done)
+
+	h.broker.Unsubscribe(sub)
+	slog.Info("WebSocket disconnected", "group_id", groupID, "user_id", userID)
+}
+
+// readPump はpongと切断を検知するためだけに読み続ける｡読めなくなったら done を閉じる
+func readPump(conn *websocket.Conn, done chan<- struct{}) {
+	defer close(done)
+
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
+
+// writePump はイベントとpingを書き込む｡書き込めなくなるか､購読が切られるか､
+// クライアントが切断したら戻る
+func writePump(conn *websocket.Conn, sub *Subscription, done <-chan struct{}) {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case event, ok := <-sub.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// 遅すぎて Broker から切断された
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"))
+				return
+			}
+
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+
+		case <-done:
+			return
+		}
+	}
+}
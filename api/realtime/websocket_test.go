@@ -0,0 +1,51 @@
+package realtime
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestUpgraderCheckOrigin(t *testing.T) {
+	upgrader := newUpgrader([]string{"http://localhost", " https://domeal.example/ "})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		// nginx を通ると Host は api:8080 などになるが､Origin が設定と合えば通す
+		{"http://localhost", http.StatusSwitchingProtocols},
+		{"https://domeal.example", http.StatusSwitchingProtocols},
+		{"", http.StatusSwitchingProtocols},
+		{"http://evil.example", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if conn != nil {
+			conn.Close()
+		}
+		if resp == nil {
+			t.Fatalf("origin %q: no response: %v", tt.origin, err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("origin %q: status = %d, want %d", tt.origin, resp.StatusCode, tt.want)
+		}
+	}
+}
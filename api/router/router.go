@@ -3,34 +3,291 @@ package router
 import (
 	"database/sql"
 	"domeal/controller"
+	"domeal/mailer"
+	"domeal/media"
 	"domeal/middleware"
 	"domeal/model"
+	"domeal/notify"
+	"domeal/ratelimit"
+	"domeal/realtime"
 	"net/http"
+	"time"
+)
+
+// レート制限｡IP アドレスごとの制限は同じ回線の人たちで分け合うので､ユーザーごとより緩くする
+var (
+	loginIPLimit       = ratelimit.Rule{Burst: 10, Every: 30 * time.Second}
+	createGroupIPLimit = ratelimit.Rule{Burst: 20, Every: time.Minute}
+	createGroupLimit   = ratelimit.Rule{Burst: 5, Every: 5 * time.Minute}
+	joinGroupIPLimit   = ratelimit.Rule{Burst: 60, Every: 10 * time.Second}
+	joinGroupLimit     = ratelimit.Rule{Burst: 20, Every: 30 * time.Second}
 )
 
 type Router struct {
-	db *sql.DB
+	db     *sql.DB
+	broker *realtime.Broker
+	blobs  media.BlobStore
+	limits *middleware.RateLimiter
 }
 
-func NewRouter(db *sql.DB) *Router {
+func NewRouter(db *sql.DB, broker *realtime.Broker, blobs media.BlobStore, limits *middleware.RateLimiter) *Router {
 	return &Router{
-		db: db,
+		db:     db,
+		broker: broker,
+		blobs:  blobs,
+		limits: limits,
 	}
 }
 
 func (r *Router) SetupRouter() {
 	repo := model.NewRepository(r.db)
-	userController := controller.NewUserController(repo)
-	groupController := controller.NewGroupController(repo)
+	outbox := notify.NewOutbox(repo, repo)
+	userController := controller.NewUserController(repo, repo)
+	groupController := controller.NewGroupController(repo, r.broker, outbox, r.blobs)
+	notificationController := controller.NewNotificationController(repo)
+	menuItemController := controller.NewMenuItemController(repo, r.broker)
+	orderController := controller.NewOrderController(repo, r.broker)
+	settlementController := controller.NewSettlementController(repo, r.broker)
+	paymentController := controller.NewPaymentController(repo, r.broker)
+	expenseController := controller.NewExpenseController(repo, r.broker)
+	balanceController := controller.NewBalanceController(repo)
+	messageController := controller.NewMessageController(repo, r.broker)
+	uploadController := controller.NewUploadController(repo, r.broker, r.blobs)
+	accountController := controller.NewAccountController(repo, repo, r.broker)
+	emailController := controller.NewEmailController(repo, mailer.NewMailerFromEnv())
+	adminController := controller.NewAdminController(repo, r.broker, outbox)
+	auditController := controller.NewAuditController(repo)
+	mediaController := controller.NewMediaController(r.db, repo, r.blobs, media.NewSignerFromEnv(), media.NewCacheFromEnv())
+	webSocketHandler := realtime.NewWebSocketHandler(r.db, repo, r.broker)
+	sseHandler := realtime.NewSSEHandler(repo, r.broker)
 
-	http.HandleFunc("/api/line-callback", userController.LineCallbackHandler)
+	http.Handle(
+		"/api/line-callback",
+		r.limits.ByIP("login", loginIPLimit)(http.HandlerFunc(userController.LineCallbackHandler)),
+	)
 	http.HandleFunc("/api/check-login-status", userController.CheckLoginStatusHandler)
 	http.Handle(
 		"/api/create-group",
-		middleware.AuthMiddleware(r.db)(http.HandlerFunc(groupController.CreateGroupController)),
+		r.limits.ByIP("create_group", createGroupIPLimit)(middleware.AuthMiddleware(r.db)(
+			r.limits.ByUser("create_group", createGroupLimit)(http.HandlerFunc(groupController.CreateGroupController)),
+		)),
 	)
 	http.Handle(
 		"/api/join-group",
-		middleware.AuthMiddleware(r.db)(http.HandlerFunc(groupController.JoinGroupController)),
+		r.limits.ByIP("join_group", joinGroupIPLimit)(middleware.AuthMiddleware(r.db)(
+			r.limits.ByUser("join_group", joinGroupLimit)(http.HandlerFunc(groupController.JoinGroupController)),
+		)),
+	)
+	http.Handle(
+		"/api/leave-group",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(groupController.LeaveGroupController)),
+	)
+	http.Handle(
+		"PATCH /api/groups/{id}",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(groupController.UpdateGroupController)),
+	)
+	http.Handle(
+		"DELETE /api/groups/{id}",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(groupController.DeleteGroupController)),
+	)
+	http.Handle(
+		"GET /api/groups/{id}/menu-items",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(menuItemController.ListMenuItemsController)),
+	)
+	http.Handle(
+		"POST /api/groups/{id}/menu-items",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(menuItemController.CreateMenuItemController)),
+	)
+	http.Handle(
+		"PATCH /api/groups/{id}/menu-items/{itemID}",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(menuItemController.UpdateMenuItemController)),
+	)
+	http.Handle(
+		"DELETE /api/groups/{id}/menu-items/{itemID}",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(menuItemController.DeleteMenuItemController)),
+	)
+	http.Handle(
+		"GET /api/groups/{id}/orders/me",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(orderController.GetMyOrderController)),
+	)
+	http.Handle(
+		"PUT /api/groups/{id}/orders/me",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(orderController.PlaceOrderController)),
+	)
+	http.Handle(
+		"DELETE /api/groups/{id}/orders/me",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(orderController.CancelOrderController)),
+	)
+	http.Handle(
+		"GET /api/groups/{id}/orders",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(orderController.GetOrderSummaryController)),
+	)
+	http.Handle(
+		"GET /api/groups/{id}/settlement",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(settlementController.GetSettlementController)),
+	)
+	http.Handle(
+		"PUT /api/groups/{id}/members/{userID}/share",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(settlementController.UpdateMemberShareController)),
+	)
+	http.Handle(
+		"PUT /api/groups/{id}/members/{userID}/role",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(groupController.UpdateMemberRoleController)),
+	)
+	http.Handle(
+		"GET /api/groups/{id}/payments",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(paymentController.ListPaymentsController)),
+	)
+	http.Handle(
+		"GET /api/groups/{id}/payments/me",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(paymentController.GetMyPaymentsController)),
+	)
+	http.Handle(
+		"POST /api/groups/{id}/payments/me/paid",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(paymentController.MarkPaidController)),
+	)
+	http.Handle(
+		"POST /api/groups/{id}/payments/{userID}/confirm",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(paymentController.ConfirmPaymentController)),
+	)
+	http.Handle(
+		"GET /api/groups/{id}/expenses",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(expenseController.ListExpensesController)),
+	)
+	http.Handle(
+		"POST /api/groups/{id}/expenses",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(expenseController.CreateExpenseController)),
+	)
+	http.Handle(
+		"PATCH /api/groups/{id}/expenses/{expenseID}",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(expenseController.UpdateExpenseController)),
+	)
+	http.Handle(
+		"DELETE /api/groups/{id}/expenses/{expenseID}",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(expenseController.DeleteExpenseController)),
+	)
+	http.Handle(
+		"GET /api/groups/{id}/messages",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(messageController.ListMessagesController)),
+	)
+	http.Handle(
+		"POST /api/groups/{id}/messages",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(messageController.PostMessageController)),
+	)
+	http.Handle(
+		"POST /api/groups/{id}/messages/read",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(messageController.MarkMessagesReadController)),
+	)
+	http.Handle(
+		"PATCH /api/groups/{id}/messages/{messageID}",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(messageController.UpdateMessageController)),
+	)
+	http.Handle(
+		"DELETE /api/groups/{id}/messages/{messageID}",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(messageController.DeleteMessageController)),
+	)
+	http.Handle(
+		"GET /api/groups/{id}/history",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(auditController.GroupHistoryController)),
+	)
+	http.Handle(
+		"GET /api/me",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(userController.GetMeHandler)),
+	)
+	http.Handle(
+		"PATCH /api/me",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(userController.UpdateMeHandler)),
+	)
+	http.Handle(
+		"DELETE /api/me",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(accountController.DeleteMeHandler)),
+	)
+	http.Handle(
+		"POST /api/me/email",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(emailController.RequestEmailChangeHandler)),
+	)
+	// 確認メールのリンクは別の端末で開かれることがあるので､トークンだけで確認する
+	http.HandleFunc("POST /api/verify-email", emailController.VerifyEmailHandler)
+	http.Handle(
+		"GET /api/me/export",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(accountController.ExportMeHandler)),
+	)
+	http.Handle(
+		"GET /api/me/paypal-me",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(userController.GetPayPalMeHandler)),
+	)
+	http.Handle(
+		"PUT /api/me/paypal-me",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(userController.UpdatePayPalMeHandler)),
+	)
+	http.Handle(
+		"GET /api/me/balances",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(balanceController.GetMyBalancesController)),
+	)
+	http.Handle(
+		"GET /api/groups/{id}/events",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(sseHandler.ServeGroup)),
+	)
+	// WebSocket はアップグレード前に自分でCookieを確認する
+	http.HandleFunc("GET /ws/groups/{id}", webSocketHandler.ServeGroup)
+	http.Handle(
+		"GET /api/me/notification-settings",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(notificationController.GetNotificationSettingsController)),
+	)
+	http.Handle(
+		"PUT /api/me/notification-settings",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(notificationController.UpdateNotificationSettingsController)),
+	)
+	http.Handle(
+		"POST /api/uploads",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(uploadController.CreateUploadController)),
+	)
+	http.Handle(
+		"POST /api/uploads/{id}/confirm",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(uploadController.ConfirmUploadController)),
+	)
+	// 画像は署名付きURLでも取得できるので､Cookieの確認はハンドラーの中で行う
+	http.HandleFunc("GET "+media.MediaPath+"/{id}", mediaController.ServeMediaController)
+	http.Handle(
+		"GET "+media.MediaPath+"/{id}/signed-url",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(mediaController.SignMediaController)),
+	)
+	http.Handle(
+		"GET /api/notifications",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(notificationController.ListNotificationsController)),
+	)
+	http.Handle(
+		"POST /api/notifications/read",
+		middleware.AuthMiddleware(r.db)(http.HandlerFunc(notificationController.MarkNotificationsReadController)),
+	)
+
+	// 運営者向けのAPI｡すべて AdminMiddleware を通す
+	http.Handle(
+		"GET /api/admin/users",
+		middleware.AdminMiddleware(r.db)(http.HandlerFunc(adminController.SearchUsersController)),
+	)
+	http.Handle(
+		"DELETE /api/admin/users/{id}/sessions",
+		middleware.AdminMiddleware(r.db)(http.HandlerFunc(adminController.RevokeSessionsController)),
+	)
+	http.Handle(
+		"GET /api/admin/groups",
+		middleware.AdminMiddleware(r.db)(http.HandlerFunc(adminController.SearchGroupsController)),
+	)
+	http.Handle(
+		"POST /api/admin/groups/{id}/close",
+		middleware.AdminMiddleware(r.db)(http.HandlerFunc(adminController.CloseGroupController)),
+	)
+	http.Handle(
+		"DELETE /api/admin/groups/{id}",
+		middleware.AdminMiddleware(r.db)(http.HandlerFunc(adminController.DeleteGroupController)),
+	)
+	http.Handle(
+		"GET /api/admin/actions",
+		middleware.AdminMiddleware(r.db)(http.HandlerFunc(adminController.ListAdminActionsController)),
+	)
+	http.Handle(
+		"GET /api/admin/audit-events",
+		middleware.AdminMiddleware(r.db)(http.HandlerFunc(adminController.ListAuditEventsController)),
 	)
 }
@@ -0,0 +1,273 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"domeal/model"
+	"domeal/notify"
+	"domeal/realtime"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// lockKey は締め切り処理のアドバイザリロックのキー｡1回の処理を1つのレプリカだけが行う
+	lockKey int64 = 40_000_001
+	// tickInterval ごとに締め切りを確認する
+	tickInterval = time.Minute
+	// reminderLead は締め切りのどれだけ前にリマインドを送るか
+	reminderLead = time.Hour
+	// batchSize は1回の処理で扱うグループの上限｡残りは次の回に回す
+	batchSize = 100
+	// jobRetention を過ぎた完了済みのジョブは消す
+	jobRetention = 7 * 24 * time.Hour
+	// jobSweepBatchSize ずつ消して､1つの DELETE が長くロックを持たないようにする
+	jobSweepBatchSize = 1000
+)
+
+// jst は通知の文面に出す時刻のタイムゾーン
+var jst = time.FixedZone("JST", 9*60*60)
+
+// Clock は現在時刻を返す｡テストでは固定した時刻を返すものに差し替える
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock は実際の時刻を返す Clock
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Scheduler は締め切りを過ぎたグループを締め切り､締め切りが近いグループのメンバーにリマインドを送る｡
+// ついでに古くなった完了済みのジョブを消す｡各レプリカで動かしてよい
+type Scheduler struct {
+	repo          model.DeadlineInterface
+	events        realtime.Publisher
+	notifications notify.Enqueuer
+	clock         Clock
+}
+
+func NewScheduler(repo model.DeadlineInterface, events realtime.Publisher, notifications notify.Enqueuer, clock Clock) *Scheduler {
+	return &Scheduler{
+		repo:          repo,
+		events:        events,
+		notifications: notifications,
+		clock:         clock,
+	}
+}
+
+// Run は ctx が終わるまで tickInterval ごとに Tick を呼ぶ
+func (s *Scheduler) Run(ctx context.Context) {
+	slog.Info("Starting deadline scheduler", "interval", tickInterval)
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Tick(ctx); err != nil {
+			slog.Error("Deadline scheduler error", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick は1回分の締め切り処理を行う｡ほかのレプリカが処理中なら何もしない｡
+// グループごとに別のトランザクションで処理するので､1つが失敗してもほかは進める
+func (s *Scheduler) Tick(ctx context.Context) error {
+	// ロックはこのトランザクションが終わるまで持ち続ける
+	lockTx, err := s.repo.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer lockTx.Rollback()
+
+	locked, err := s.repo.TryAdvisoryLock(lockTx, lockKey)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		slog.Debug("Deadline scheduler is running on another replica")
+		return nil
+	}
+
+	now := s.clock.Now()
+
+	closingGroupIDs, err := s.repo.ListGroupsPastDeadline(now, batchSize)
+	if err != nil {
+		return err
+	}
+	for _, groupID := range closingGroupIDs {
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			return s.closeGroup(tx, groupID, now)
+		})
+		if err != nil {
+			slog.Error("Failed to close group at deadline", "error", err, "group_id", groupID)
+		}
+	}
+
+	remindingGroupIDs, err := s.repo.ListGroupsToRemind(now, now.Add(reminderLead), batchSize)
+	if err != nil {
+		return err
+	}
+	for _, groupID := range remindingGroupIDs {
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			return s.remindGroup(tx, groupID, now)
+		})
+		if err != nil {
+			slog.Error("Failed to send deadline reminders", "error", err, "group_id", groupID)
+		}
+	}
+
+	if err := s.sweepJobs(now); err != nil {
+		slog.Error("Failed to sweep finished jobs", "error", err)
+	}
+
+	return nil
+}
+
+func (s *Scheduler) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.repo.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// closeGroup は締め切りを過ぎたグループを closed にしてメンバーに知らせる｡
+// 一覧を取ってから締め切りが延ばされたり手で締め切られたりしていれば何もしない
+func (s *Scheduler) closeGroup(tx *sql.Tx, groupID int64, now time.Time) error {
+	group, err := s.repo.LockGroup(tx, groupID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if group.Status != model.GroupStatusOpen || group.Deadline == nil || group.Deadline.After(now) {
+		return nil
+	}
+
+	group.Status = model.GroupStatusClosed
+	if err := s.repo.UpdateGroup(tx, group); err != nil {
+		return err
+	}
+
+	memberIDs, err := s.repo.ListGroupMemberIDs(tx, groupID)
+	if err != nil {
+		return err
+	}
+
+	for _, memberID := range memberIDs {
+		err := s.repo.CreateNotification(tx, &model.Notification{
+			UserID:  memberID,
+			GroupID: &groupID,
+			Kind:    model.NotificationKindGroupClosed,
+			Message: fmt.Sprintf("グループ「%s」は締め切りを過ぎたので募集を終了しました", group.Name),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// 締め切ったら精算額が決まるので､支払う側に知らせる
+	if err := notify.EnqueuePaymentDue(tx, s.notifications, s.repo, group); err != nil {
+		return err
+	}
+
+	events := []realtime.Event{
+		realtime.NewEvent(realtime.EventGroupUpdated, groupID, group),
+		realtime.NewEvent(realtime.EventGroupStatusChanged, groupID, realtime.StatusPayload{
+			Status: group.Status,
+		}),
+	}
+	for _, event := range events {
+		if err := s.events.Record(tx, event); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Group closed at deadline", "group_id", groupID, "deadline", *group.Deadline)
+	return nil
+}
+
+// remindGroup は締め切りが近いグループのメンバーにリマインドを送る｡1つの締め切りにつき1回だけ送る
+func (s *Scheduler) remindGroup(tx *sql.Tx, groupID int64, now time.Time) error {
+	group, err := s.repo.LockGroup(tx, groupID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if group.Status != model.GroupStatusOpen || group.Deadline == nil || !group.Deadline.After(now) {
+		return nil
+	}
+
+	reminded, err := s.repo.MarkDeadlineReminded(tx, groupID, now)
+	if err != nil || !reminded {
+		return err
+	}
+
+	memberIDs, err := s.repo.ListGroupMemberIDs(tx, groupID)
+	if err != nil {
+		return err
+	}
+
+	for _, memberID := range memberIDs {
+		err := s.notifications.Enqueue(tx, notify.Message{
+			UserID: memberID,
+			Kind:   notify.KindDeadlineApproaching,
+			Params: map[string]any{
+				"group_name": group.Name,
+				"deadline":   formatDeadline(*group.Deadline),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Deadline reminders enqueued", "group_id", groupID, "members", len(memberIDs))
+	return nil
+}
+
+// sweepJobs は jobRetention より前に完了したジョブを jobSweepBatchSize ずつ消す
+func (s *Scheduler) sweepJobs(now time.Time) error {
+	var total int64
+	for {
+		removed, err := s.repo.DeleteFinishedJobs(now.Add(-jobRetention), jobSweepBatchSize)
+		if err != nil {
+			return err
+		}
+		total += removed
+		if removed < jobSweepBatchSize {
+			break
+		}
+	}
+
+	if total > 0 {
+		slog.Info("Swept finished jobs", "count", total)
+	}
+	return nil
+}
+
+// formatDeadline は通知の文面に出す締め切りの時刻
+func formatDeadline(t time.Time) string {
+	return t.In(jst).Format("1月2日 15:04")
+}
@@ -0,0 +1,238 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"domeal/model"
+	"domeal/notify"
+	"domeal/realtime"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fixedClock はいつも同じ時刻を返す
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
+
+// fakeRepo はトランザクションを使わずにメモリ上でグループを持つ
+type fakeRepo struct {
+	groups        map[int64]*model.Group
+	reminded      map[int64]time.Time
+	members       map[int64][]int64
+	notifications []model.Notification
+	settlement    *model.Settlement
+	finishedJobs  []time.Time
+}
+
+func (r *fakeRepo) TryAdvisoryLock(tx *sql.Tx, key int64) (bool, error) {
+	return true, nil
+}
+
+func (r *fakeRepo) ListGroupsPastDeadline(now time.Time, limit int) ([]int64, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (r *fakeRepo) ListGroupsToRemind(now, until time.Time, limit int) ([]int64, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (r *fakeRepo) LockGroup(tx *sql.Tx, groupID int64) (*model.Group, error) {
+	group, ok := r.groups[groupID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *group
+	return &copied, nil
+}
+
+func (r *fakeRepo) UpdateGroup(tx *sql.Tx, group *model.Group) error {
+	copied := *group
+	r.groups[group.ID] = &copied
+	return nil
+}
+
+func (r *fakeRepo) MarkDeadlineReminded(tx *sql.Tx, groupID int64, remindedAt time.Time) (bool, error) {
+	if _, ok := r.reminded[groupID]; ok {
+		return false, nil
+	}
+	r.reminded[groupID] = remindedAt
+	return true, nil
+}
+
+func (r *fakeRepo) ListGroupMemberIDs(tx *sql.Tx, groupID int64) ([]int64, error) {
+	return r.members[groupID], nil
+}
+
+func (r *fakeRepo) CreateNotification(tx *sql.Tx, notification *model.Notification) error {
+	r.notifications = append(r.notifications, *notification)
+	return nil
+}
+
+func (r *fakeRepo) GetSettlement(groupID int64, method model.SplitMethod) (*model.Settlement, error) {
+	if r.settlement == nil {
+		return nil, model.ErrNoParticipants
+	}
+	return r.settlement, nil
+}
+
+func (r *fakeRepo) ListGroupPayPalMeUsernames(groupID int64) (map[int64]string, error) {
+	return map[int64]string{}, nil
+}
+
+func (r *fakeRepo) DeleteFinishedJobs(before time.Time, limit int) (int64, error) {
+	var removed int64
+	kept := r.finishedJobs[:0]
+	for _, finishedAt := range r.finishedJobs {
+		if finishedAt.Before(before) && removed < int64(limit) {
+			removed++
+			continue
+		}
+		kept = append(kept, finishedAt)
+	}
+	r.finishedJobs = kept
+	return removed, nil
+}
+
+func (r *fakeRepo) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return nil, errors.New("not implemented")
+}
+
+// fakePublisher は記録されたイベントを覚えておく
+type fakePublisher struct {
+	events []realtime.Event
+}
+
+func (p *fakePublisher) Record(tx *sql.Tx, event realtime.Event) error {
+	p.events = append(p.events, event)
+	return nil
+}
+
+// fakeEnqueuer は積まれた通知を覚えておく
+type fakeEnqueuer struct {
+	messages []notify.Message
+}
+
+func (e *fakeEnqueuer) Enqueue(tx *sql.Tx, message notify.Message) error {
+	e.messages = append(e.messages, message)
+	return nil
+}
+
+func newTestScheduler(now time.Time, groups ...*model.Group) (*Scheduler, *fakeRepo, *fakePublisher, *fakeEnqueuer) {
+	repo := &fakeRepo{
+		groups:   map[int64]*model.Group{},
+		reminded: map[int64]time.Time{},
+		members:  map[int64][]int64{},
+	}
+	for _, group := range groups {
+		repo.groups[group.ID] = group
+		repo.members[group.ID] = []int64{10, 20}
+	}
+
+	events := &fakePublisher{}
+	notifications := &fakeEnqueuer{}
+	return NewScheduler(repo, events, notifications, fixedClock{now: now}), repo, events, notifications
+}
+
+func TestCloseGroup(t *testing.T) {
+	now := time.Date(2025, 4, 1, 3, 0, 0, 0, time.UTC)
+	passed := now.Add(-time.Minute)
+	upcoming := now.Add(time.Minute)
+
+	s, repo, events, notifications := newTestScheduler(now,
+		&model.Group{ID: 1, Name: "ランチ", Status: model.GroupStatusOpen, Deadline: &passed},
+		&model.Group{ID: 2, Name: "ディナー", Status: model.GroupStatusOpen, Deadline: &upcoming},
+	)
+	repo.settlement = &model.Settlement{
+		Shares:    []model.SettlementShare{{UserID: 10, DisplayName: "たろう"}, {UserID: 20, DisplayName: "はなこ"}},
+		Transfers: []model.Transfer{{FromUserID: 20, ToUserID: 10, Amount: 800}},
+	}
+
+	for _, groupID := range []int64{1, 2, 3} {
+		if err := s.closeGroup(nil, groupID, s.clock.Now()); err != nil {
+			t.Fatalf("closeGroup(%d) error = %v", groupID, err)
+		}
+	}
+
+	if repo.groups[1].Status != model.GroupStatusClosed {
+		t.Errorf("group 1 status = %q, want closed", repo.groups[1].Status)
+	}
+	// 一覧を取った後で締め切りが延ばされた
+	if repo.groups[2].Status != model.GroupStatusOpen {
+		t.Errorf("group 2 status = %q, want open", repo.groups[2].Status)
+	}
+
+	if len(repo.notifications) != 2 || repo.notifications[0].Kind != model.NotificationKindGroupClosed {
+		t.Errorf("notifications = %+v", repo.notifications)
+	}
+	if len(notifications.messages) != 1 || notifications.messages[0].Kind != notify.KindPaymentDue || notifications.messages[0].UserID != 20 {
+		t.Errorf("messages = %+v", notifications.messages)
+	}
+	if len(events.events) != 2 || events.events[1].Type != realtime.EventGroupStatusChanged || events.events[1].GroupID != 1 {
+		t.Errorf("events = %+v", events.events)
+	}
+
+	// もう締め切ったグループは何もしない
+	if err := s.closeGroup(nil, 1, s.clock.Now()); err != nil {
+		t.Fatalf("closeGroup(1) error = %v", err)
+	}
+	if len(events.events) != 2 {
+		t.Errorf("closing twice recorded %d events, want 2", len(events.events))
+	}
+}
+
+func TestRemindGroup(t *testing.T) {
+	now := time.Date(2025, 4, 1, 3, 0, 0, 0, time.UTC)
+	deadline := now.Add(30 * time.Minute)
+	passed := now.Add(-time.Minute)
+
+	s, repo, _, notifications := newTestScheduler(now,
+		&model.Group{ID: 1, Name: "ランチ", Status: model.GroupStatusOpen, Deadline: &deadline},
+		&model.Group{ID: 2, Name: "ディナー", Status: model.GroupStatusOpen, Deadline: &passed},
+		&model.Group{ID: 3, Name: "朝ごはん", Status: model.GroupStatusClosed, Deadline: &deadline},
+	)
+
+	for _, groupID := range []int64{1, 1, 2, 3} {
+		if err := s.remindGroup(nil, groupID, s.clock.Now()); err != nil {
+			t.Fatalf("remindGroup(%d) error = %v", groupID, err)
+		}
+	}
+
+	if len(repo.reminded) != 1 || !repo.reminded[1].Equal(now) {
+		t.Errorf("reminded = %v, want {1: %v}", repo.reminded, now)
+	}
+
+	if len(notifications.messages) != 2 {
+		t.Fatalf("messages = %+v, want 2", notifications.messages)
+	}
+	for _, message := range notifications.messages {
+		if message.Kind != notify.KindDeadlineApproaching || message.Params["deadline"] != "4月1日 12:30" {
+			t.Errorf("message = %+v", message)
+		}
+	}
+}
+
+func TestSweepJobs(t *testing.T) {
+	now := time.Date(2025, 4, 1, 3, 0, 0, 0, time.UTC)
+	scheduler, repo, _, _ := newTestScheduler(now)
+
+	// 1回では消しきれない数の古いジョブと､まだ残すジョブ
+	for i := 0; i < jobSweepBatchSize+5; i++ {
+		repo.finishedJobs = append(repo.finishedJobs, now.Add(-jobRetention-time.Minute))
+	}
+	recent := now.Add(-jobRetention + time.Minute)
+	repo.finishedJobs = append(repo.finishedJobs, recent)
+
+	if err := scheduler.sweepJobs(now); err != nil {
+		t.Fatalf("sweepJobs() unexpected error: %v", err)
+	}
+
+	if len(repo.finishedJobs) != 1 || !repo.finishedJobs[0].Equal(recent) {
+		t.Errorf("finished jobs after sweep = %v, want only %v", repo.finishedJobs, recent)
+	}
+}
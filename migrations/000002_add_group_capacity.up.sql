@@ -0,0 +1,11 @@
+ALTER TABLE groups
+    ADD COLUMN max_members INT CHECK (max_members IS NULL OR max_members > 0);
+
+-- 定員オーバーで参加できなかったユーザーの待ちリスト
+CREATE TABLE group_waitlist (
+    id SERIAL PRIMARY KEY,
+    group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    UNIQUE(group_id, user_id)
+);
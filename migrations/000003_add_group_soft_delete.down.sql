@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS notifications;
+ALTER TABLE groups DROP COLUMN IF EXISTS deleted_at;
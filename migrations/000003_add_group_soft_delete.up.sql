@@ -0,0 +1,15 @@
+ALTER TABLE groups
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- アプリ内のお知らせ
+CREATE TABLE notifications (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    group_id INT REFERENCES groups(id) ON DELETE CASCADE,
+    kind VARCHAR(64) NOT NULL,
+    message TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    read_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX notifications_user_id_idx ON notifications(user_id, created_at DESC);
@@ -0,0 +1,16 @@
+-- グループで購入する料理の一覧
+CREATE TABLE menu_items (
+    id SERIAL PRIMARY KEY,
+    group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    -- 1単位あたりの価格(円)
+    price INT NOT NULL CHECK (price >= 0),
+    -- 購入予定の数量
+    quantity INT NOT NULL DEFAULT 1 CHECK (quantity > 0),
+    unit VARCHAR(32) NOT NULL,
+    image_url TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE INDEX menu_items_group_id_idx ON menu_items(group_id);
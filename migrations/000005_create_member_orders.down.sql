@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS member_orders;
+ALTER TABLE groups DROP COLUMN IF EXISTS status;
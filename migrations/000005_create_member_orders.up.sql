@@ -0,0 +1,16 @@
+ALTER TABLE groups
+    ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'closed'));
+
+-- メンバーごとの注文｡1メンバー1料理につき1行
+CREATE TABLE member_orders (
+    id SERIAL PRIMARY KEY,
+    group_member_id INT NOT NULL REFERENCES group_members(id) ON DELETE CASCADE,
+    menu_item_id INT NOT NULL REFERENCES menu_items(id) ON DELETE CASCADE,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    note TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    UNIQUE(group_member_id, menu_item_id)
+);
+
+CREATE INDEX member_orders_menu_item_id_idx ON member_orders(menu_item_id);
@@ -0,0 +1,3 @@
+ALTER TABLE group_members DROP COLUMN IF EXISTS fixed_share;
+ALTER TABLE group_members DROP COLUMN IF EXISTS share_weight;
+ALTER TABLE groups DROP COLUMN IF EXISTS split_method;
@@ -0,0 +1,8 @@
+ALTER TABLE groups
+    ADD COLUMN split_method VARCHAR(16) NOT NULL DEFAULT 'items' CHECK (split_method IN ('equal', 'items', 'fixed', 'weighted'));
+
+ALTER TABLE group_members
+    -- weighted のときの比率
+    ADD COLUMN share_weight INT NOT NULL DEFAULT 1 CHECK (share_weight >= 0),
+    -- fixed のときの負担額(円)｡NULLなら残りを均等に負担する
+    ADD COLUMN fixed_share INT CHECK (fixed_share >= 0);
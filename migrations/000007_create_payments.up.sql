@@ -0,0 +1,14 @@
+-- 精算の送金の記録｡送金した側が支払い済みにして､受け取った側が確認する
+CREATE TABLE payments (
+    id SERIAL PRIMARY KEY,
+    group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    payer_id INT NOT NULL REFERENCES users(id),
+    payee_id INT NOT NULL REFERENCES users(id),
+    amount INT NOT NULL CHECK (amount > 0),
+    status VARCHAR(16) NOT NULL CHECK (status IN ('paid', 'confirmed')),
+    paid_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    confirmed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    UNIQUE(group_id, payer_id, payee_id)
+);
@@ -0,0 +1,14 @@
+-- 買い出しなどで実際に立て替えた費用
+CREATE TABLE expenses (
+    id SERIAL PRIMARY KEY,
+    group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    paid_by INT NOT NULL REFERENCES users(id),
+    amount INT NOT NULL CHECK (amount > 0),
+    description VARCHAR(255) NOT NULL,
+    receipt_image_url TEXT,
+    created_by INT NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE INDEX expenses_group_id_idx ON expenses(group_id);
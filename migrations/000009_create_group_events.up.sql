@@ -0,0 +1,10 @@
+-- グループのリアルタイムイベント｡再接続したクライアントが Last-Event-ID から取り直せるように残す
+CREATE TABLE group_events (
+    id BIGSERIAL PRIMARY KEY,
+    group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    type VARCHAR(64) NOT NULL,
+    payload JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE INDEX group_events_group_id_idx ON group_events(group_id, id);
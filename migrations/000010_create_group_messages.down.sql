@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS group_message_reads;
+DROP TABLE IF EXISTS group_messages;
@@ -0,0 +1,21 @@
+-- グループ内のチャット｡削除しても既読位置やカーソルがずれないように行は残す
+CREATE TABLE group_messages (
+    id BIGSERIAL PRIMARY KEY,
+    group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id),
+    body TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    edited_at TIMESTAMP WITH TIME ZONE,
+    deleted_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX group_messages_group_id_idx ON group_messages(group_id, id DESC);
+
+-- メンバーごとの既読位置｡最後に読んだメッセージだけを持つ
+CREATE TABLE group_message_reads (
+    group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    last_read_message_id BIGINT NOT NULL REFERENCES group_messages(id) ON DELETE CASCADE,
+    read_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    PRIMARY KEY (group_id, user_id)
+);
@@ -0,0 +1,25 @@
+-- LINEへのプッシュ通知のアウトボックス｡グループの変更と同じトランザクションで積み､別のワーカーが送る
+CREATE TABLE notification_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(64) NOT NULL,
+    params JSONB NOT NULL DEFAULT '{}',
+    -- LINE の X-Line-Retry-Key｡再送しても二重に届かない
+    retry_key UUID NOT NULL DEFAULT gen_random_uuid(),
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed')),
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    sent_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX notification_outbox_pending_idx ON notification_outbox(next_attempt_at) WHERE status = 'pending';
+
+-- ユーザーが受け取らないことにした通知の種類
+CREATE TABLE notification_opt_outs (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, kind)
+);
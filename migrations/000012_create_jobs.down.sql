@@ -0,0 +1,6 @@
+ALTER TABLE notification_outbox
+    ADD COLUMN attempts INT NOT NULL DEFAULT 0,
+    ADD COLUMN next_attempt_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL;
+CREATE INDEX notification_outbox_pending_idx ON notification_outbox(next_attempt_at) WHERE status = 'pending';
+
+DROP TABLE IF EXISTS jobs;
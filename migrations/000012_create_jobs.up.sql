@@ -0,0 +1,27 @@
+-- コミット後に確実に行いたい処理のキュー｡ワーカーは FOR UPDATE SKIP LOCKED で1件ずつ取り出す
+CREATE TABLE jobs (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(64) NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    -- 同じ種類で同じキーの未実行のジョブは1つにまとめる
+    unique_key VARCHAR(255),
+    -- dead は再試行をあきらめたジョブ｡手で直して pending に戻せば再実行される
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'done', 'dead')),
+    run_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL DEFAULT 10,
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX jobs_pending_idx ON jobs(run_at, id) WHERE status = 'pending';
+CREATE UNIQUE INDEX jobs_unique_key_idx ON jobs(kind, unique_key) WHERE status = 'pending';
+CREATE INDEX jobs_dead_idx ON jobs(updated_at) WHERE status = 'dead';
+
+-- 通知の再送はジョブキューに任せる
+DROP INDEX IF EXISTS notification_outbox_pending_idx;
+ALTER TABLE notification_outbox
+    DROP COLUMN attempts,
+    DROP COLUMN next_attempt_at;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS groups_open_deadline_idx;
+
+ALTER TABLE groups
+    DROP COLUMN IF EXISTS deadline_reminded_at,
+    DROP COLUMN IF EXISTS deadline;
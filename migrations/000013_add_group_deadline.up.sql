@@ -0,0 +1,7 @@
+ALTER TABLE groups
+    -- 募集の締め切り｡過ぎるとスケジューラーが自動で closed にする
+    ADD COLUMN deadline TIMESTAMP WITH TIME ZONE,
+    -- 締め切り前のリマインドを送った時刻｡締め切りを変えたら NULL に戻す
+    ADD COLUMN deadline_reminded_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX groups_open_deadline_idx ON groups(deadline) WHERE status = 'open' AND deleted_at IS NULL;
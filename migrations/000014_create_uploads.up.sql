@@ -0,0 +1,15 @@
+-- ブラウザからストレージに直接アップロードされるファイル｡確定されないまま期限を過ぎたものは掃除する
+CREATE TABLE uploads (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    object_key VARCHAR(255) UNIQUE NOT NULL,
+    content_type VARCHAR(64) NOT NULL,
+    size INT NOT NULL CHECK (size > 0),
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'attached')),
+    group_id INT REFERENCES groups(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    attached_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX uploads_pending_idx ON uploads(expires_at) WHERE status = 'pending';
@@ -0,0 +1,6 @@
+UPDATE groups g
+SET menu_image_url = '/api/blobs/' || m.object_key
+FROM media m
+WHERE g.menu_image_url = '/api/media/' || m.id;
+
+DROP TABLE IF EXISTS media;
@@ -0,0 +1,21 @@
+-- グループのメンバーだけに配信する画像｡groups.menu_image_url には /api/media/{id} を入れる
+CREATE TABLE media (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    object_key VARCHAR(255) UNIQUE NOT NULL,
+    content_type VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE INDEX media_group_id_idx ON media(group_id);
+
+-- ローカルに保存していた画像は /api/media から配信するように付け替える
+INSERT INTO media (group_id, object_key, content_type)
+SELECT id, substring(menu_image_url FROM length('/api/blobs/') + 1), 'image/jpeg'
+FROM groups
+WHERE menu_image_url LIKE '/api/blobs/%';
+
+UPDATE groups g
+SET menu_image_url = '/api/media/' || m.id
+FROM media m
+WHERE m.group_id = g.id AND g.menu_image_url = '/api/blobs/' || m.object_key;
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS display_name_overridden,
+    DROP COLUMN IF EXISTS line_display_name;
@@ -0,0 +1,8 @@
+-- display_name はアプリで表示する名前｡ユーザーが変えていなければログインのたびに LINE の名前に合わせる
+ALTER TABLE users
+    ADD COLUMN line_display_name VARCHAR(255),
+    ADD COLUMN display_name_overridden BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE users SET line_display_name = display_name;
+
+ALTER TABLE users ALTER COLUMN line_display_name SET NOT NULL;
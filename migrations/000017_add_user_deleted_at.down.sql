@@ -0,0 +1,6 @@
+ALTER TABLE group_members
+    DROP CONSTRAINT group_members_user_id_fkey,
+    ADD CONSTRAINT group_members_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS deleted_at;
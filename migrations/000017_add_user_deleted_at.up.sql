@@ -0,0 +1,8 @@
+-- 退会したユーザー｡精算やチャットの記録が他のメンバーに残るように行は消さずに匿名化する
+ALTER TABLE users
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- ユーザーの行は消さないので､誤って消そうとしたらメンバーの記録があるかぎり失敗させる
+ALTER TABLE group_members
+    DROP CONSTRAINT group_members_user_id_fkey,
+    ADD CONSTRAINT group_members_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE RESTRICT;
@@ -0,0 +1,17 @@
+-- LINE から受け取ったメールアドレスは LINE が確認済みなので email_verified_at も入れる
+ALTER TABLE users
+    ADD COLUMN email VARCHAR(254),
+    ADD COLUMN email_verified_at TIMESTAMP WITH TIME ZONE;
+
+-- メールアドレスの追加や変更の確認｡トークンはハッシュだけを保存する
+CREATE TABLE email_verifications (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(254) NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX email_verifications_user_id_idx ON email_verifications(user_id);
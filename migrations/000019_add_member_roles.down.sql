@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS group_members_owner_idx;
+
+ALTER TABLE group_members
+    ADD COLUMN is_owner BOOLEAN DEFAULT FALSE;
+
+-- 幹事や見るだけのメンバーは普通のメンバーに戻る
+UPDATE group_members SET is_owner = (role = 'owner');
+
+ALTER TABLE group_members
+    DROP COLUMN IF EXISTS role;
@@ -0,0 +1,13 @@
+-- オーナーだけが管理できた is_owner を役割に置き換えて､幹事を任せられるようにする｡
+-- 役割ごとにできることは api/authz で決める
+ALTER TABLE group_members
+    ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'member'
+        CONSTRAINT group_members_role_check CHECK (role IN ('owner', 'organiser', 'member', 'viewer'));
+
+UPDATE group_members SET role = 'owner' WHERE is_owner;
+
+ALTER TABLE group_members
+    DROP COLUMN is_owner;
+
+-- オーナーはグループに1人だけ｡譲るときは前のオーナーの役割を先に変える
+CREATE UNIQUE INDEX group_members_owner_idx ON group_members(group_id) WHERE role = 'owner';
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS admin_actions;
+
+DROP FUNCTION IF EXISTS reject_append_only_change();
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS is_admin;
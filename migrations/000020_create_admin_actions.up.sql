@@ -0,0 +1,28 @@
+-- 運営者｡/api/admin を使える｡付け外しは psql で行う
+ALTER TABLE users
+    ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- 運営者の操作の記録｡検索でも個人情報を見るので､変更しない操作も残す
+CREATE TABLE admin_actions (
+    id BIGSERIAL PRIMARY KEY,
+    admin_user_id INT NOT NULL REFERENCES users(id),
+    action VARCHAR(64) NOT NULL,
+    target_type VARCHAR(32),
+    target_id BIGINT,
+    detail JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE INDEX admin_actions_admin_user_id_idx ON admin_actions(admin_user_id, id);
+CREATE INDEX admin_actions_target_idx ON admin_actions(target_type, target_id, id);
+
+-- 記録は書き足すだけにして､あとから書き換えたり消したりできないようにする
+CREATE FUNCTION reject_append_only_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER admin_actions_append_only
+    BEFORE UPDATE OR DELETE ON admin_actions
+    FOR EACH ROW EXECUTE FUNCTION reject_append_only_change();
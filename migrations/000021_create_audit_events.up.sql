@@ -0,0 +1,23 @@
+-- 誰がいつ何をしたかの記録｡変更と同じトランザクションで書き足す｡
+-- 退会したユーザーも匿名化するだけなので､ID から誰の操作だったかはたどれる
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    action VARCHAR(64) NOT NULL,
+    -- 操作した人｡スケジューラーなどシステムの操作なら NULL
+    actor_user_id INT REFERENCES users(id),
+    group_id INT REFERENCES groups(id),
+    -- 操作された人｡役割を変えられたメンバーや支払った人など
+    subject_user_id INT REFERENCES users(id),
+    detail JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE INDEX audit_events_group_id_idx ON audit_events(group_id, id);
+CREATE INDEX audit_events_actor_user_id_idx ON audit_events(actor_user_id, id);
+CREATE INDEX audit_events_subject_user_id_idx ON audit_events(subject_user_id, id);
+CREATE INDEX audit_events_action_idx ON audit_events(action, id);
+
+-- admin_actions と同じく書き足すだけにする
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION reject_append_only_change();
@@ -0,0 +1,11 @@
+-- レプリカが複数あるときのレート制限のトークンバケット｡RATE_LIMIT_STORE=postgres のときだけ使う
+CREATE TABLE rate_limit_buckets (
+    -- 制限の名前と IP やユーザーID を組み合わせたキー
+    key VARCHAR(255) PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    -- この時刻を過ぎるとバケットが満タンに戻るので､行を消しても同じになる
+    full_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX rate_limit_buckets_full_at_idx ON rate_limit_buckets(full_at);
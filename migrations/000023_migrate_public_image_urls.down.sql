@@ -0,0 +1,2 @@
+-- 外部のURLは書き換えていないので戻すものはない｡
+-- 付け替えた /api/media/{id} は media の行が残っているのでそのまま使える
@@ -0,0 +1,37 @@
+-- 以前はストレージの公開URL (S3_PUBLIC_URL/menu/{id}.jpg) をそのまま保存していた｡
+-- 保存した画像は media に登録して /api/media から配信する｡外部のURLはこちらの画像ではないのでそのまま残す
+INSERT INTO media (group_id, object_key, content_type)
+SELECT id, substring(menu_image_url FROM '(menu/[0-9a-f]{32}\.jpg)$'), 'image/jpeg'
+FROM groups
+WHERE menu_image_url ~ '^https?://.*/menu/[0-9a-f]{32}\.jpg$'
+UNION
+SELECT group_id, substring(image_url FROM '(menu/[0-9a-f]{32}\.jpg)$'), 'image/jpeg'
+FROM menu_items
+WHERE image_url ~ '^https?://.*/menu/[0-9a-f]{32}\.jpg$'
+UNION
+SELECT group_id, substring(receipt_image_url FROM '(menu/[0-9a-f]{32}\.jpg)$'), 'image/jpeg'
+FROM expenses
+WHERE receipt_image_url ~ '^https?://.*/menu/[0-9a-f]{32}\.jpg$'
+ON CONFLICT (object_key) DO NOTHING;
+
+-- 別のグループで登録された画像はそのグループのメンバーにしか見えないので付け替えない
+UPDATE groups g
+SET menu_image_url = '/api/media/' || m.id
+FROM media m
+WHERE g.menu_image_url ~ '^https?://'
+    AND m.group_id = g.id
+    AND m.object_key = substring(g.menu_image_url FROM '(menu/[0-9a-f]{32}\.jpg)$');
+
+UPDATE menu_items i
+SET image_url = '/api/media/' || m.id
+FROM media m
+WHERE i.image_url ~ '^https?://'
+    AND m.group_id = i.group_id
+    AND m.object_key = substring(i.image_url FROM '(menu/[0-9a-f]{32}\.jpg)$');
+
+UPDATE expenses e
+SET receipt_image_url = '/api/media/' || m.id
+FROM media m
+WHERE e.receipt_image_url ~ '^https?://'
+    AND m.group_id = e.group_id
+    AND m.object_key = substring(e.receipt_image_url FROM '(menu/[0-9a-f]{32}\.jpg)$');
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS jobs_done_idx;
@@ -0,0 +1,2 @@
+-- 完了済みのジョブを古い順に消すのに使う
+CREATE INDEX jobs_done_idx ON jobs(finished_at, id) WHERE status = 'done';